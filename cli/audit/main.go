@@ -0,0 +1,189 @@
+/*
+Copyright © 2023 Alexandre Pires
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/a13labs/cobot/cli"
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/spf13/cobra"
+)
+
+var listCount int
+var exportFormat string
+var exportOutput string
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the audit log of the executed actions",
+	Long: `Inspect the audit log kept in local/audit.log. Every action the agent was
+	asked to run is recorded with the requester, the rendered parameters and the
+	result. Records are hash-chained, use verify to detect tampering.`,
+}
+
+var auditListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the last audit records",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		records := readRecords()
+		if listCount > 0 && len(records) > listCount {
+			records = records[len(records)-listCount:]
+		}
+		for i := range records {
+			fmt.Println(records[i].String())
+		}
+	},
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the hash chain of the audit log",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		storage := openStorage()
+
+		count, head, err := agent.VerifyAuditLog(storage)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("%d record(s) verified, head %s\n", count, head)
+	},
+}
+
+var auditExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the audit log as JSON or CSV",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		records := readRecords()
+
+		var out io.Writer = os.Stdout
+		if exportOutput != "" {
+			f, err := os.Create(exportOutput)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+
+		var err error
+		switch exportFormat {
+		case "json":
+			err = exportJSON(out, records)
+		case "csv":
+			err = exportCSV(out, records)
+		default:
+			err = fmt.Errorf("unknown export format '%s', use json or csv", exportFormat)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func openStorage() agent.Storage {
+	storage, err := cli.OpenStorage()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	return storage
+}
+
+func readRecords() []agent.AuditRecord {
+	records, err := agent.ReadAuditLog(openStorage())
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	return records
+}
+
+func exportJSON(out io.Writer, records []agent.AuditRecord) error {
+	if records == nil {
+		records = []agent.AuditRecord{}
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+func exportCSV(out io.Writer, records []agent.AuditRecord) error {
+	w := csv.NewWriter(out)
+	header := []string{"seq", "time", "channel", "user", "message", "action", "parameters", "result", "output", "error", "duration_ms", "prev_hash", "hash"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, r := range records {
+		params, err := json.Marshal(r.Parameters)
+		if err != nil {
+			return err
+		}
+		row := []string{
+			strconv.FormatInt(r.Seq, 10),
+			r.Time.Format(time.RFC3339Nano),
+			r.Channel,
+			r.User,
+			r.Message,
+			r.Action,
+			string(params),
+			r.Result,
+			r.Output,
+			r.Error,
+			strconv.FormatInt(r.DurationMs, 10),
+			r.PrevHash,
+			r.Hash,
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func init() {
+
+	cli.RootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditListCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+	auditCmd.AddCommand(auditExportCmd)
+	auditListCmd.Flags().IntVarP(&listCount, "count", "n", 20, "Number of records to show, 0 for all")
+	auditExportCmd.Flags().StringVarP(&exportFormat, "format", "f", "json", "Export format, json or csv")
+	auditExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Output file, stdout when empty")
+}
@@ -0,0 +1,260 @@
+/*
+Copyright © 2023 Alexandre Pires
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/a13labs/cobot/internal/metrics"
+	"github.com/a13labs/cobot/internal/tracing"
+	"github.com/sevlyar/go-daemon"
+)
+
+// TraceFile receives the traces in the storage when no trace file is given
+const TraceFile = "local/traces.json"
+
+// Time given to the exporter to send the last traces
+const traceFlushTimeout = 5 * time.Second
+
+// ChannelFactory creates a channel from the command line flags
+type ChannelFactory func() (agent.Channel, error)
+
+var channelFactories = map[string]ChannelFactory{}
+
+// RegisterChannel makes a channel available to the serve command, it is called
+// from the init function of the channel commands.
+func RegisterChannel(name string, factory ChannelFactory) {
+	if _, exist := channelFactories[name]; exist {
+		panic(fmt.Sprintf("channel %s already registered", name))
+	}
+	channelFactories[name] = factory
+}
+
+// ChannelNames returns the names of the registered channels, sorted
+func ChannelNames() []string {
+	names := make([]string, 0, len(channelFactories))
+	for name := range channelFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewChannel creates the registered channel with the given name
+func NewChannel(name string) (agent.Channel, error) {
+	factory, exist := channelFactories[name]
+	if !exist {
+		return nil, fmt.Errorf("%w: %s", agent.ErrChannelNotFound, name)
+	}
+	return factory()
+}
+
+// CheckRole returns an error when the role given to the users of a channel is
+// not valid
+func CheckRole(channel string, role string) error {
+	if !agent.ValidRole(role) {
+		return fmt.Errorf("The %s channel role '%s' is not valid, valid roles: %s, %s, %s, aborting.", channel, role, agent.RoleViewer, agent.RoleOperator, agent.RoleAdmin)
+	}
+	return nil
+}
+
+// RunChannels attaches the given channels to the agent and runs them until one
+// of them stops or the process is interrupted, the other channels are then
+// stopped and the agent is shut down, the requests being processed are given
+// the drain timeout to finish. SIGHUP reloads the configuration. The first error
+// returned by a channel is returned.
+func RunChannels(channels ...agent.Channel) error {
+
+	logger := agent.GetLogger()
+
+	if pidFile != "" {
+		lock, err := lockPidFile(pidFile)
+		if err != nil {
+			return err
+		}
+		defer lock.Remove()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGQUIT, syscall.SIGTERM)
+	defer stop()
+
+	for _, channel := range channels {
+		if err := AgentCtx.AddChannel(channel); err != nil {
+			return err
+		}
+	}
+
+	if metricsListen != "" {
+		if err := serveMetrics(ctx, metricsListen); err != nil {
+			return err
+		}
+	}
+
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.Error("Error exporting the traces: %s", err)
+		}
+	}()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	go func() {
+		for {
+			select {
+			case <-hangup:
+				if err := AgentCtx.RequestReload(); err != nil {
+					logger.Error("Error reloading the configuration: %s", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	done := make(chan error, len(channels))
+	for _, channel := range channels {
+		go func(channel agent.Channel) {
+			err := channel.Start(ctx, AgentCtx)
+			if err != nil {
+				err = fmt.Errorf("channel %s: %w", channel.Name(), err)
+			}
+			done <- err
+		}(channel)
+	}
+
+	// Wait for the first channel to stop, then stop the others
+	var firstErr error
+	for range channels {
+		err := <-done
+		stop()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if len(AgentCtx.Jobs()) > 0 {
+		logger.Info("Waiting for the actions being executed to finish")
+	}
+	if err := AgentCtx.Shutdown(drainCtx); err != nil {
+		logger.Warning("%s", err)
+	}
+	return firstErr
+}
+
+// setupTracing exports the traces as selected by the global flags, the
+// returned function flushes the last ones
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+
+	logger := agent.GetLogger()
+
+	options := tracing.Options{
+		Exporter: traceExporter,
+		Endpoint: traceEndpoint,
+		Insecure: traceInsecure,
+		File:     traceFile,
+	}
+	if options.File == "" {
+		options.File = filepath.Join(storagePath, TraceFile)
+	}
+	shutdown, err := tracing.Setup(ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+	switch options.Exporter {
+	case tracing.ExporterOTLP, tracing.ExporterStdout:
+		logger.Info("Traces exported with %s", options.Exporter)
+	case tracing.ExporterFile:
+		logger.Info("Traces exported to %s", options.File)
+	}
+	return shutdown, nil
+}
+
+// serveMetrics serves the Prometheus metrics on the given address until the
+// context is done
+func serveMetrics(ctx context.Context, address string) error {
+
+	logger := agent.GetLogger()
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Error serving the metrics: %s", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	logger.Info("Metrics served on http://%s/metrics", listener.Addr())
+	return nil
+}
+
+// lockPidFile writes the process id to the given file and locks it, the lock
+// is held until the process exits so a stale file never blocks a new agent
+func lockPidFile(path string) (*daemon.LockFile, error) {
+	lock, err := daemon.OpenLockFile(path, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lock.Lock(); err != nil {
+		// The file belongs to the running agent, it must not be removed
+		lock.Close()
+		if errors.Is(err, daemon.ErrWouldBlock) {
+			return nil, fmt.Errorf("an agent is already running, see %s", path)
+		}
+		return nil, err
+	}
+	if err := lock.WritePid(); err != nil {
+		lock.Remove()
+		return nil, err
+	}
+	return lock, nil
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+// The helper process locking the pid file given in this variable
+const lockHelperEnv = "COBOT_TEST_LOCK_PID_FILE"
+
+func TestLockPidFileHelper(t *testing.T) {
+	path := os.Getenv(lockHelperEnv)
+	if path == "" {
+		t.Skip("only run as a helper process")
+	}
+	if _, err := lockPidFile(path); err != nil {
+		os.Exit(1)
+	}
+	// The lock is left to the exit of the process
+	os.Exit(0)
+}
+
+func TestLockPidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cobot.pid")
+
+	lock, err := lockPidFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockPidFile(path); err == nil {
+		t.Errorf("a second agent was allowed to run")
+	}
+	if err := lock.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A process exiting without removing its pid file releases the lock
+	helper := exec.Command(os.Args[0], "-test.run=^TestLockPidFileHelper$")
+	helper.Env = append(os.Environ(), lockHelperEnv+"="+path)
+	if output, err := helper.CombinedOutput(); err != nil {
+		t.Fatalf("helper process error = %v: %s", err, output)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("the pid file of the helper process is missing: %v", err)
+	}
+	lock, err = lockPidFile(path)
+	if err != nil {
+		t.Fatalf("the stale pid file blocks the agent: %v", err)
+	}
+	defer lock.Remove()
+}
+
+// idleChannel runs until the context is done
+type idleChannel struct {
+	started chan struct{}
+}
+
+func (c *idleChannel) Name() string { return "idle" }
+
+func (c *idleChannel) Start(ctx context.Context, agentCtx *agent.AgentCtx) error {
+	close(c.started)
+	<-ctx.Done()
+	return nil
+}
+
+func (c *idleChannel) Send(conversation string, text string) error { return nil }
+
+func (c *idleChannel) Capabilities() agent.ChannelCapabilities {
+	return agent.ChannelCapabilities{}
+}
+
+func TestHangupReloadsTheConfiguration(t *testing.T) {
+	storage := agent.NewMemStorageFromMap(map[string]string{"agent-config.yaml": "agent:\n  name: before\n"})
+	AgentCtx = &agent.AgentCtx{Storage: storage}
+	if err := AgentCtx.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { AgentCtx = nil }()
+
+	channel := &idleChannel{started: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() { done <- RunChannels(channel) }()
+
+	// The signals are handled once the channels are started
+	select {
+	case <-channel.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the channel was not started")
+	}
+
+	if err := storage.WriteFile("agent-config.yaml", []byte("agent:\n  name: after\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for AgentCtx.GetAgentName() != "after" {
+		if time.Now().After(deadline) {
+			t.Fatal("the configuration was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("RunChannels() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGTERM did not stop the channels")
+	}
+}
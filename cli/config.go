@@ -0,0 +1,271 @@
+/*
+Copyright © 2023 Alexandre Pires
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cli
+
+/*
+	Every flag can also be set in a config file or with an environment
+	variable, the value of a setting is taken from the first of:
+	- the command line flag
+	- the COBOT_<KEY> environment variable, e.g. COBOT_LLM_HOST
+	- the config file, YAML or TOML, given with --config or COBOT_CONFIG or
+	  found as cobot.yaml or cobot.toml in the current folder, in the user
+	  config folder (~/.config/cobot) or in /etc/cobot
+	- the default of the flag
+
+	The key of a setting is the name of its flag on the root and serve commands,
+	e.g. llm-host or telegram-token. The channel commands map their own flags
+	to the same keys, --token of the telegram command is telegram-token.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Sources of the settings
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// EnvPrefix is the prefix of the environment variables of the settings
+const EnvPrefix = "COBOT_"
+
+// ConfigEnv holds the config file path when --config is not given
+const ConfigEnv = EnvPrefix + "CONFIG"
+
+// ConfigName is the name of the config file searched, without extension
+const ConfigName = "cobot"
+
+// Setting is the effective value of a setting
+type Setting struct {
+	Key   string
+	Value string
+	// Source is where the value comes from, default, file, env or flag
+	Source string
+	// Origin is the config file or the environment variable the value is read
+	// from
+	Origin string
+}
+
+var configFile string
+
+// configUsed is the path of the config file read, empty when there is none
+var configUsed string
+
+var configPrefixes = map[*cobra.Command]string{}
+var envAliases = map[string][]string{}
+
+// BindConfig makes the local flags of a command configurable, the key of a
+// flag is its name with the given prefix
+func BindConfig(cmd *cobra.Command, prefix string) {
+	configPrefixes[cmd] = prefix
+}
+
+// BindEnv adds environment variables to the ones a setting is read from, they
+// are used after the COBOT_<KEY> variable
+func BindEnv(key string, names ...string) {
+	envAliases[key] = append(envAliases[key], names...)
+}
+
+// EnvName returns the environment variable of a setting
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// ConfigFileUsed returns the path of the config file read, empty when there
+// is none
+func ConfigFileUsed() string {
+	return configUsed
+}
+
+// loadConfig sets the flags of the command not given on the command line from
+// the environment and the config file
+func loadConfig(cmd *cobra.Command, args []string) {
+	if _, err := applyConfig(cmd); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
+
+// Settings returns the effective value of the settings of the root and serve
+// commands, sorted by key
+func Settings() ([]Setting, error) {
+	v, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := resolveFlags(v, RootCmd.PersistentFlags(), "")
+	if err != nil {
+		return nil, err
+	}
+	served, err := resolveFlags(v, ServeCmd.Flags(), "")
+	if err != nil {
+		return nil, err
+	}
+	settings = append(settings, served...)
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+	return settings, nil
+}
+
+// applyConfig reads the config file and resolves the root flags and the local
+// flags of the given command
+func applyConfig(cmd *cobra.Command) (*viper.Viper, error) {
+	v, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := resolveFlags(v, RootCmd.PersistentFlags(), ""); err != nil {
+		return nil, err
+	}
+	if prefix, ok := configPrefixes[cmd]; ok {
+		if _, err := resolveFlags(v, cmd.LocalFlags(), prefix); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// readConfig reads the given or the discovered config file, it is not an
+// error when no config file is found
+func readConfig() (*viper.Viper, error) {
+	v := viper.New()
+
+	path := configFile
+	if path == "" {
+		path = os.Getenv(ConfigEnv)
+	}
+	if path != "" {
+		v.SetConfigFile(path)
+	} else {
+		v.SetConfigName(ConfigName)
+		v.AddConfigPath(".")
+		if dir, err := os.UserConfigDir(); err == nil {
+			v.AddConfigPath(filepath.Join(dir, ConfigName))
+		}
+		v.AddConfigPath("/etc/cobot")
+	}
+
+	if err := v.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if path != "" || !errors.As(err, &notFound) {
+			return nil, fmt.Errorf("error reading the config file: %w", err)
+		}
+	}
+	configUsed = v.ConfigFileUsed()
+	if configUsed != "" {
+		if abs, err := filepath.Abs(configUsed); err == nil {
+			configUsed = abs
+		}
+	}
+	return v, nil
+}
+
+// resolveFlags sets the flags not given on the command line from the
+// environment or the config file, the flags keep the changed state of the
+// command line
+func resolveFlags(v *viper.Viper, flags *pflag.FlagSet, prefix string) ([]Setting, error) {
+	var settings []Setting
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Name == "config" || flag.Name == "help" {
+			return
+		}
+		var setting Setting
+		setting, err = resolveFlag(v, flag, prefix+flag.Name)
+		settings = append(settings, setting)
+	})
+	return settings, err
+}
+
+func resolveFlag(v *viper.Viper, flag *pflag.Flag, key string) (Setting, error) {
+
+	setting := Setting{Key: key, Source: SourceDefault}
+
+	var value interface{}
+	switch {
+	case flag.Changed:
+		setting.Source = SourceFlag
+	case lookupEnv(key) != "":
+		setting.Origin = lookupEnv(key)
+		setting.Source = SourceEnv
+		value = os.Getenv(setting.Origin)
+	case v.InConfig(key):
+		setting.Origin = v.ConfigFileUsed()
+		setting.Source = SourceFile
+		value = v.Get(key)
+	}
+
+	if value != nil {
+		if err := setFlag(flag, value); err != nil {
+			return setting, fmt.Errorf("invalid value for %s in %s: %w", key, setting.Origin, err)
+		}
+	}
+	setting.Value = flag.Value.String()
+	return setting, nil
+}
+
+// lookupEnv returns the first environment variable set for a setting, empty
+// when none is set
+func lookupEnv(key string) string {
+	for _, name := range append([]string{EnvName(key)}, envAliases[key]...) {
+		if os.Getenv(name) != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// setFlag sets the value of a flag without marking it as given on the command
+// line, the values of the list flags are replaced, given as a list or comma
+// separated
+func setFlag(flag *pflag.Flag, value interface{}) error {
+	slice, isSlice := flag.Value.(pflag.SliceValue)
+
+	var items []string
+	switch value := value.(type) {
+	case []interface{}:
+		for _, item := range value {
+			items = append(items, fmt.Sprint(item))
+		}
+	default:
+		if !isSlice {
+			return flag.Value.Set(fmt.Sprint(value))
+		}
+		items = strings.Split(fmt.Sprint(value), ",")
+	}
+
+	if isSlice {
+		return slice.Replace(items)
+	}
+	return flag.Value.Set(strings.Join(items, ","))
+}
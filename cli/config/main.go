@@ -0,0 +1,101 @@
+/*
+Copyright © 2023 Alexandre Pires
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/a13labs/cobot/cli"
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/spf13/cobra"
+)
+
+// Words of the keys of the settings holding secrets, they are not shown
+var secretKeys = []string{"token", "password", "secret"}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show the configuration of the agent",
+	Long: `Every setting can be given with its flag, the COBOT_<KEY> environment
+	variable or the config file, in this order of precedence. The config file is
+	a YAML or TOML file given with --config or COBOT_CONFIG, or found as
+	cobot.yaml or cobot.toml in the current folder, ~/.config/cobot or
+	/etc/cobot. The keys are the names of the flags of the serve command, e.g.:
+
+	llm-host: ollama.lan
+	llm-model: mistral
+	channel: [telegram, control]
+	telegram-chat: -1001234567890`,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective settings and where they come from",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		settings, err := cli.Settings()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		if file := cli.ConfigFileUsed(); file != "" {
+			fmt.Printf("Config file: %s\n\n", file)
+		} else {
+			fmt.Printf("Config file: none\n\n")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, setting := range settings {
+			source := setting.Source
+			if setting.Origin != "" {
+				source = fmt.Sprintf("%s (%s)", setting.Source, setting.Origin)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Key, displayValue(setting), source)
+		}
+		w.Flush()
+	},
+}
+
+// displayValue returns the value of a setting, the secrets are redacted
+func displayValue(setting cli.Setting) string {
+	if setting.Value == "" || setting.Value == "[]" {
+		return "-"
+	}
+	for _, word := range secretKeys {
+		if strings.Contains(setting.Key, word) {
+			return agent.RedactedText
+		}
+	}
+	return setting.Value
+}
+
+func init() {
+
+	cli.RootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+}
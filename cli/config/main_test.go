@@ -0,0 +1,27 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/a13labs/cobot/cli"
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+func TestDisplayValueRedactsSecrets(t *testing.T) {
+	for _, tc := range []struct {
+		setting cli.Setting
+		want    string
+	}{
+		{cli.Setting{Key: "llm-host", Value: "ollama.lan"}, "ollama.lan"},
+		{cli.Setting{Key: "log-file", Value: ""}, "-"},
+		{cli.Setting{Key: "channel", Value: "[]"}, "-"},
+		{cli.Setting{Key: "telegram-token", Value: "123:abc"}, agent.RedactedText},
+		{cli.Setting{Key: "email-smtp-password", Value: "hunter22"}, agent.RedactedText},
+		{cli.Setting{Key: "telegram-webhook-secret", Value: "s3cr3t"}, agent.RedactedText},
+		{cli.Setting{Key: "http-token", Value: ""}, "-"},
+	} {
+		if got := displayValue(tc.setting); got != tc.want {
+			t.Errorf("displayValue(%s) = %q; want %q", tc.setting.Key, got, tc.want)
+		}
+	}
+}
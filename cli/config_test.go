@@ -0,0 +1,107 @@
+package cli_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/a13labs/cobot/cli"
+	_ "github.com/a13labs/cobot/cli/telegram"
+	"github.com/spf13/pflag"
+)
+
+// resetFlags gives back their default to the flags once the test is done, the
+// settings read from the environment and the config file are kept by the flags
+func resetFlags(t *testing.T) {
+	t.Cleanup(func() {
+		for _, flags := range []*pflag.FlagSet{cli.RootCmd.PersistentFlags(), cli.ServeCmd.Flags()} {
+			flags.VisitAll(func(flag *pflag.Flag) {
+				if slice, ok := flag.Value.(pflag.SliceValue); ok {
+					slice.Replace(nil)
+				} else {
+					flag.Value.Set(flag.DefValue)
+				}
+				flag.Changed = false
+			})
+		}
+	})
+}
+
+func settingsByKey(t *testing.T) map[string]cli.Setting {
+	t.Helper()
+
+	settings, err := cli.Settings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byKey := make(map[string]cli.Setting, len(settings))
+	for _, setting := range settings {
+		byKey[setting.Key] = setting
+	}
+	return byKey
+}
+
+func TestSettingsPrecedence(t *testing.T) {
+	resetFlags(t)
+
+	config := filepath.Join(t.TempDir(), "cobot.yaml")
+	err := os.WriteFile(config, []byte(`llm-host: file.lan
+llm-model: file-model
+llm-port: 1234
+telegram-chat: 42
+telegram-webhook-secret: file-secret
+channel: [telegram, control]
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(cli.ConfigEnv, config)
+	t.Setenv("COBOT_LLM_MODEL", "env-model")
+	t.Setenv("COBOT_LLM_PORT", "5678")
+	t.Setenv("TELEGRAM_TOKEN", "alias-token")
+	t.Setenv("TELEGRAM_WEBHOOK_SECRET", "alias-secret")
+	t.Setenv("COBOT_TELEGRAM_WEBHOOK_SECRET", "env-secret")
+	if err := cli.RootCmd.PersistentFlags().Set("llm-port", "9999"); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := settingsByKey(t)
+	if file := cli.ConfigFileUsed(); file != config {
+		t.Errorf("ConfigFileUsed() = %q; want %q", file, config)
+	}
+
+	for _, expected := range []cli.Setting{
+		{Key: "log-level", Value: "info", Source: cli.SourceDefault},
+		{Key: "llm-host", Value: "file.lan", Source: cli.SourceFile, Origin: config},
+		{Key: "telegram-chat", Value: "42", Source: cli.SourceFile, Origin: config},
+		{Key: "channel", Value: "[telegram,control]", Source: cli.SourceFile, Origin: config},
+		{Key: "llm-model", Value: "env-model", Source: cli.SourceEnv, Origin: "COBOT_LLM_MODEL"},
+		{Key: "telegram-token", Value: "alias-token", Source: cli.SourceEnv, Origin: "TELEGRAM_TOKEN"},
+		{Key: "telegram-webhook-secret", Value: "env-secret", Source: cli.SourceEnv, Origin: "COBOT_TELEGRAM_WEBHOOK_SECRET"},
+		{Key: "llm-port", Value: "9999", Source: cli.SourceFlag},
+	} {
+		if setting := settings[expected.Key]; setting != expected {
+			t.Errorf("setting %s = %+v; want %+v", expected.Key, setting, expected)
+		}
+	}
+}
+
+func TestSettingsInvalidValue(t *testing.T) {
+	resetFlags(t)
+
+	dir := t.TempDir()
+	t.Setenv(cli.ConfigEnv, filepath.Join(dir, "missing.yaml"))
+	if _, err := cli.Settings(); err == nil {
+		t.Errorf("Settings() with a missing config file should fail")
+	}
+
+	config := filepath.Join(dir, "cobot.yaml")
+	if err := os.WriteFile(config, []byte("llm-host: file.lan\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(cli.ConfigEnv, config)
+	t.Setenv("COBOT_LLM_PORT", "not-a-port")
+	if _, err := cli.Settings(); err == nil {
+		t.Errorf("Settings() with an invalid port should fail")
+	}
+}
@@ -22,26 +22,54 @@ THE SOFTWARE.
 package console
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/a13labs/cobot/cli"
+	"github.com/a13labs/cobot/internal/agent"
 	consoleChannel "github.com/a13labs/cobot/internal/channels/console"
 	"github.com/spf13/cobra"
 )
 
-// telegramCmd represents the list command
+var execute string
+
+// consoleCmd represents the console command
 var consoleCmd = &cobra.Command{
 	Use:   "console",
-	Short: "Receive input from user argument",
-	Long:  `Receive all commands from argument.`,
+	Short: "Receive input from the console",
+	Long: `Receive all commands from the console. On a terminal an interactive
+	console with line editing and history is started, type /help to list the
+	commands. When the input is piped every line is run in order, a single
+	message can also be run with --execute, the input then answers the
+	questions of the agent:
+
+	  cobot console -e "restart nginx"
+	  echo yes | cobot console -e "restart nginx"
+
+	The exit code is 0 on success, 1 when an action failed and 2 when no
+	action ran.`,
 	Run: func(cmd *cobra.Command, args []string) {
 
-		consoleChannel.Start(cli.AgentCtx)
-		os.Exit(0)
+		cli.InitAgent()
+
+		channel := consoleChannel.New(consoleChannel.Options{
+			Interactive: consoleChannel.IsTerminal(),
+			Execute:     execute,
+		})
+		if err := cli.RunChannels(channel); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(consoleChannel.ExitCode(channel.Result()))
 	},
 }
 
 func init() {
 
 	cli.RootCmd.AddCommand(consoleCmd)
+	consoleCmd.Flags().StringVarP(&execute, "execute", "e", "", "Run the given message and exit")
+
+	cli.RegisterChannel(consoleChannel.ChannelName, func() (agent.Channel, error) {
+		return consoleChannel.New(consoleChannel.Options{Interactive: consoleChannel.IsTerminal()}), nil
+	})
 }
@@ -0,0 +1,200 @@
+/*
+Copyright © 2023 Alexandre Pires
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package ctl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/a13labs/cobot/cli"
+	"github.com/a13labs/cobot/internal/agent"
+	controlChannel "github.com/a13labs/cobot/internal/channels/control"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var options controlChannel.Options
+
+var socketPath string
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "Control a running agent",
+	Long: `Control an agent running with the control channel enabled, e.g.:
+
+	cobot serve --channel control --channel telegram
+
+	The commands talk to the agent over its Unix socket, by default the
+	local/control.sock file of the storage. Only the serve command can run the
+	control channel, an agent started with a single channel command, like
+	'cobot telegram' or 'cobot http', can't be reached.`,
+}
+
+var chatCmd = &cobra.Command{
+	Use:   "chat <message>",
+	Short: "Send a message to the agent",
+	Long: `Send a message to the agent and show its replies, the questions of the
+	agent are answered from the standard input.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		request(controlChannel.Frame{Type: controlChannel.FrameMessage, Text: strings.Join(args, " ")}, nil)
+	},
+}
+
+var actionsCmd = &cobra.Command{
+	Use:   "actions",
+	Short: "List the actions of the agent",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		request(controlChannel.Frame{Type: controlChannel.FrameActions}, nil)
+	},
+}
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "List the actions being executed",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		request(controlChannel.Frame{Type: controlChannel.FrameJobs}, func(frame controlChannel.Frame) {
+			if len(frame.Jobs) == 0 {
+				fmt.Println("No actions are being executed")
+				return
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tACTION\tCHANNEL\tUSER\tRUNNING")
+			for _, job := range frame.Jobs {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", job.Id, job.Action, job.Channel, job.User, time.Since(job.Started).Round(time.Second))
+			}
+			w.Flush()
+		})
+	},
+}
+
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Load the configuration and the actions of the agent again",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		request(controlChannel.Frame{Type: controlChannel.FrameReload}, nil)
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the status of the agent",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		request(controlChannel.Frame{Type: controlChannel.FrameStatus}, func(frame controlChannel.Frame) {
+			status := frame.Status
+			if status == nil {
+				return
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(w, "Name:\t%s\n", status.Name)
+			fmt.Fprintf(w, "Storage version:\t%s\n", status.Version)
+			fmt.Fprintf(w, "Model:\t%s\n", status.Model)
+			fmt.Fprintf(w, "Started:\t%s\n", status.Started.Format(time.RFC3339))
+			fmt.Fprintf(w, "Uptime:\t%s\n", status.Uptime)
+			fmt.Fprintf(w, "Channels:\t%s\n", strings.Join(status.Channels, ", "))
+			fmt.Fprintf(w, "Running jobs:\t%d\n", status.Jobs)
+			fmt.Fprintf(w, "Actions (%d):\t%s\n", len(status.Actions), strings.Join(status.Actions, ", "))
+			w.Flush()
+		})
+	},
+}
+
+var shutdownCmd = &cobra.Command{
+	Use:   "shutdown",
+	Short: "Stop the agent gracefully",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		request(controlChannel.Frame{Type: controlChannel.FrameShutdown}, nil)
+		fmt.Println("The agent is shutting down")
+	},
+}
+
+// request sends a request to the agent, the replies are printed and the
+// questions answered from stdin. The last frame is given to show.
+func request(frame controlChannel.Frame, show func(controlChannel.Frame)) {
+
+	path := socketPath
+	if path == "" {
+		path = filepath.Join(cli.StoragePath(), controlChannel.SocketFile)
+	}
+
+	client, err := controlChannel.Dial(path)
+	if err != nil {
+		fmt.Printf("Error connecting to the agent: %s\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	input := bufio.NewScanner(os.Stdin)
+	ask := func(prompt agent.Prompt) (string, error) {
+		question := prompt.Text
+		if len(prompt.Options) > 0 {
+			question = fmt.Sprintf("%s (%s)", question, strings.Join(prompt.Options, "/"))
+		}
+		fmt.Printf("%s\n? ", question)
+		if !input.Scan() {
+			return "", errors.New("no answer given")
+		}
+		return strings.TrimSpace(input.Text()), nil
+	}
+
+	last, err := client.Request(frame, func(text string) { fmt.Println(text) }, ask)
+	if err != nil {
+		fmt.Println(err.Error())
+		client.Close()
+		os.Exit(1)
+	}
+	if show != nil {
+		show(last)
+	}
+}
+
+// addFlags adds the channel flags to the given flag set, the names are
+// prefixed for the serve command
+func addFlags(flags *pflag.FlagSet, prefix string) {
+	flags.StringVar(&options.Path, prefix+"socket", "", "Control socket path, the storage local/control.sock file by default")
+	flags.StringVar(&options.Group, prefix+"group", "", "Group allowed to use the control socket besides the user running the agent")
+}
+
+func newChannel() (agent.Channel, error) {
+	return controlChannel.New(options), nil
+}
+
+func init() {
+
+	cli.RootCmd.AddCommand(ctlCmd)
+	ctlCmd.PersistentFlags().StringVar(&socketPath, "socket", "", "Control socket path, the storage local/control.sock file by default")
+	ctlCmd.AddCommand(chatCmd, actionsCmd, jobsCmd, reloadCmd, statusCmd, shutdownCmd)
+
+	cli.RegisterChannel(controlChannel.ChannelName, newChannel)
+	addFlags(cli.ServeCmd.Flags(), "control-")
+}
@@ -0,0 +1,101 @@
+/*
+Copyright © 2023 Alexandre Pires
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package email
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/a13labs/cobot/cli"
+	"github.com/a13labs/cobot/internal/agent"
+	emailChannel "github.com/a13labs/cobot/internal/channels/email"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var options emailChannel.Options
+
+var emailCmd = &cobra.Command{
+	Use:   "email",
+	Short: "Receive input from an email mailbox",
+	Long: `Poll an IMAP mailbox for the requests of the allowed senders, the subject
+	and the body of an email are the request. The replies are sent over SMTP on
+	the same thread, the questions of the agent are answered by replying. The
+	senders must be authenticated by the Authentication-Results header of the
+	mail server, they are given the viewer role unless --role is given. The
+	passwords can also be given with the COBOT_IMAP_PASSWORD and
+	COBOT_SMTP_PASSWORD variables.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		channel, err := newChannel()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		cli.InitAgent()
+		if err := cli.RunChannels(channel); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+}
+
+func newChannel() (agent.Channel, error) {
+
+	if err := cli.CheckRole(emailChannel.ChannelName, options.Role); err != nil {
+		return nil, err
+	}
+	return emailChannel.New(options), nil
+}
+
+// addFlags adds the channel flags to the given flag set, the names are
+// prefixed for the serve command
+func addFlags(flags *pflag.FlagSet, prefix string) {
+	flags.StringVar(&options.IMAPAddress, prefix+"imap", "", "IMAP server address, host:port")
+	flags.BoolVar(&options.IMAPPlain, prefix+"imap-plain", false, "Connect to the IMAP server without TLS")
+	flags.StringVar(&options.IMAPUsername, prefix+"imap-username", "", "IMAP username")
+	flags.StringVar(&options.IMAPPassword, prefix+"imap-password", "", "IMAP password")
+	flags.StringVar(&options.Mailbox, prefix+"mailbox", "INBOX", "Mailbox polled for the requests")
+	flags.DurationVar(&options.PollInterval, prefix+"poll-interval", emailChannel.DefaultPollInterval, "Interval between two polls of the mailbox")
+	flags.StringVar(&options.SMTPAddress, prefix+"smtp", "", "SMTP server address, host:port")
+	flags.StringVar(&options.SMTPUsername, prefix+"smtp-username", "", "SMTP username")
+	flags.StringVar(&options.SMTPPassword, prefix+"smtp-password", "", "SMTP password")
+	flags.StringVar(&options.From, prefix+"from", "", "Address the replies are sent from")
+	flags.StringSliceVar(&options.AllowedSenders, prefix+"allowed-senders", nil, "Addresses the requests are accepted from, @domain accepts a whole domain")
+	flags.StringVar(&options.Role, prefix+"role", agent.RoleViewer, "Role given to the allowed senders")
+	flags.StringVar(&options.AuthServId, prefix+"auth-serv-id", "", "Id of the mail server in the Authentication-Results headers (default the last server)")
+	flags.BoolVar(&options.NoSenderAuth, prefix+"no-sender-auth", false, "Accept the allowed senders without checking their Authentication-Results")
+}
+
+func init() {
+
+	cli.RootCmd.AddCommand(emailCmd)
+	addFlags(emailCmd.Flags(), "")
+	cli.BindConfig(emailCmd, "email-")
+	cli.BindEnv("email-imap-password", "COBOT_IMAP_PASSWORD")
+	cli.BindEnv("email-smtp-password", "COBOT_SMTP_PASSWORD")
+
+	cli.RegisterChannel(emailChannel.ChannelName, newChannel)
+	addFlags(cli.ServeCmd.Flags(), "email-")
+}
@@ -0,0 +1,101 @@
+/*
+Copyright © 2023 Alexandre Pires
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package http
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/a13labs/cobot/cli"
+	"github.com/a13labs/cobot/internal/agent"
+	httpChannel "github.com/a13labs/cobot/internal/channels/http"
+	"github.com/spf13/cobra"
+)
+
+var httpListen string
+var httpToken string
+var httpCORSOrigins []string
+var httpNoAuth bool
+var httpRole string
+
+var httpCmd = &cobra.Command{
+	Use:   "http",
+	Short: "Receive input from an HTTP API",
+	Long: `Receive commands from an HTTP API with Server-Sent Events replies:
+	- POST /v1/messages                   submit a message, returns the conversation id
+	- GET  /v1/conversations/{id}/events  stream the replies and action results
+	- GET  /v1/actions                    list the actions
+	Requests must carry the token as a bearer token, the token can also be given
+	with the COBOT_HTTP_TOKEN variable. --no-auth serves the API without a token.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		channel, err := newChannel()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		cli.InitAgent()
+		if err := cli.RunChannels(channel); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+}
+
+func newChannel() (agent.Channel, error) {
+
+	if err := cli.CheckRole(httpChannel.ChannelName, httpRole); err != nil {
+		return nil, err
+	}
+	if httpToken == "" && !httpNoAuth {
+		return nil, errors.New("The HTTP token is not defined (http-token, COBOT_HTTP_TOKEN), aborting.")
+	}
+
+	return httpChannel.New(httpChannel.Options{
+		Listen:      httpListen,
+		Token:       httpToken,
+		NoAuth:      httpNoAuth,
+		CORSOrigins: httpCORSOrigins,
+		Role:        httpRole,
+	}), nil
+}
+
+func init() {
+
+	cli.RootCmd.AddCommand(httpCmd)
+	httpCmd.Flags().StringVar(&httpListen, "listen", "127.0.0.1:8080", "Address to listen on")
+	httpCmd.Flags().StringVarP(&httpToken, "token", "t", "", "Bearer token required by the API")
+	httpCmd.Flags().StringSliceVar(&httpCORSOrigins, "cors-origin", nil, "Origin allowed to call the API from a browser, can be repeated")
+	httpCmd.Flags().BoolVar(&httpNoAuth, "no-auth", false, "Serve the API without authentication when no token is given")
+	httpCmd.Flags().StringVar(&httpRole, "role", agent.RoleViewer, "Role given to the API clients")
+	cli.BindConfig(httpCmd, "http-")
+
+	cli.RegisterChannel(httpChannel.ChannelName, newChannel)
+	cli.ServeCmd.Flags().StringVar(&httpListen, "http-listen", "127.0.0.1:8080", "HTTP channel address to listen on")
+	cli.ServeCmd.Flags().StringVar(&httpToken, "http-token", "", "HTTP channel bearer token")
+	cli.ServeCmd.Flags().StringSliceVar(&httpCORSOrigins, "http-cors-origin", nil, "HTTP channel allowed origin, can be repeated")
+	cli.ServeCmd.Flags().BoolVar(&httpNoAuth, "http-no-auth", false, "HTTP channel served without authentication when no token is given")
+	cli.ServeCmd.Flags().StringVar(&httpRole, "http-role", agent.RoleViewer, "HTTP channel role given to the API clients")
+}
@@ -22,28 +22,52 @@ THE SOFTWARE.
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/a13labs/cobot/internal/agent"
+	"github.com/a13labs/cobot/internal/tracing"
 	"github.com/spf13/cobra"
 )
 
 var logFile string
+var logLevel string
+var logFormat string
+var logToStorage bool
+var logMaxSize int64
+var logRotateInterval time.Duration
+var logMaxBackups int
 var language string
 var minimumScore float64
 var storagePath string
+var storageRevision string
 var llmHost string
 var llmPort int
 var llmModel string
+var pidFile string
+var drainTimeout time.Duration
+var maxConcurrency int
+var metricsListen string
+var traceExporter string
+var traceEndpoint string
+var traceInsecure bool
+var traceFile string
 
 var RootCmd = &cobra.Command{
 	Use:   "cobot",
 	Short: "A friendly customizable agent that can run actions on the local machine",
 	Long: `A friendly customizable agent that can run actions on the local machine. The agent can run
-	in two modes, in both modes the user can interact by writing commands.
+	in several modes, in all modes the user can interact by writing commands.
 	- console
 	- telegram
+	- http
+	- websocket
+	- mqtt
+	Several channels can be served by the same agent with the serve command,
+	a running agent is controlled locally with the ctl command. The settings can
+	also be given in a config file or with COBOT_* variables, see the config command.
 	`,
 }
 
@@ -55,8 +79,6 @@ var AgentCtx *agent.AgentCtx
 
 func init() {
 
-	cobra.OnInitialize(initAgent)
-
 	// Current working directory
 	currDir, err := os.Getwd()
 	if err != nil {
@@ -67,26 +89,78 @@ func init() {
 	// Set defaults storage path
 	defaultPath := currDir + "/.data"
 
+	RootCmd.PersistentPreRun = loadConfig
+	RootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file, YAML or TOML, cobot.yaml or cobot.toml is searched in the current folder, ~/.config/cobot and /etc/cobot when empty")
 	RootCmd.PersistentFlags().StringVarP(&storagePath, "storage-path", "d", defaultPath, "Database path")
+	RootCmd.PersistentFlags().StringVar(&storageRevision, "storage-revision", "", "Serve storage read-only from a git branch, tag or commit")
 	RootCmd.PersistentFlags().StringVarP(&logFile, "log-file", "l", "", "Log file")
-	RootCmd.PersistentFlags().StringVarP(&language, "language", "g", "english", "Language")
+	RootCmd.PersistentFlags().StringVar(&logLevel, "log-level", agent.LogLevelInfo, "Log level: debug, info, warning or error")
+	RootCmd.PersistentFlags().StringVar(&logFormat, "log-format", agent.LogFormatText, "Log format: text or json")
+	RootCmd.PersistentFlags().BoolVar(&logToStorage, "log-to-storage", false, "Write the logs to the storage local/logs folder when no log file is given")
+	RootCmd.PersistentFlags().Int64Var(&logMaxSize, "log-max-size", 10, "Size in MB of the log file before it is rotated, 0 disables it")
+	RootCmd.PersistentFlags().DurationVar(&logRotateInterval, "log-rotate-interval", 24*time.Hour, "Interval between two rotations of the log file, 0 disables it")
+	RootCmd.PersistentFlags().IntVar(&logMaxBackups, "log-max-backups", 7, "Number of rotated log files kept, 0 keeps all of them")
+	RootCmd.PersistentFlags().StringVarP(&language, "language", "g", agent.DefaultArgs.Language, "Language of the replies, the language of the agent configuration takes precedence")
 	RootCmd.PersistentFlags().Float64VarP(&minimumScore, "minimum-score", "r", 0.5, "Similarity minimum")
 	RootCmd.PersistentFlags().StringVarP(&llmHost, "llm-host", "s", "localhost", "LLM host")
 	RootCmd.PersistentFlags().IntVarP(&llmPort, "llm-port", "p", 11434, "LLM port")
 	RootCmd.PersistentFlags().StringVarP(&llmModel, "llm-model", "m", "mistral", "LLM model")
+	RootCmd.PersistentFlags().StringVar(&pidFile, "pid-file", "", "File holding the process id while the agent runs")
+	RootCmd.PersistentFlags().StringVar(&metricsListen, "metrics-listen", "", "Address serving the Prometheus metrics on /metrics, e.g. 127.0.0.1:9090, disabled when empty")
+	RootCmd.PersistentFlags().StringVar(&traceExporter, "trace-exporter", tracing.ExporterNone, "Exporter of the OpenTelemetry traces: none, otlp, stdout or file")
+	RootCmd.PersistentFlags().StringVar(&traceEndpoint, "trace-endpoint", "", "Host and port of the OTLP HTTP collector, the OTEL_EXPORTER_OTLP_* variables apply when empty")
+	RootCmd.PersistentFlags().BoolVar(&traceInsecure, "trace-insecure", false, "Send the traces to the OTLP collector without TLS")
+	RootCmd.PersistentFlags().StringVar(&traceFile, "trace-file", "", "File receiving the traces with the file exporter, the storage "+TraceFile+" when empty")
+	RootCmd.PersistentFlags().DurationVar(&drainTimeout, "drain-timeout", 30*time.Second, "Time given to the actions being executed to finish when the agent stops")
+	RootCmd.PersistentFlags().IntVar(&maxConcurrency, "max-concurrency", agent.DefaultArgs.MaxConcurrency, "Maximum number of messages processed at once, the messages of a conversation are processed in order")
+}
+
+// StoragePath returns the storage path selected by the global flags
+func StoragePath() string {
+	return storagePath
+}
+
+// PidFile returns the pid file selected by the global flags, empty when none
+func PidFile() string {
+	return pidFile
 }
 
-func initAgent() {
+// OpenStorage opens the storage selected by the global flags, without starting
+// the agent. It is used by the commands that only manage the storage content.
+func OpenStorage() (agent.Storage, error) {
+	if storageRevision != "" {
+		return agent.NewGitStorage(storagePath, storageRevision)
+	}
+	return agent.NewFileStorage(storagePath)
+}
+
+// InitAgent starts the agent, it must be called by the commands that interact
+// with the agent before using AgentCtx. RunChannels shuts it down.
+func InitAgent() {
 	agentArgs := &agent.AgentStartArgs{
-		StoragePath:  storagePath,
-		LogFile:      logFile,
-		MinimumScore: minimumScore,
-		LLMHost:      llmHost,
-		LLMPort:      llmPort,
-		LLMModel:     llmModel,
+		StoragePath:     storagePath,
+		StorageRevision: storageRevision,
+		Log: agent.LogOptions{
+			Level:          logLevel,
+			Format:         logFormat,
+			File:           logFile,
+			ToStorage:      logToStorage,
+			MaxSize:        logMaxSize << 20,
+			RotateInterval: logRotateInterval,
+			MaxBackups:     logMaxBackups,
+		},
+		MinimumScore:   minimumScore,
+		LLMHost:        llmHost,
+		LLMPort:        llmPort,
+		LLMModel:       llmModel,
+		Language:       language,
+		MaxConcurrency: maxConcurrency,
 	}
 	var err error
 	AgentCtx, err = agent.NewAgentCtx(agentArgs)
+	if err == nil {
+		err = AgentCtx.Start(context.Background())
+	}
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
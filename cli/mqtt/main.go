@@ -0,0 +1,101 @@
+/*
+Copyright © 2023 Alexandre Pires
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/a13labs/cobot/cli"
+	"github.com/a13labs/cobot/internal/agent"
+	mqttChannel "github.com/a13labs/cobot/internal/channels/mqtt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var options mqttChannel.Options
+var qos int
+
+var mqttCmd = &cobra.Command{
+	Use:   "mqtt",
+	Short: "Receive input from a MQTT broker",
+	Long: `Receive commands from a MQTT command topic and publish the replies, the action
+	events and the agent status (online/offline, retained) to their topics. The
+	topics default to cobot/<agent>/command, reply, events and status. Commands
+	published on <command topic>/<user> come from <user>, restrict them with the
+	broker ACLs. The broker password can also be given with the COBOT_MQTT_PASSWORD
+	variable.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		channel, err := newChannel()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		cli.InitAgent()
+		if err := cli.RunChannels(channel); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+}
+
+func newChannel() (agent.Channel, error) {
+
+	if qos < 0 || qos > 2 {
+		return nil, errors.New("QoS must be 0, 1 or 2, aborting.")
+	}
+	options.QoS = byte(qos)
+	if err := cli.CheckRole(mqttChannel.ChannelName, options.Role); err != nil {
+		return nil, err
+	}
+
+	return mqttChannel.New(options), nil
+}
+
+// addFlags adds the channel flags to the given flag set, the names are
+// prefixed for the serve command
+func addFlags(flags *pflag.FlagSet, prefix string) {
+	flags.StringVar(&options.Broker, prefix+"broker", "tcp://localhost:1883", "MQTT broker URL")
+	flags.StringVar(&options.ClientId, prefix+"client-id", "", "MQTT client id (default cobot-<agent>)")
+	flags.StringVar(&options.Username, prefix+"username", "", "MQTT username")
+	flags.StringVar(&options.Password, prefix+"password", "", "MQTT password")
+	flags.IntVar(&qos, prefix+"qos", 1, "MQTT QoS of the subscriptions and publications")
+	flags.StringVar(&options.CommandTopic, prefix+"command-topic", "", "Topic the commands are read from")
+	flags.StringVar(&options.ReplyTopic, prefix+"reply-topic", "", "Topic the replies are published to")
+	flags.StringVar(&options.EventTopic, prefix+"event-topic", "", "Topic the action events are published to")
+	flags.StringVar(&options.StatusTopic, prefix+"status-topic", "", "Topic the agent status is published to")
+	flags.StringVar(&options.Role, prefix+"role", agent.RoleViewer, "Role given to the users publishing commands")
+}
+
+func init() {
+
+	cli.RootCmd.AddCommand(mqttCmd)
+	addFlags(mqttCmd.Flags(), "")
+	cli.BindConfig(mqttCmd, "mqtt-")
+
+	cli.RegisterChannel(mqttChannel.ChannelName, newChannel)
+	addFlags(cli.ServeCmd.Flags(), "mqtt-")
+}
@@ -0,0 +1,142 @@
+/*
+Copyright © 2023 Alexandre Pires
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package secrets
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/a13labs/cobot/cli"
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/spf13/cobra"
+)
+
+// secretsCmd represents the secrets command
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manage the encrypted secrets used by the actions",
+	Long: `Manage the encrypted secrets stored in the storage. Actions reference
+	secrets using ${secret:name} in their parameters. The encryption key is read
+	from the COBOT_SECRETS_KEY environment variable or from local/secrets.key,
+	a new key file is created when the first secret is set.`,
+}
+
+var secretsSetCmd = &cobra.Command{
+	Use:   "set NAME [VALUE]",
+	Short: "Set a secret, the value is read from stdin when omitted",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		store := openSecretStore(true)
+
+		var value string
+		if len(args) == 2 {
+			value = args[1]
+		} else {
+			fmt.Fprint(os.Stderr, "Value: ")
+			reader := bufio.NewReader(os.Stdin)
+			line, err := reader.ReadString('\n')
+			if err != nil && line == "" {
+				fmt.Println("Error reading secret value:", err.Error())
+				os.Exit(1)
+			}
+			value = strings.TrimRight(line, "\r\n")
+		}
+
+		if err := store.Set(args[0], value); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var secretsGetCmd = &cobra.Command{
+	Use:   "get NAME",
+	Short: "Print the value of a secret",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		store := openSecretStore(false)
+
+		value, ok := store.Get(args[0])
+		if !ok {
+			fmt.Printf("secret '%s' not found\n", args[0])
+			os.Exit(1)
+		}
+		fmt.Println(value)
+	},
+}
+
+var secretsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the names of the secrets",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		store := openSecretStore(false)
+
+		for _, name := range store.Names() {
+			fmt.Println(name)
+		}
+	},
+}
+
+var secretsRmCmd = &cobra.Command{
+	Use:   "rm NAME",
+	Short: "Remove a secret",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		store := openSecretStore(false)
+
+		if err := store.Remove(args[0]); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func openSecretStore(createKey bool) *agent.SecretStore {
+	storage, err := cli.OpenStorage()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	store, err := agent.NewSecretStore(storage, createKey)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	return store
+}
+
+func init() {
+
+	cli.RootCmd.AddCommand(secretsCmd)
+	secretsCmd.AddCommand(secretsSetCmd)
+	secretsCmd.AddCommand(secretsGetCmd)
+	secretsCmd.AddCommand(secretsListCmd)
+	secretsCmd.AddCommand(secretsRmCmd)
+}
@@ -0,0 +1,75 @@
+/*
+Copyright © 2023 Alexandre Pires
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/spf13/cobra"
+)
+
+var serveChannels []string
+
+// ServeCmd runs several channels in the same process, the channel commands add
+// their own flags to it.
+var ServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Receive input from several channels at once",
+	Long: `Receive commands from several channels at once, all the channels share the
+	same agent. Replies are sent back to the channel the command came from, e.g.:
+
+	cobot serve --channel console --channel telegram`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		if len(serveChannels) == 0 {
+			fmt.Printf("No channel selected, available channels: %s\n", strings.Join(ChannelNames(), ", "))
+			os.Exit(1)
+		}
+
+		var channels []agent.Channel
+		for _, name := range serveChannels {
+			channel, err := NewChannel(name)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			channels = append(channels, channel)
+		}
+
+		InitAgent()
+		if err := RunChannels(channels...); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+}
+
+func init() {
+
+	RootCmd.AddCommand(ServeCmd)
+	ServeCmd.Flags().StringSliceVarP(&serveChannels, "channel", "C", nil, "Channel to serve, can be repeated")
+	BindConfig(ServeCmd, "")
+}
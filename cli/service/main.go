@@ -0,0 +1,262 @@
+/*
+Copyright © 2023 Alexandre Pires
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/a13labs/cobot/cli"
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/a13labs/cobot/internal/algo"
+	controlChannel "github.com/a13labs/cobot/internal/channels/control"
+	"github.com/kardianos/service"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// The pid file of the service, in the storage
+const pidFile = "local/cobot.pid"
+
+var serviceName string
+var userService bool
+var userName string
+var channels []string
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Manage the agent as a system service",
+	Long: `Install the agent as a system service running the serve command, e.g.:
+
+	cobot service install --channel telegram --channel control -- --telegram-chat 123456789
+	echo COBOT_TELEGRAM_TOKEN=<token> > /etc/sysconfig/cobot
+	cobot service start
+
+	The global flags given to install, like the storage path or the LLM host,
+	are passed to the service. The arguments after '--' are added to the serve
+	command. With systemd the unit also reads /etc/sysconfig/<name>, the
+	passwords are better given there with the COBOT_* variables as the unit is
+	readable by all the users, the channels are only created when the service
+	starts. The service is only reached by 'cobot ctl' with the control
+	channel. SIGTERM stops the agent once the actions being executed finish,
+	SIGHUP ('systemctl reload') reloads the configuration.`,
+}
+
+var installCmd = &cobra.Command{
+	Use:   "install [-- serve flags]",
+	Short: "Install the service",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		if len(channels) == 0 {
+			fmt.Printf("No channel selected, available channels: %s\n", strings.Join(cli.ChannelNames(), ", "))
+			os.Exit(1)
+		}
+		names := algo.StringList(cli.ChannelNames())
+		for _, name := range channels {
+			if !names.Contains(name) {
+				fmt.Printf("%s: %s, available channels: %s\n", agent.ErrChannelNotFound, name, strings.Join(names, ", "))
+				os.Exit(1)
+			}
+		}
+
+		arguments, err := serveArguments(cmd, args)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		control("install", arguments)
+		fmt.Printf("Service %s installed, it runs: cobot %s\n", serviceName, strings.Join(arguments, " "))
+		if selected := algo.StringList(channels); !selected.Contains(controlChannel.ChannelName) {
+			fmt.Printf("The %s channel is not selected, the service can't be reached with cobot ctl\n", controlChannel.ChannelName)
+		}
+	},
+}
+
+var uninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Uninstall the service",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		control("uninstall", nil)
+		fmt.Printf("Service %s uninstalled\n", serviceName)
+	},
+}
+
+var startCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the service",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		control("start", nil)
+	},
+}
+
+var stopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the service, once the actions being executed finish",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		control("stop", nil)
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the service is running",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		s, err := newService(nil)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		status, err := s.Status()
+		if errors.Is(err, service.ErrNotInstalled) {
+			fmt.Printf("Service %s is not installed\n", serviceName)
+			os.Exit(3)
+		}
+		if err != nil {
+			fmt.Printf("Error reading the status of service %s: %s\n", serviceName, err)
+			os.Exit(1)
+		}
+		switch status {
+		case service.StatusRunning:
+			fmt.Printf("Service %s is running\n", serviceName)
+		case service.StatusStopped:
+			fmt.Printf("Service %s is stopped\n", serviceName)
+			os.Exit(3)
+		default:
+			fmt.Printf("The status of service %s is unknown\n", serviceName)
+			os.Exit(4)
+		}
+	},
+}
+
+// program is required by the service manager, the service runs the serve
+// command which handles the signals itself
+type program struct{}
+
+func (p *program) Start(s service.Service) error { return nil }
+func (p *program) Stop(s service.Service) error  { return nil }
+
+// servicePidFile returns the absolute path of the pid file of the service, the
+// one given with --pid-file or the one of the storage
+func servicePidFile(storagePath string) (string, error) {
+	if path := cli.PidFile(); path != "" {
+		return filepath.Abs(path)
+	}
+	return filepath.Join(storagePath, pidFile), nil
+}
+
+func newService(arguments []string) (service.Service, error) {
+	storagePath, err := filepath.Abs(cli.StoragePath())
+	if err != nil {
+		return nil, err
+	}
+	pidPath, err := servicePidFile(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	options := service.KeyValue{
+		"Restart":      "on-failure",
+		"ReloadSignal": "HUP",
+		"PIDFile":      pidPath,
+		"UserService":  userService,
+	}
+	return service.New(&program{}, &service.Config{
+		Name:             serviceName,
+		DisplayName:      "Cobot agent",
+		Description:      "A friendly customizable agent that can run actions on the local machine",
+		UserName:         userName,
+		Arguments:        arguments,
+		WorkingDirectory: storagePath,
+		Dependencies:     []string{"After=network-online.target", "Wants=network-online.target"},
+		Option:           options,
+	})
+}
+
+func control(action string, arguments []string) {
+	s, err := newService(arguments)
+	if err == nil {
+		err = service.Control(s, action)
+	}
+	if err != nil {
+		fmt.Printf("Error running %s for service %s: %s\n", action, serviceName, err)
+		os.Exit(1)
+	}
+}
+
+// serveArguments returns the arguments of the serve command run by the
+// service: the global flags given, the paths being made absolute as the
+// service runs in the storage folder, the channels and the extra serve flags
+func serveArguments(cmd *cobra.Command, extra []string) ([]string, error) {
+	storagePath, err := filepath.Abs(cli.StoragePath())
+	if err != nil {
+		return nil, err
+	}
+	// The service manager reads the pid file written by serve
+	pidPath, err := servicePidFile(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	arguments := []string{"serve", "--storage-path", storagePath, "--pid-file", pidPath}
+
+	// The service runs in the storage folder, the config file found in the
+	// current folder would not be found by the service
+	if config := cli.ConfigFileUsed(); config != "" {
+		arguments = append(arguments, "--config", config)
+	}
+
+	cmd.Root().PersistentFlags().Visit(func(flag *pflag.Flag) {
+		value := flag.Value.String()
+		switch flag.Name {
+		case "storage-path", "config", "pid-file":
+			return
+		case "log-file", "trace-file":
+			if value != "" && err == nil {
+				value, err = filepath.Abs(value)
+			}
+		}
+		arguments = append(arguments, fmt.Sprintf("--%s=%s", flag.Name, value))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, channel := range channels {
+		arguments = append(arguments, "--channel", channel)
+	}
+	return append(arguments, extra...), nil
+}
+
+func init() {
+
+	cli.RootCmd.AddCommand(serviceCmd)
+	serviceCmd.AddCommand(installCmd, uninstallCmd, startCmd, stopCmd, statusCmd)
+	serviceCmd.PersistentFlags().StringVar(&serviceName, "name", "cobot", "Name of the service")
+	serviceCmd.PersistentFlags().BoolVar(&userService, "user-service", false, "Manage a service of the current user instead of a system service")
+	installCmd.Flags().StringVar(&userName, "user", "", "User running the service, root when empty")
+	installCmd.Flags().StringSliceVarP(&channels, "channel", "C", nil, "Channel served, can be repeated")
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/a13labs/cobot/cli"
+	"github.com/spf13/pflag"
+)
+
+// setFlags gives the global flags as on the command line, they are reset once
+// the test is done
+func setFlags(t *testing.T, values map[string]string) {
+	t.Helper()
+
+	flags := cli.RootCmd.PersistentFlags()
+	t.Cleanup(func() {
+		flags.VisitAll(func(flag *pflag.Flag) {
+			flag.Value.Set(flag.DefValue)
+			flag.Changed = false
+		})
+	})
+	for name, value := range values {
+		if err := flags.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestServeArguments(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	channels = []string{"telegram", "control"}
+	defer func() { channels = nil }()
+
+	setFlags(t, map[string]string{
+		"storage-path": "data",
+		"log-file":     "logs/cobot.log",
+		"llm-host":     "ollama.lan",
+	})
+	arguments, err := serveArguments(installCmd, []string{"--telegram-chat", "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"serve", "--storage-path", filepath.Join(dir, "data"), "--pid-file", filepath.Join(dir, "data", pidFile),
+		"--llm-host=ollama.lan", "--log-file=" + filepath.Join(dir, "logs/cobot.log"),
+		"--channel", "telegram", "--channel", "control", "--telegram-chat", "42",
+	}
+	if !reflect.DeepEqual(arguments, want) {
+		t.Errorf("serveArguments() = %q; want %q", arguments, want)
+	}
+
+	// The pid file given is the one of the unit
+	setFlags(t, map[string]string{"pid-file": "run/cobot.pid"})
+	arguments, err = serveArguments(installCmd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unitPidFile, err := servicePidFile(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unitPidFile != filepath.Join(dir, "run/cobot.pid") || arguments[4] != unitPidFile {
+		t.Errorf("serveArguments() = %q; want the pid file %s of the unit", arguments, unitPidFile)
+	}
+}
@@ -22,57 +22,96 @@ THE SOFTWARE.
 package telegram
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"strconv"
+	"time"
 
 	"github.com/a13labs/cobot/cli"
+	"github.com/a13labs/cobot/internal/agent"
 	telegramChannel "github.com/a13labs/cobot/internal/channels/telegram"
 	"github.com/spf13/cobra"
 )
 
 var telegramToken string
 var telegramChatId int64
+var webhookURL string
+var webhookListen string
+var webhookSecret string
+var maxMessageAge time.Duration
 
 // telegramCmd represents the list command
 var telegramCmd = &cobra.Command{
 	Use:   "telegram",
 	Short: "Receive input from a telegram channel",
 	Long: `Receive all commands from a telegram channel, make sure you
-	provide a valid telegram token and a chat id.`,
+	provide a valid telegram token and a chat id. Messages are addressed to the
+	agent with @<agent name>, @<bot username> or @all, or by replying to the
+	bot. The updates are polled unless
+	a webhook URL is given, Telegram then pushes them to the --listen address.
+	The token, the chat id and the webhook secret can also be given with the
+	TELEGRAM_TOKEN, TELEGRAM_CHAT_ID and TELEGRAM_WEBHOOK_SECRET variables, a
+	random webhook secret is used otherwise. The messages older than
+	--max-message-age, sent while the agent was stopped, are ignored.`,
 	Run: func(cmd *cobra.Command, args []string) {
 
-		if telegramToken == "" {
-			value, exist := os.LookupEnv("TELEGRAM_TOKEN")
-			if !exist {
-				fmt.Println("TELEGRAM_TOKEN it's not defined, aborting.")
-				os.Exit(1)
-			}
-			telegramToken = value
+		channel, err := newChannel()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
 		}
 
-		if telegramChatId == 0 {
-			valueStr, exist := os.LookupEnv("TELEGRAM_CHAT_ID")
-			if !exist {
-				fmt.Println("TELEGRAM_CHAT_ID it's not defined, aborting.")
-				os.Exit(1)
-			}
-			value, err := strconv.ParseInt(valueStr, 10, 64)
-			if err != nil {
-				fmt.Println("TELEGRAM_CHAT_ID it's invalid, aborting.")
-				os.Exit(1)
-			}
-			telegramChatId = value
+		cli.InitAgent()
+		if err := cli.RunChannels(channel); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
 		}
-
-		telegramChannel.Start(cli.AgentCtx, telegramToken, telegramChatId)
 		os.Exit(0)
 	},
 }
 
+// newChannel creates the telegram channel from the settings, the token and the
+// chat id are required.
+func newChannel() (agent.Channel, error) {
+
+	if telegramToken == "" {
+		return nil, errors.New("The Telegram token is not defined (telegram-token, TELEGRAM_TOKEN), aborting.")
+	}
+
+	if telegramChatId == 0 {
+		return nil, errors.New("The Telegram chat id is not defined (telegram-chat, TELEGRAM_CHAT_ID), aborting.")
+	}
+
+	return telegramChannel.New(telegramChannel.Options{
+		Token:         telegramToken,
+		ChatId:        telegramChatId,
+		WebhookURL:    webhookURL,
+		Listen:        webhookListen,
+		WebhookSecret: webhookSecret,
+		MaxMessageAge: maxMessageAge,
+	}), nil
+}
+
 func init() {
 
 	cli.RootCmd.AddCommand(telegramCmd)
 	telegramCmd.Flags().StringVarP(&telegramToken, "token", "t", "", "Telegram bot token")
 	telegramCmd.Flags().Int64VarP(&telegramChatId, "chat", "c", 0, "Telegram chat id")
+	telegramCmd.Flags().StringVar(&webhookURL, "webhook-url", "", "Public URL of the webhook, enables the webhook mode")
+	telegramCmd.Flags().StringVar(&webhookListen, "listen", "127.0.0.1:8443", "Address the webhook listens on")
+	telegramCmd.Flags().StringVar(&webhookSecret, "webhook-secret", "", "Secret token Telegram must send with the updates")
+	telegramCmd.Flags().DurationVar(&maxMessageAge, "max-message-age", telegramChannel.DefaultMaxMessageAge, "Age after which the messages are ignored")
+
+	cli.BindConfig(telegramCmd, "telegram-")
+	cli.BindEnv("telegram-token", "TELEGRAM_TOKEN")
+	cli.BindEnv("telegram-chat", "TELEGRAM_CHAT_ID")
+	cli.BindEnv("telegram-webhook-secret", "TELEGRAM_WEBHOOK_SECRET")
+
+	cli.RegisterChannel(telegramChannel.ChannelName, newChannel)
+	cli.ServeCmd.Flags().StringVar(&telegramToken, "telegram-token", "", "Telegram bot token")
+	cli.ServeCmd.Flags().Int64Var(&telegramChatId, "telegram-chat", 0, "Telegram chat id")
+	cli.ServeCmd.Flags().StringVar(&webhookURL, "telegram-webhook-url", "", "Public URL of the Telegram webhook, enables the webhook mode")
+	cli.ServeCmd.Flags().StringVar(&webhookListen, "telegram-listen", "127.0.0.1:8443", "Address the Telegram webhook listens on")
+	cli.ServeCmd.Flags().StringVar(&webhookSecret, "telegram-webhook-secret", "", "Secret token Telegram must send with the updates")
+	cli.ServeCmd.Flags().DurationVar(&maxMessageAge, "telegram-max-message-age", telegramChannel.DefaultMaxMessageAge, "Age after which the Telegram messages are ignored")
 }
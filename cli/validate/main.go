@@ -0,0 +1,74 @@
+/*
+Copyright © 2023 Alexandre Pires
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package validate
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/a13labs/cobot/cli"
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/spf13/cobra"
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the agent configuration and the action files",
+	Long: `Check agent-config.yaml and every action file in the storage for unknown
+	fields, missing descriptions, unregistered plugins, unsatisfied placeholders
+	and duplicated names. The action files the agent does not load are reported
+	with a warning. Exits with a non-zero code when problems are found.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		storage, err := cli.OpenStorage()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		issues := agent.ValidateStorage(storage)
+		problems := 0
+		for _, issue := range issues {
+			fmt.Println(issue.String())
+			if !issue.Warning {
+				problems++
+			}
+		}
+
+		if problems > 0 {
+			fmt.Fprintf(os.Stderr, "%d problem(s) found\n", problems)
+			os.Exit(1)
+		}
+		if len(issues) > 0 {
+			fmt.Printf("No problems found, %d warning(s)\n", len(issues))
+			return
+		}
+		fmt.Println("No problems found")
+	},
+}
+
+func init() {
+
+	cli.RootCmd.AddCommand(validateCmd)
+}
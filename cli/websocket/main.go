@@ -0,0 +1,101 @@
+/*
+Copyright © 2023 Alexandre Pires
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/a13labs/cobot/cli"
+	"github.com/a13labs/cobot/internal/agent"
+	websocketChannel "github.com/a13labs/cobot/internal/channels/websocket"
+	"github.com/spf13/cobra"
+)
+
+var wsListen string
+var wsToken string
+var wsAllowedOrigins []string
+var wsNoAuth bool
+var wsRole string
+
+var websocketCmd = &cobra.Command{
+	Use:   "websocket",
+	Short: "Receive input from WebSocket clients",
+	Long: `Receive commands from WebSocket clients connected to /v1/ws. Messages, replies,
+	confirmation prompts and action progress are exchanged as JSON frames. Clients
+	must send the token as a bearer token or in the token query parameter, the
+	token can also be given with the COBOT_WS_TOKEN variable. --no-auth accepts the
+	connections without a token. Browsers may only connect from the server's own
+	host unless --allowed-origin is given.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		channel, err := newChannel()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		cli.InitAgent()
+		if err := cli.RunChannels(channel); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+}
+
+func newChannel() (agent.Channel, error) {
+
+	if err := cli.CheckRole(websocketChannel.ChannelName, wsRole); err != nil {
+		return nil, err
+	}
+	if wsToken == "" && !wsNoAuth {
+		return nil, errors.New("The WebSocket token is not defined (ws-token, COBOT_WS_TOKEN), aborting.")
+	}
+
+	return websocketChannel.New(websocketChannel.Options{
+		Listen:         wsListen,
+		Token:          wsToken,
+		NoAuth:         wsNoAuth,
+		AllowedOrigins: wsAllowedOrigins,
+		Role:           wsRole,
+	}), nil
+}
+
+func init() {
+
+	cli.RootCmd.AddCommand(websocketCmd)
+	websocketCmd.Flags().StringVar(&wsListen, "listen", "127.0.0.1:8081", "Address to listen on")
+	websocketCmd.Flags().StringVarP(&wsToken, "token", "t", "", "Token required from the clients")
+	websocketCmd.Flags().StringSliceVar(&wsAllowedOrigins, "allowed-origin", nil, "Browser origin allowed to connect, can be repeated")
+	websocketCmd.Flags().BoolVar(&wsNoAuth, "no-auth", false, "Accept the connections without authentication when no token is given")
+	websocketCmd.Flags().StringVar(&wsRole, "role", agent.RoleViewer, "Role given to the clients")
+	cli.BindConfig(websocketCmd, "ws-")
+
+	cli.RegisterChannel(websocketChannel.ChannelName, newChannel)
+	cli.ServeCmd.Flags().StringVar(&wsListen, "ws-listen", "127.0.0.1:8081", "WebSocket channel address to listen on")
+	cli.ServeCmd.Flags().StringVar(&wsToken, "ws-token", "", "WebSocket channel token")
+	cli.ServeCmd.Flags().StringSliceVar(&wsAllowedOrigins, "ws-allowed-origin", nil, "WebSocket channel allowed origin, can be repeated")
+	cli.ServeCmd.Flags().BoolVar(&wsNoAuth, "ws-no-auth", false, "WebSocket channel accepts connections without authentication when no token is given")
+	cli.ServeCmd.Flags().StringVar(&wsRole, "ws-role", agent.RoleViewer, "WebSocket channel role given to the clients")
+}
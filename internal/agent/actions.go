@@ -1,7 +1,22 @@
 package agent
 
+/*
+	Actions are loaded from two sources, in this order:
+	- the actions list of the agent configuration, an entry either names a file
+	  in the actions folder (actions/<name>.yaml) or defines the action inline
+	- when discovery is enabled, every actions/**.yaml file matching the include
+	  patterns and none of the exclude patterns. Patterns are matched with
+	  algo.Match against the path relative to the actions folder.
+
+	Any action can be disabled using 'enabled: false'. An action name defined by
+	more than one source is reported and the first definition is kept.
+*/
+
 import (
 	"errors"
+	"path"
+	"sort"
+	"strings"
 
 	"github.com/a13labs/cobot/internal/algo"
 	"github.com/a13labs/cobot/internal/nlp"
@@ -14,56 +29,230 @@ type ActionExecution struct {
 }
 
 type Action struct {
-	Description string          `yaml:"description"`
-	Name        string          `yaml:"name"`
-	Args        algo.StringList `yaml:"args,omitempty"`
-	Exec        ActionExecution `yaml:"exec,omitempty"`
+	Description string `yaml:"description"`
+	Name        string `yaml:"name"`
+	Enabled     *bool  `yaml:"enabled,omitempty"`
+	// Confirm asks the user to confirm before running the action
+	Confirm bool `yaml:"confirm,omitempty"`
+	// Role is the minimum role required to run the action, operator when empty
+	Role string          `yaml:"role,omitempty"`
+	Args algo.StringList `yaml:"args,omitempty"`
+	// Files are the arguments filled with the files sent with the message
+	Files algo.StringList `yaml:"files,omitempty"`
+	Exec  ActionExecution `yaml:"exec,omitempty"`
+}
+
+// IsEnabled returns false only when the action is explicitly disabled
+func (a *Action) IsEnabled() bool {
+	return a.Enabled == nil || *a.Enabled
+}
+
+// ActionRef is an entry of the actions list of the agent configuration, it
+// either names an action file or holds an inline action definition
+type ActionRef struct {
+	Name   string
+	Inline *Action
+}
+
+func (r *ActionRef) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		r.Name = name
+		return nil
+	}
+
+	var action Action
+	if err := unmarshal(&action); err != nil {
+		return err
+	}
+	r.Name = action.Name
+	r.Inline = &action
+	return nil
+}
+
+func (r ActionRef) MarshalYAML() (interface{}, error) {
+	if r.Inline != nil {
+		return r.Inline, nil
+	}
+	return r.Name, nil
+}
+
+// ActionDiscovery controls the automatic loading of the action files
+type ActionDiscovery struct {
+	Enabled bool     `yaml:"enabled"`
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
+// Matches returns true if the given path, relative to the actions folder, is
+// selected by the include and exclude patterns
+func (d *ActionDiscovery) Matches(file string) bool {
+	included := len(d.Include) == 0
+	for _, pattern := range d.Include {
+		if algo.Match(pattern, file) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, pattern := range d.Exclude {
+		if algo.Match(pattern, file) {
+			return false
+		}
+	}
+	return true
 }
 
 type ActionDB struct {
 	LLMClient   *nlp.LLMClient
 	Actions     map[string]Action
 	ActionNames algo.StringList
-	Driver      *Storage
+	// Sources holds where each action was defined
+	Sources map[string]string
+	Driver  Storage
 }
 
-func NewActionDB(actions algo.StringList, storage *Storage, llmClient *nlp.LLMClient) (*ActionDB, error) {
+func NewActionDB(cfg AgentConfigFile, storage Storage, llmClient *nlp.LLMClient) (*ActionDB, error) {
+
+	logger := GetLogger()
 
 	_, err := storage.Stat("actions")
 	if err != nil {
-		storage.Mkdir("actions", 0755)
-		logger.Info("Created actions folder, this folder should contain action files. Agent will start with an empty actions list.")
+		if err := storage.Mkdir("actions", 0755); err != nil {
+			logger.Warning("Actions folder not found and could not be created. Agent will start with an empty actions list.")
+		} else {
+			logger.Info("Created actions folder, this folder should contain action files. Agent will start with an empty actions list.")
+		}
 	}
 
-	availableActions := make(map[string]Action)
-	actionNames := algo.StringList{}
-	for _, action := range actions {
-		_, err := storage.Stat("actions/" + action + ".yaml")
-		if err != nil {
-			logger.Error("Action definition not found: " + action + ".yaml, skipping")
+	adb := &ActionDB{
+		Actions:     make(map[string]Action),
+		ActionNames: algo.StringList{},
+		Sources:     make(map[string]string),
+		Driver:      storage,
+		LLMClient:   llmClient,
+	}
+
+	sources, missing, err := actionSources(cfg, storage)
+	for _, name := range missing {
+		logger.Error("Action definition not found: " + name + ".yaml, skipping")
+	}
+	if err != nil {
+		logger.Error("Error listing actions folder: %s", err)
+	}
+	for _, source := range sources {
+		if source.inline != nil {
+			adb.add(*source.inline, source.name, source.file)
 			continue
 		}
-		data, err := storage.ReadFile("actions/" + action + ".yaml")
-		if err != nil {
-			logger.Error("Error reading action file: " + action + ".yaml, skipping")
+		adb.loadFile(source.file, source.name)
+	}
+
+	return adb, nil
+}
+
+// actionSource is an action definition to load, either inline in the agent
+// configuration or in an action file
+type actionSource struct {
+	file string
+	// name is the action name when the definition has none
+	name   string
+	inline *Action
+}
+
+// actionSources returns the action definitions selected by the configuration,
+// in the order they are loaded, and the names of the listed action files that
+// do not exist. The agent and the validator load the same definitions.
+func actionSources(cfg AgentConfigFile, storage Storage) ([]actionSource, []string, error) {
+
+	var sources []actionSource
+	var missing []string
+
+	selected := map[string]bool{}
+	for _, ref := range cfg.Actions {
+		if ref.Inline != nil {
+			sources = append(sources, actionSource{file: "agent-config.yaml", name: ref.Inline.Name, inline: ref.Inline})
+			continue
+		}
+		file := "actions/" + ref.Name + ".yaml"
+		if _, err := storage.Stat(file); err != nil {
+			missing = append(missing, ref.Name)
 			continue
 		}
-		var a Action
-		if err := yaml.Unmarshal(data, &a); err != nil {
-			logger.Error("Error parsing action file: " + action + ".yaml, skipping")
+		if selected[file] {
 			continue
 		}
+		selected[file] = true
+		sources = append(sources, actionSource{file: file, name: ref.Name})
+	}
 
-		availableActions[action] = a
-		actionNames = append(actionNames, action)
+	if !cfg.Discovery.Enabled {
+		return sources, missing, nil
 	}
 
-	return &ActionDB{
-		Actions:     availableActions,
-		ActionNames: actionNames,
-		Driver:      storage,
-		LLMClient:   llmClient,
-	}, nil
+	files, err := WalkFiles(storage, "actions")
+	for _, file := range files {
+		if path.Ext(file) != ".yaml" || selected[file] {
+			continue
+		}
+		if !cfg.Discovery.Matches(strings.TrimPrefix(file, "actions/")) {
+			continue
+		}
+		sources = append(sources, actionSource{file: file, name: strings.TrimSuffix(path.Base(file), ".yaml")})
+	}
+	return sources, missing, err
+}
+
+// parseAction decodes an action definition
+func parseAction(data []byte) (Action, error) {
+	var a Action
+	err := yaml.Unmarshal(data, &a)
+	return a, err
+}
+
+func (adb *ActionDB) loadFile(file string, defaultName string) {
+
+	logger := GetLogger()
+
+	data, err := adb.Driver.ReadFile(file)
+	if err != nil {
+		logger.Error("Error reading action file: " + file + ", skipping")
+		return
+	}
+	a, err := parseAction(data)
+	if err != nil {
+		logger.Error("Error parsing action file: " + file + ", skipping")
+		return
+	}
+	adb.add(a, defaultName, file)
+}
+
+func (adb *ActionDB) add(a Action, name string, source string) {
+
+	logger := GetLogger()
+
+	if a.Name != "" {
+		name = a.Name
+	}
+	if name == "" {
+		logger.Error("Action without a name in %s, skipping", source)
+		return
+	}
+	if !a.IsEnabled() {
+		logger.Info("Action %s is disabled, skipping", name)
+		return
+	}
+	if previous, exist := adb.Sources[name]; exist {
+		logger.Error("Action name collision: '%s' is defined in %s and %s, keeping the first one", name, previous, source)
+		return
+	}
+
+	a.Name = name
+	adb.Actions[name] = a
+	adb.Sources[name] = source
+	adb.ActionNames = append(adb.ActionNames, name)
 }
 
 func (adb *ActionDB) GetActions() map[string]Action {
@@ -85,21 +274,39 @@ func (adb *ActionDB) GetActionDescriptions() algo.StringList {
 
 func (adb *ActionDB) GetAction(actionName string) (Action, error) {
 
-	_, err := adb.Driver.Stat("actions/" + actionName + ".yaml")
-
-	if err != nil {
+	action, ok := adb.Actions[actionName]
+	if !ok {
 		return Action{}, errors.New("action not found")
 	}
 
-	actionFile, err := adb.Driver.ReadFile("actions/" + actionName + ".yaml")
+	return action, nil
+}
+
+// WalkFiles returns the paths of all the files found under the given folder,
+// recursively and sorted
+func WalkFiles(storage Storage, dir string) ([]string, error) {
+	names, err := storage.ListFiles(dir)
 	if err != nil {
-		return Action{}, errors.New("error reading action file")
+		return nil, err
 	}
 
-	var action Action
-	if err := yaml.Unmarshal(actionFile, &action); err != nil {
-		return Action{}, errors.New("error parsing action file")
+	var files []string
+	for _, name := range names {
+		p := dir + "/" + name
+		fi, err := storage.Stat(p)
+		if err != nil {
+			continue
+		}
+		if fi.IsDir() {
+			sub, err := WalkFiles(storage, p)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, sub...)
+		} else {
+			files = append(files, p)
+		}
 	}
-
-	return action, nil
+	sort.Strings(files)
+	return files, nil
 }
@@ -0,0 +1,101 @@
+package agent_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/a13labs/cobot/internal/algo"
+)
+
+func TestActionDBDiscoveryAndInline(t *testing.T) {
+	s := agent.NewMemStorageFromMap(map[string]string{
+		"agent-config.yaml": `agent:
+  name: tester
+actions:
+  - wake_up
+  - description: restart a local service
+    name: restart_service
+    args:
+      - service
+    exec:
+      plugin: echo
+      parameters:
+        command: restart ${service.name}
+  - description: inline copy of a file action
+    name: reboot
+    exec:
+      plugin: echo
+discovery:
+  enabled: true
+  exclude:
+    - "experimental/*"
+`,
+		"actions/wake_up.yaml":           "description: wake up\nname: wake_up\nexec:\n  plugin: echo\n",
+		"actions/net/ping.yaml":          "description: ping a host\nname: ping\nexec:\n  plugin: echo\n",
+		"actions/net/reboot.yaml":        "description: reboot\nname: reboot\nexec:\n  plugin: echo\n",
+		"actions/net/disabled.yaml":      "description: disabled\nname: disabled\nenabled: false\nexec:\n  plugin: echo\n",
+		"actions/experimental/test.yaml": "description: test\nname: test\nexec:\n  plugin: echo\n",
+		"actions/notes.txt":              "not an action",
+	})
+
+	cfg, err := agent.LoadAgentConfig(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := agent.NewActionDB(cfg, s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := algo.StringList{"wake_up", "restart_service", "reboot", "ping"}
+	if !reflect.DeepEqual(db.ActionNames, expected) {
+		t.Errorf("ActionNames = %v; want %v", db.ActionNames, expected)
+	}
+	if db.Sources["reboot"] != "agent-config.yaml" {
+		t.Errorf("reboot source = %q; the first definition must be kept", db.Sources["reboot"])
+	}
+	if db.Sources["ping"] != "actions/net/ping.yaml" {
+		t.Errorf("ping source = %q", db.Sources["ping"])
+	}
+	action, err := db.GetAction("restart_service")
+	if err != nil || action.Exec.Parameters["command"] != "restart ${service.name}" {
+		t.Errorf("GetAction(restart_service) = %v, %v", action, err)
+	}
+
+	var got []string
+	for _, issue := range agent.ValidateStorage(s) {
+		got = append(got, issue.String())
+	}
+	all := strings.Join(got, "\n")
+	if !strings.Contains(all, "actions/net/reboot.yaml:2: duplicated action name 'reboot', already defined in agent-config.yaml:14") {
+		t.Errorf("collision not reported by the validator, got:\n%s", all)
+	}
+	if !strings.Contains(all, "actions/experimental/test.yaml: warning: file is not loaded") {
+		t.Errorf("excluded file not reported by the validator, got:\n%s", all)
+	}
+	if len(got) != 2 {
+		t.Errorf("expected two issues, got:\n%s", all)
+	}
+}
+
+func TestActionDiscoveryMatches(t *testing.T) {
+	d := agent.ActionDiscovery{Enabled: true, Include: []string{"net/*", "*.yaml"}, Exclude: []string{"*/draft_*"}}
+	tests := map[string]bool{
+		"wake_up.yaml":        true,
+		"net/ping.yaml":       true,
+		"net/draft_ping.yaml": false,
+	}
+	for file, want := range tests {
+		if got := d.Matches(file); got != want {
+			t.Errorf("Matches(%q) = %v; want %v", file, got, want)
+		}
+	}
+
+	d = agent.ActionDiscovery{Enabled: true, Include: []string{"net/*"}}
+	if d.Matches("wake_up.yaml") {
+		t.Errorf("Matches(wake_up.yaml) = true; want false")
+	}
+}
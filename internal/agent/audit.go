@@ -0,0 +1,240 @@
+package agent
+
+/*
+	The audit log records every action the agent was asked to run. It is kept in
+	local/audit.log, one JSON record per line, and it is append-only.
+
+	Records are hash-chained: each record holds the hash of the previous one and
+	its own hash is the SHA-256 of the record serialized without the hash field.
+	Changing, removing or reordering a record breaks the chain from that record
+	on. Truncating the tail of the log can only be detected by comparing the head
+	hash with a copy kept elsewhere, 'cobot audit verify' prints it.
+*/
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const AuditLogFile = "local/audit.log"
+
+// The hash used as previous hash by the first record
+var auditGenesisHash = strings.Repeat("0", 64)
+
+// Audit record results
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditRefused = "refused"
+)
+
+type AuditRecord struct {
+	Seq        int64                  `json:"seq"`
+	Time       time.Time              `json:"time"`
+	Channel    string                 `json:"channel"`
+	User       string                 `json:"user"`
+	Message    string                 `json:"message"`
+	Action     string                 `json:"action,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Result     string                 `json:"result"`
+	Output     string                 `json:"output,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	DurationMs int64                  `json:"duration_ms"`
+	PrevHash   string                 `json:"prev_hash"`
+	Hash       string                 `json:"hash"`
+}
+
+func (r *AuditRecord) computeHash() (string, error) {
+	unhashed := *r
+	unhashed.Hash = ""
+	data, err := json.Marshal(&unhashed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (r *AuditRecord) String() string {
+	action := r.Action
+	if action == "" {
+		action = "-"
+	}
+	return fmt.Sprintf("#%d %s %s/%s %s %s (%dms) %q", r.Seq, r.Time.Format(time.RFC3339), r.Channel, r.User, action, r.Result, r.DurationMs, r.Message)
+}
+
+type AuditLog struct {
+	mu       sync.Mutex
+	storage  Storage
+	lastHash string
+	lastSeq  int64
+}
+
+// OpenAuditLog opens the audit log kept in the given storage, the log is
+// created on the first record.
+func OpenAuditLog(storage Storage) (*AuditLog, error) {
+	records, err := ReadAuditLog(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &AuditLog{
+		storage:  storage,
+		lastHash: auditGenesisHash,
+	}
+	if len(records) > 0 {
+		last := records[len(records)-1]
+		a.lastHash = last.Hash
+		a.lastSeq = last.Seq
+	}
+	return a, nil
+}
+
+// Append chains and writes a record, the written record is returned
+func (a *AuditLog) Append(record AuditRecord) (AuditRecord, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	record.Seq = a.lastSeq + 1
+	record.PrevHash = a.lastHash
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	record.Time = record.Time.UTC()
+	if record.Parameters != nil {
+		record.Parameters = normalizeJSON(record.Parameters).(map[string]interface{})
+	}
+
+	hash, err := record.computeHash()
+	if err != nil {
+		return record, err
+	}
+	record.Hash = hash
+
+	line, err := json.Marshal(&record)
+	if err != nil {
+		return record, err
+	}
+
+	if err := EnsureDir(a.storage, "local", 0700); err != nil {
+		return record, err
+	}
+	if err := AppendFile(a.storage, AuditLogFile, append(line, '\n'), 0600); err != nil {
+		return record, err
+	}
+
+	a.lastSeq = record.Seq
+	a.lastHash = record.Hash
+	return record, nil
+}
+
+// Recent returns the last n records, oldest first
+func (a *AuditLog) Recent(n int) ([]AuditRecord, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	records, err := ReadAuditLog(a.storage)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && len(records) > n {
+		records = records[len(records)-n:]
+	}
+	return records, nil
+}
+
+// ReadAuditLog reads all the records of the audit log, an empty list is
+// returned when the log does not exist yet
+func ReadAuditLog(storage Storage) ([]AuditRecord, error) {
+	if _, err := storage.Stat(AuditLogFile); err != nil {
+		return nil, nil
+	}
+
+	data, err := storage.ReadFile(AuditLogFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []AuditRecord
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		// Numbers are kept as written so the hash can be recomputed
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		var record AuditRecord
+		if err := dec.Decode(&record); err != nil {
+			return nil, fmt.Errorf("audit log line %d: %w", i+1, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ErrAuditChainBroken is returned when the audit log was tampered with
+var ErrAuditChainBroken = errors.New("audit log chain is broken")
+
+// VerifyAuditLog checks the hash chain of the audit log, it returns the number
+// of records and the hash of the last one
+func VerifyAuditLog(storage Storage) (int, string, error) {
+	records, err := ReadAuditLog(storage)
+	if err != nil {
+		return 0, "", err
+	}
+
+	prevHash := auditGenesisHash
+	var prevSeq int64
+	for _, record := range records {
+		if record.PrevHash != prevHash {
+			return 0, "", fmt.Errorf("%w: record #%d does not follow the previous record", ErrAuditChainBroken, record.Seq)
+		}
+		if record.Seq != prevSeq+1 {
+			return 0, "", fmt.Errorf("%w: record #%d found after record #%d", ErrAuditChainBroken, record.Seq, prevSeq)
+		}
+		hash, err := record.computeHash()
+		if err != nil {
+			return 0, "", err
+		}
+		if hash != record.Hash {
+			return 0, "", fmt.Errorf("%w: record #%d was modified", ErrAuditChainBroken, record.Seq)
+		}
+		prevHash = record.Hash
+		prevSeq = record.Seq
+	}
+
+	return len(records), prevHash, nil
+}
+
+// normalizeJSON converts the maps decoded from YAML into maps JSON can encode
+func normalizeJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = normalizeJSON(item)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalizeJSON(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = normalizeJSON(item)
+		}
+		return l
+	default:
+		return value
+	}
+}
@@ -0,0 +1,158 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+func TestAuditLogChain(t *testing.T) {
+	s := agent.NewMemStorage()
+
+	audit, err := agent.OpenAuditLog(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, action := range []string{"wake_up", "restart_service", "wake_up"} {
+		record, err := audit.Append(agent.AuditRecord{
+			Channel:    "console",
+			User:       "alice",
+			Message:    "please run " + action,
+			Action:     action,
+			Parameters: map[string]interface{}{"command": "run", "retries": 3, "nested": map[interface{}]interface{}{"a": 1}},
+			Result:     agent.AuditSuccess,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Seq != int64(i+1) {
+			t.Errorf("record.Seq = %d; want %d", record.Seq, i+1)
+		}
+	}
+
+	// Reopening the log continues the chain
+	audit, err = agent.OpenAuditLog(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := audit.Append(agent.AuditRecord{Channel: "telegram", User: "bob", Message: "hi", Result: agent.AuditRefused}); err != nil {
+		t.Fatal(err)
+	}
+
+	count, head, err := agent.VerifyAuditLog(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 || len(head) != 64 {
+		t.Errorf("VerifyAuditLog() = %d, %q", count, head)
+	}
+
+	recent, err := audit.Recent(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 2 || recent[1].User != "bob" {
+		t.Errorf("Recent(2) = %v", recent)
+	}
+
+	// Tamper with a record
+	data, _ := s.ReadFile(agent.AuditLogFile)
+	tampered := strings.Replace(string(data), `"user":"alice"`, `"user":"mallory"`, 1)
+	if err := s.WriteFile(agent.AuditLogFile, []byte(tampered), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := agent.VerifyAuditLog(s); !errors.Is(err, agent.ErrAuditChainBroken) {
+		t.Errorf("VerifyAuditLog() error = %v; want ErrAuditChainBroken", err)
+	}
+
+	// Remove a record
+	lines := strings.SplitAfter(string(data), "\n")
+	if err := s.WriteFile(agent.AuditLogFile, []byte(lines[0]+lines[2]+lines[3]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := agent.VerifyAuditLog(s); !errors.Is(err, agent.ErrAuditChainBroken) {
+		t.Errorf("VerifyAuditLog() error = %v; want ErrAuditChainBroken", err)
+	}
+}
+
+func TestExecuteActionIsAudited(t *testing.T) {
+	s := agent.NewMemStorage()
+
+	secrets, err := agent.NewSecretStore(s, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := secrets.Set("token", "very-secret-token"); err != nil {
+		t.Fatal(err)
+	}
+	audit, err := agent.OpenAuditLog(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &agent.AgentCtx{
+		Storage: s,
+		Secrets: secrets,
+		Audit:   audit,
+		AgentCfg: agent.AgentConfigFile{
+			KnowledgeBase: map[string]interface{}{
+				"computer": map[string]interface{}{
+					"fedora": map[string]interface{}{"mac": "00:11:22:33:44:55"},
+				},
+			},
+		},
+	}
+
+	action := agent.Action{
+		Name: "wake_up",
+		Args: []string{"computer"},
+		Exec: agent.ActionExecution{
+			Plugin: "echo",
+			Parameters: map[string]interface{}{
+				"command": "wakeonlan ${kb:computer.mac} ${computer} --token ${secret:token}",
+			},
+		},
+	}
+	msg := agent.Message{Channel: "console", User: "alice", Role: agent.RoleAdmin, Text: "wake up fedora, token is very-secret-token"}
+
+	output, err := ctx.ExecuteAction(context.Background(), msg, action, map[string]string{"computer": "fedora"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "wakeonlan 00:11:22:33:44:55 fedora --token very-secret-token" {
+		t.Errorf("output = %q", output)
+	}
+
+	privileged := action
+	privileged.Name = "privileged"
+	privileged.Exec.Parameters = map[string]interface{}{"command": "reboot", "privileged": true}
+	if _, err := ctx.ExecuteAction(context.Background(), msg, privileged, nil); !errors.Is(err, agent.ErrPrivilegedNotAllow) {
+		t.Errorf("privileged action error = %v; want ErrPrivilegedNotAllow", err)
+	}
+
+	records, err := agent.ReadAuditLog(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 audit records, got %d", len(records))
+	}
+
+	r := records[0]
+	if r.Channel != "console" || r.User != "alice" || r.Action != "wake_up" || r.Result != agent.AuditSuccess {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if r.Parameters["command"] != "wakeonlan 00:11:22:33:44:55 fedora --token "+agent.RedactedText {
+		t.Errorf("audited parameters = %v", r.Parameters)
+	}
+	data, _ := s.ReadFile(agent.AuditLogFile)
+	if strings.Contains(string(data), "very-secret-token") {
+		t.Errorf("audit log holds a secret value")
+	}
+	if records[1].Result != agent.AuditRefused {
+		t.Errorf("privileged record result = %q; want %q", records[1].Result, agent.AuditRefused)
+	}
+}
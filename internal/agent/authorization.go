@@ -0,0 +1,145 @@
+package agent
+
+/*
+	Users are declared in the agent configuration with a role and the
+	identities they have on each channel:
+
+	users:
+	  - name: alice
+	    role: admin
+	    telegram:
+	      id: 123456789
+
+	Roles are ordered, each one can do everything the previous one can:
+	- viewer    talks to the agent, runs the actions declaring 'role: viewer'
+	- operator  runs the actions, the default role required by an action
+	- admin     runs the privileged actions
+
+	Every channel sets the role of the messages it dispatches: the console and
+	the control socket are used by the administrators of the host, the other
+	channels give their users the role of their user or a configured one. An
+	empty or unknown role is granted nothing.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/a13labs/cobot/internal/algo"
+)
+
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+var ErrNotAuthorized = errors.New("not authorized")
+
+// TelegramIdentity identifies a user on Telegram, the id is preferred as the
+// username can be changed by its owner
+type TelegramIdentity struct {
+	Id       int64  `yaml:"id,omitempty"`
+	Username string `yaml:"username,omitempty"`
+}
+
+type UserDef struct {
+	Name     string           `yaml:"name"`
+	Role     string           `yaml:"role"`
+	Telegram TelegramIdentity `yaml:"telegram,omitempty"`
+}
+
+// ValidRole returns true if the given role exists
+func ValidRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+// HasRole returns true if the given role grants the required one, an empty or
+// unknown role is granted nothing
+func HasRole(role string, required string) bool {
+	level, ok := roleLevels[role]
+	return ok && level >= roleLevels[required]
+}
+
+// RequiredRole returns the role needed to run the action, privileged actions
+// always need an admin
+func (a *Action) RequiredRole() string {
+	if privileged, _ := a.Exec.Parameters["privileged"].(bool); privileged {
+		return RoleAdmin
+	}
+	if a.Role == "" {
+		return RoleOperator
+	}
+	return a.Role
+}
+
+// AllowedActions returns the names of the actions the given role can run
+func (adb *ActionDB) AllowedActions(role string) algo.StringList {
+	names := algo.StringList{}
+	for _, name := range adb.ActionNames {
+		action := adb.Actions[name]
+		if HasRole(role, action.RequiredRole()) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// FindTelegramUser returns the user with the given Telegram id or, for the
+// users declared without id, username
+func (cfg *AgentConfigFile) FindTelegramUser(id int64, username string) (UserDef, bool) {
+	for _, user := range cfg.Users {
+		if user.Telegram.Id != 0 && user.Telegram.Id == id {
+			return user, true
+		}
+	}
+	if username == "" {
+		return UserDef{}, false
+	}
+	for _, user := range cfg.Users {
+		if user.Telegram.Id == 0 && user.Telegram.Username == username {
+			return user, true
+		}
+	}
+	return UserDef{}, false
+}
+
+// TelegramUserName returns how an unknown Telegram user is reported
+func TelegramUserName(id int64, username string) string {
+	if username == "" {
+		return "telegram:" + strconv.FormatInt(id, 10)
+	}
+	return fmt.Sprintf("telegram:%d (@%s)", id, username)
+}
+
+// Authorize checks the sender of the message can run the action
+func (ctx *AgentCtx) Authorize(msg Message, action Action) error {
+	required := action.RequiredRole()
+	if !HasRole(msg.Role, required) {
+		return fmt.Errorf("%w: action '%s' requires the %s role, %s has the %s role", ErrNotAuthorized, action.Name, required, msg.User, msg.Role)
+	}
+	return nil
+}
+
+// AuditRefusal records a message that was refused, action is empty when the
+// message was refused before an action was selected
+func (ctx *AgentCtx) AuditRefusal(msg Message, action string, reason string) {
+	ctx.writeAudit(AuditRecord{
+		Time:    time.Now(),
+		Channel: msg.Channel,
+		User:    msg.User,
+		Message: ctx.Secrets.Redact(msg.Text),
+		Action:  action,
+		Result:  AuditRefused,
+		Error:   reason,
+	})
+}
@@ -0,0 +1,146 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+func TestRoles(t *testing.T) {
+	privileged := agent.Action{Name: "reboot", Exec: agent.ActionExecution{Parameters: map[string]interface{}{"privileged": true}}}
+	status := agent.Action{Name: "status", Role: agent.RoleViewer}
+	restart := agent.Action{Name: "restart"}
+
+	tests := []struct {
+		role   string
+		action agent.Action
+		allow  bool
+	}{
+		{agent.RoleViewer, status, true},
+		{agent.RoleViewer, restart, false},
+		{agent.RoleOperator, restart, true},
+		{agent.RoleOperator, privileged, false},
+		{agent.RoleAdmin, privileged, true},
+		{"", status, false},
+		{"root", status, false},
+	}
+
+	ctx := &agent.AgentCtx{}
+	for _, tt := range tests {
+		msg := agent.Message{Channel: "telegram", User: "alice", Role: tt.role}
+		err := ctx.Authorize(msg, tt.action)
+		if tt.allow && err != nil {
+			t.Errorf("Authorize(%s, %s) = %v; want allowed", tt.role, tt.action.Name, err)
+		}
+		if !tt.allow && !errors.Is(err, agent.ErrNotAuthorized) {
+			t.Errorf("Authorize(%s, %s) = %v; want ErrNotAuthorized", tt.role, tt.action.Name, err)
+		}
+	}
+}
+
+func TestAllowedActions(t *testing.T) {
+	db := &agent.ActionDB{
+		ActionNames: []string{"reboot", "status", "restart"},
+		Actions: map[string]agent.Action{
+			"reboot":  {Name: "reboot", Exec: agent.ActionExecution{Parameters: map[string]interface{}{"privileged": true}}},
+			"status":  {Name: "status", Role: agent.RoleViewer},
+			"restart": {Name: "restart"},
+		},
+	}
+
+	tests := map[string]string{
+		agent.RoleViewer:   "status",
+		agent.RoleOperator: "status,restart",
+		agent.RoleAdmin:    "reboot,status,restart",
+		"":                 "",
+	}
+	for role, want := range tests {
+		if got := strings.Join(db.AllowedActions(role), ","); got != want {
+			t.Errorf("AllowedActions(%q) = %q; want %q", role, got, want)
+		}
+	}
+}
+
+func TestFindTelegramUser(t *testing.T) {
+	cfg := agent.AgentConfigFile{Users: []agent.UserDef{
+		{Name: "alice", Role: agent.RoleAdmin, Telegram: agent.TelegramIdentity{Id: 1, Username: "alice"}},
+		{Name: "bob", Role: agent.RoleViewer, Telegram: agent.TelegramIdentity{Username: "bob"}},
+	}}
+
+	if user, ok := cfg.FindTelegramUser(1, "renamed"); !ok || user.Name != "alice" {
+		t.Errorf("FindTelegramUser(1) = %v, %v", user, ok)
+	}
+	if user, ok := cfg.FindTelegramUser(2, "bob"); !ok || user.Name != "bob" {
+		t.Errorf("FindTelegramUser(bob) = %v, %v", user, ok)
+	}
+	// A username declared along with an id does not match other ids
+	if _, ok := cfg.FindTelegramUser(3, "alice"); ok {
+		t.Errorf("FindTelegramUser(3, alice) matched")
+	}
+}
+
+func TestRefusedActionIsAudited(t *testing.T) {
+	s := agent.NewMemStorage()
+	audit, err := agent.OpenAuditLog(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &agent.AgentCtx{Storage: s, Audit: audit}
+
+	action := agent.Action{Name: "restart", Exec: agent.ActionExecution{Plugin: "echo", Parameters: map[string]interface{}{"command": "restart"}}}
+	msg := agent.Message{Channel: "telegram", User: "bob", Role: agent.RoleViewer, Text: "restart nginx"}
+
+	if _, err := ctx.ExecuteAction(context.Background(), msg, action, nil); !errors.Is(err, agent.ErrNotAuthorized) {
+		t.Fatalf("ExecuteAction() error = %v; want ErrNotAuthorized", err)
+	}
+
+	records, err := agent.ReadAuditLog(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Result != agent.AuditRefused || records[0].User != "bob" || !strings.Contains(records[0].Error, "operator") {
+		t.Errorf("audit records = %+v", records)
+	}
+}
+
+func TestValidateUsers(t *testing.T) {
+	s := agent.NewMemStorageFromMap(map[string]string{
+		"agent-config.yaml": `agent:
+  name: tester
+actions:
+  - status
+users:
+  - name: alice
+    role: admin
+    telegram:
+      id: 1
+  - name: bob
+    role: root
+    telegram:
+      id: 1
+  - name: carol
+    role: viewer
+`,
+		"actions/status.yaml": "description: status\nname: status\nrole: guest\nexec:\n  plugin: echo\n",
+	})
+
+	var got []string
+	for _, issue := range agent.ValidateStorage(s) {
+		got = append(got, issue.String())
+	}
+	all := strings.Join(got, "\n")
+
+	for _, e := range []string{
+		"agent-config.yaml:11: user 'bob' has an invalid role 'root'",
+		"agent-config.yaml:10: telegram id 1 is used by users 'alice' and 'bob'",
+		"agent-config.yaml:14: user 'carol' has no identity on any channel",
+		"actions/status.yaml:3: invalid role 'guest'",
+	} {
+		if !strings.Contains(all, e) {
+			t.Errorf("missing issue %q in:\n%s", e, all)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package agent
+
+/*
+	A channel connects the agent to its users (console, telegram, ...). Several
+	channels can be attached to the same agent, each message received carries the
+	channel and the conversation it came from so the replies are routed back to
+	the sender.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ChannelCapabilities describes what a channel is able to deliver
+type ChannelCapabilities struct {
+	// MaxMessageLength is the longest text a single message can hold, longer
+	// replies are split. Zero means unlimited.
+	MaxMessageLength int
+	// Markdown is true when the channel renders markdown
+	Markdown bool
+}
+
+// Channel is implemented by every way of talking to the agent
+type Channel interface {
+	// Name identifies the channel, it is used as Message.Channel
+	Name() string
+	// Start receives messages and dispatches them to the agent, it blocks until
+	// the given context is done or the channel is closed
+	Start(ctx context.Context, agent *AgentCtx) error
+	// Send delivers a text to the given conversation
+	Send(conversation string, text string) error
+	// Capabilities reports what the channel is able to deliver
+	Capabilities() ChannelCapabilities
+}
+
+var ErrChannelNotFound = errors.New("channel not found")
+
+// AddChannel attaches a channel to the agent, replies to messages received from
+// the channel are sent through it
+func (ctx *AgentCtx) AddChannel(channel Channel) error {
+	ctx.channelsMu.Lock()
+	defer ctx.channelsMu.Unlock()
+
+	if ctx.channels == nil {
+		ctx.channels = map[string]Channel{}
+	}
+	if _, exist := ctx.channels[channel.Name()]; exist {
+		return fmt.Errorf("channel %s already attached", channel.Name())
+	}
+	ctx.channels[channel.Name()] = channel
+	return nil
+}
+
+// GetChannel returns the attached channel with the given name
+func (ctx *AgentCtx) GetChannel(name string) (Channel, bool) {
+	ctx.channelsMu.RLock()
+	defer ctx.channelsMu.RUnlock()
+
+	channel, ok := ctx.channels[name]
+	return channel, ok
+}
+
+// ChannelNames returns the names of the attached channels, sorted
+func (ctx *AgentCtx) ChannelNames() []string {
+	ctx.channelsMu.RLock()
+	defer ctx.channelsMu.RUnlock()
+
+	names := make([]string, 0, len(ctx.channels))
+	for name := range ctx.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Deliver sends a reply through the channel it is addressed to, the text is split
+// when it is longer than the channel accepts, or sent as a file when possible
+func (ctx *AgentCtx) Deliver(reply Reply) error {
+	channel, ok := ctx.GetChannel(reply.Channel)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrChannelNotFound, reply.Channel)
+	}
+
+	maxLength := channel.Capabilities().MaxMessageLength
+	if sender, ok := channel.(FileSender); ok && reply.FileName != "" && maxLength > 0 && len(reply.Text) > maxLength {
+		return sender.SendFile(reply.Conversation, reply.FileName, []byte(reply.Text))
+	}
+
+	for _, part := range SplitMessage(reply.Text, maxLength) {
+		if err := channel.Send(reply.Conversation, part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SplitMessage splits a text in parts no longer than the given length, lines
+// are kept whole whenever possible. A length of zero returns the text as is.
+func SplitMessage(text string, length int) []string {
+	if length <= 0 || len(text) <= length {
+		return []string{text}
+	}
+
+	var parts []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			parts = append(parts, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if current.Len()+len(line) > length {
+			flush()
+		}
+		for len(line) > length {
+			// Never cut a multi-byte character
+			cut := length
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				cut = length
+			}
+			parts = append(parts, line[:cut])
+			line = line[cut:]
+		}
+		current.WriteString(line)
+	}
+	flush()
+	return parts
+}
+
+// Event types sent to the channels able to report more than text replies
+const (
+	EventReply          = "reply"
+	EventActionStarted  = "action_started"
+	EventActionFinished = "action_finished"
+	EventError          = "error"
+)
+
+// Event is something that happened in a conversation
+type Event struct {
+	Type   string `json:"type"`
+	Action string `json:"action,omitempty"`
+	Text   string `json:"text,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// EventSender is implemented by the channels reporting the action progress to
+// their users, the other channels only receive the replies
+type EventSender interface {
+	SendEvent(conversation string, event Event) error
+}
+
+// notify sends an event to the conversation of the given message when its
+// channel is an EventSender
+func (ctx *AgentCtx) notify(msg Message, event Event) {
+
+	logger := GetLogger()
+
+	channel, ok := ctx.GetChannel(msg.Channel)
+	if !ok {
+		return
+	}
+	sender, ok := channel.(EventSender)
+	if !ok {
+		return
+	}
+	event.Text = ctx.Secrets.Redact(event.Text)
+	event.Error = ctx.Secrets.Redact(event.Error)
+	if err := sender.SendEvent(msg.Conversation, event); err != nil {
+		logger.Error("Error sending event to %s: %s", msg.Channel, err)
+	}
+}
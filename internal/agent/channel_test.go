@@ -0,0 +1,98 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+type fakeChannel struct {
+	name      string
+	maxLength int
+	mu        sync.Mutex
+	sent      map[string][]string
+}
+
+func (c *fakeChannel) sentTo(conversation string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.sent[conversation]...)
+}
+
+func (c *fakeChannel) Name() string { return c.name }
+
+func (c *fakeChannel) Start(ctx context.Context, agentCtx *agent.AgentCtx) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (c *fakeChannel) Send(conversation string, text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sent == nil {
+		c.sent = map[string][]string{}
+	}
+	c.sent[conversation] = append(c.sent[conversation], text)
+	return nil
+}
+
+func (c *fakeChannel) Capabilities() agent.ChannelCapabilities {
+	return agent.ChannelCapabilities{MaxMessageLength: c.maxLength}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		text   string
+		length int
+		want   []string
+	}{
+		{"hello", 0, []string{"hello"}},
+		{"hello", 10, []string{"hello"}},
+		{"one\ntwo\nthree", 8, []string{"one\ntwo\n", "three"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"ééé", 3, []string{"é", "é", "é"}},
+	}
+	for _, tt := range tests {
+		got := agent.SplitMessage(tt.text, tt.length)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitMessage(%q, %d) = %q; want %q", tt.text, tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestDeliverRoutesToChannel(t *testing.T) {
+	ctx := &agent.AgentCtx{}
+	console := &fakeChannel{name: "console"}
+	telegram := &fakeChannel{name: "telegram", maxLength: 5}
+
+	for _, channel := range []agent.Channel{console, telegram} {
+		if err := ctx.AddChannel(channel); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ctx.AddChannel(&fakeChannel{name: "console"}); err == nil {
+		t.Errorf("AddChannel() accepted a duplicated channel")
+	}
+	if names := ctx.ChannelNames(); !reflect.DeepEqual(names, []string{"console", "telegram"}) {
+		t.Errorf("ChannelNames() = %v", names)
+	}
+
+	if err := ctx.Deliver(agent.Reply{Channel: "telegram", Conversation: "42", Text: "hello world"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(telegram.sent["42"], "|"); got != "hello| worl|d" {
+		t.Errorf("telegram received %q", got)
+	}
+	if len(console.sent) != 0 {
+		t.Errorf("console received %v", console.sent)
+	}
+
+	if err := ctx.Deliver(agent.Reply{Channel: "email", Text: "hi"}); !errors.Is(err, agent.ErrChannelNotFound) {
+		t.Errorf("Deliver() error = %v; want ErrChannelNotFound", err)
+	}
+}
@@ -0,0 +1,153 @@
+package agent
+
+/*
+	Chat commands start with '/' and are handled by the agent itself, they never
+	reach the LLM:
+	- /help        list the chat commands
+	- /actions     list the actions available
+	- /kb [path]   show the knowledge base, or the entry at the given dotted path
+	- /reload      load the configuration and the actions again (admin role)
+	- /audit [n]   show the last n audit records (default 10)
+	- /cancel      cancel the request being processed, see workers.go
+*/
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+)
+
+const defaultAuditHistory = 10
+
+// IsChatCommand returns true if the given input is a chat command
+func IsChatCommand(text string) bool {
+	return strings.HasPrefix(strings.TrimSpace(text), "/")
+}
+
+// ErrUnknownCommand is returned for the chat commands the agent does not know
+var ErrUnknownCommand = errors.New("unknown command")
+
+const chatCommandsHelp = `/help        list the chat commands
+/actions     list the actions available
+/kb [path]   show the knowledge base, or the entry at the given dotted path
+/reload      load the configuration and the actions again
+/audit [n]   show the last n audit records
+/cancel      cancel your request being processed`
+
+func (ctx *AgentCtx) handleChatCommand(msg Message) error {
+
+	logger := GetLogger().ForMessage(msg)
+
+	fields := strings.Fields(msg.Text)
+	command, args := fields[0], fields[1:]
+
+	switch command {
+	case "/help":
+		ctx.Reply(msg, chatCommandsHelp)
+	case "/actions":
+		ctx.Reply(msg, actionList(ctx.actionsOf(msg), msg.Role))
+	case "/kb":
+		ctx.Reply(msg, knowledgeBaseEntry(ctx.configOf(msg).KnowledgeBase, args))
+	case "/reload":
+		if !HasRole(msg.Role, RoleAdmin) {
+			ctx.AuditRefusal(msg, "", "reloading the configuration requires the admin role")
+			ctx.Reply(msg, "You are not allowed to reload the configuration.")
+			return ErrNotAuthorized
+		}
+		if err := ctx.Reload(); err != nil {
+			logger.Error("Error reloading the configuration: %s", err)
+			ctx.Reply(msg, fmt.Sprintf("The configuration could not be reloaded: %s", err))
+			return err
+		}
+		ctx.Reply(msg, fmt.Sprintf("Configuration reloaded, %d actions available.", len(ctx.Actions().ActionNames)))
+	case "/audit":
+		if !HasRole(msg.Role, RoleOperator) {
+			ctx.AuditRefusal(msg, "", "the audit log requires the operator role")
+			ctx.Reply(msg, "You are not allowed to read the audit log.")
+			return ErrNotAuthorized
+		}
+		ctx.Reply(msg, ctx.auditHistory(args))
+	default:
+		ctx.Reply(msg, fmt.Sprintf("Unknown command %s, try /help", command))
+		return fmt.Errorf("%w: %s", ErrUnknownCommand, command)
+	}
+	return nil
+}
+
+// actionList lists the actions the given role can run
+func actionList(actionDB *ActionDB, role string) string {
+	if actionDB == nil {
+		return "No actions are available"
+	}
+	names := actionDB.AllowedActions(role)
+	if len(names) == 0 {
+		return "No actions are available"
+	}
+	sort.Strings(names)
+
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = fmt.Sprintf("%s: %s", name, actionDB.Actions[name].Description)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func knowledgeBaseEntry(kb map[string]interface{}, args []string) string {
+	if len(args) > 1 {
+		return "Usage: /kb [path]"
+	}
+
+	var entry interface{} = kb
+	if len(args) == 1 {
+		value, ok := KnowledgeBaseLookup(kb, strings.Split(args[0], "."))
+		if !ok {
+			return fmt.Sprintf("No knowledge base entry at %s", args[0])
+		}
+		entry = value
+	}
+	if entry == nil || len(kb) == 0 {
+		return "The knowledge base is empty"
+	}
+
+	data, err := yaml.Marshal(entry)
+	if err != nil {
+		return fmt.Sprintf("Error formatting the knowledge base: %s", err)
+	}
+	return strings.TrimRight(string(data), "\n")
+}
+
+func (ctx *AgentCtx) auditHistory(args []string) string {
+
+	logger := GetLogger()
+
+	n := defaultAuditHistory
+	if len(args) > 0 {
+		value, err := strconv.Atoi(args[0])
+		if err != nil || value <= 0 {
+			return "Usage: /audit [number of records]"
+		}
+		n = value
+	}
+
+	if ctx.Audit == nil {
+		return "The audit log is not available"
+	}
+	records, err := ctx.Audit.Recent(n)
+	if err != nil {
+		logger.Error("Error reading audit log: %s", err)
+		return "Error reading the audit log"
+	}
+	if len(records) == 0 {
+		return "No actions were executed yet"
+	}
+
+	lines := make([]string, len(records))
+	for i := range records {
+		lines[i] = records[i].String()
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,276 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/a13labs/cobot/internal/metrics"
+	"github.com/a13labs/cobot/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrPluginNotFound     = errors.New("plugin not found")
+	ErrPrivilegedNotAllow = errors.New("privileged actions are not allowed on this agent")
+	ErrNoActionFound      = errors.New("no action found")
+	ErrMissingArguments   = errors.New("missing arguments")
+	ErrActionCancelled    = errors.New("action cancelled")
+	// ErrActionFailed wraps the error of an action that ran and failed
+	ErrActionFailed = errors.New("action failed")
+	// ErrQuotedPlaceholder is returned for a placeholder written inside quotes
+	// in the parameters of a plugin quoting the values itself
+	ErrQuotedPlaceholder = errors.New("placeholder inside quotes")
+)
+
+// runAction extracts the action arguments from the message, runs the action
+// and informs the user about the result. The error tells why the action did
+// not run or why it failed.
+func (ctx *AgentCtx) runAction(msg Message, actionName string) error {
+
+	logger := GetLogger().ForMessage(msg).With(FieldAction, actionName)
+
+	action, err := ctx.actionsOf(msg).GetAction(actionName)
+	if err != nil {
+		return ctx.failRequest(msg, ErrInternal, err)
+	}
+
+	if err := ctx.Authorize(msg, action); err != nil {
+		logger.Warning("Refused action %s: %s", actionName, err)
+		ctx.AuditRefusal(msg, actionName, err.Error())
+		ctx.Reply(msg, fmt.Sprintf("You are not allowed to run the action '%s'.", actionName))
+		return err
+	}
+
+	kb := ctx.configOf(msg).KnowledgeBase
+
+	start := time.Now()
+	args, err := extractArguments(ctx, msg.Context(), msg.Text, action, kb)
+	metrics.ObserveStage(metrics.StageArguments, start)
+	if err != nil {
+		if cause := cancellation(msg); cause != nil {
+			return ctx.failRequest(msg, cause, err)
+		}
+		logger.Error("Error extracting arguments for action %s: %s", actionName, err)
+		ctx.Inform(msg, fmt.Sprintf("It was not possible to understand the arguments of the action '%s'. No action will be taken.", actionName))
+		return fmt.Errorf("%w: %w", ErrMissingArguments, err)
+	}
+
+	var missing []string
+	for _, arg := range action.Args {
+		if strings.TrimSpace(args[arg]) == "" {
+			missing = append(missing, arg)
+		}
+	}
+	if len(missing) > 0 {
+		ctx.Inform(msg, fmt.Sprintf("The action '%s' requires a value for: %s. No action will be taken.", actionName, strings.Join(missing, ", ")))
+		return fmt.Errorf("%w: %s", ErrMissingArguments, strings.Join(missing, ", "))
+	}
+
+	args, err = CheckArguments(action, args, kb)
+	if err != nil {
+		logger.Warning("Refused the arguments of action %s: %s", actionName, err)
+		ctx.Inform(msg, fmt.Sprintf("The action '%s' can't run: %s. No action will be taken.", actionName, err))
+		return err
+	}
+
+	// The files are given in the order the action declares them
+	if len(msg.Attachments) < len(action.Files) {
+		ctx.Inform(msg, fmt.Sprintf("The action '%s' requires the files: %s. No action will be taken.", actionName, strings.Join(action.Files, ", ")))
+		return fmt.Errorf("%w: %s", ErrMissingArguments, strings.Join(action.Files, ", "))
+	}
+	for i, file := range action.Files {
+		args[file] = ctx.AttachmentPath(msg.Attachments[i])
+	}
+
+	if action.Confirm {
+		confirmed, err := ctx.Confirm(msg, fmt.Sprintf("Do you want to run the action '%s'?", actionName))
+		if err != nil {
+			if cause := cancellation(msg); cause != nil {
+				return ctx.failRequest(msg, cause, err)
+			}
+			logger.Error("Error confirming action %s: %s", actionName, err)
+			ctx.Inform(msg, fmt.Sprintf("The action '%s' was not confirmed. No action will be taken.", actionName))
+			return fmt.Errorf("%w: %w", ErrActionCancelled, err)
+		}
+		if !confirmed {
+			ctx.Inform(msg, fmt.Sprintf("The action '%s' was cancelled by the user. No action will be taken.", actionName))
+			return ErrActionCancelled
+		}
+	}
+
+	logger.Info("Running action %s", actionName)
+	output, err := ctx.ExecuteAction(msg.Context(), msg, action, args)
+	if err != nil {
+		if cause := cancellation(msg); cause != nil {
+			return ctx.failRequest(msg, cause, fmt.Errorf("%w: %w", ErrActionFailed, err))
+		}
+		logger.Warning("Action %s failed: %s", actionName, err)
+		ctx.Inform(msg, fmt.Sprintf("The action '%s' failed: %s", actionName, err))
+		return fmt.Errorf("%w: %w", ErrActionFailed, err)
+	}
+	ctx.Inform(msg, fmt.Sprintf("The action '%s' completed successfully.", actionName))
+	if strings.TrimSpace(output) != "" {
+		ctx.ReplyFile(msg, actionName+"-output.txt", output)
+	}
+	return nil
+}
+
+// ParameterResolver returns the resolver used to render the parameters of an
+// action for the given arguments, secrets are resolved only when withSecrets
+// is true, otherwise they are redacted. The values are quoted with quote when
+// it is not nil.
+func (ctx *AgentCtx) ParameterResolver(args map[string]string, kb map[string]interface{}, withSecrets bool, quote func(string) string) PlaceholderResolver {
+	secrets := RedactedSecretResolver()
+	if withSecrets {
+		secrets = SecretResolver(ctx.Secrets)
+	}
+	resolver := ChainResolvers(
+		ArgumentResolver(args, kb),
+		KnowledgeBaseResolver(args, kb),
+		secrets,
+	)
+	if quote != nil {
+		resolver = QuotingResolver(resolver, quote)
+	}
+	return resolver
+}
+
+// ExecuteAction renders the action parameters, runs the action plugin and
+// writes the audit record. The plugin output is returned.
+func (ctx *AgentCtx) ExecuteAction(execCtx context.Context, msg Message, action Action, args map[string]string) (string, error) {
+
+	if err := ctx.Authorize(msg, action); err != nil {
+		ctx.AuditRefusal(msg, action.Name, err.Error())
+		return "", err
+	}
+
+	record := AuditRecord{
+		Time:    time.Now(),
+		Channel: msg.Channel,
+		User:    msg.User,
+		Message: ctx.Secrets.Redact(msg.Text),
+		Action:  action.Name,
+	}
+
+	ctx.notify(msg, Event{Type: EventActionStarted, Action: action.Name})
+
+	done := ctx.startJob(msg, action.Name)
+	defer done()
+
+	execCtx, span := tracing.Tracer(tracerName).Start(execCtx, "agent.action",
+		trace.WithAttributes(attribute.String(tracing.AttrAction, action.Name)))
+
+	start := time.Now()
+	output, err := ctx.executeAction(execCtx, ctx.configOf(msg), action, args, &record)
+	record.DurationMs = time.Since(start).Milliseconds()
+	metrics.ObserveStage(metrics.StageExecute, start)
+	metrics.ActionDuration.WithLabelValues(action.Name).Observe(time.Since(start).Seconds())
+
+	switch {
+	case err == nil:
+		record.Result = AuditSuccess
+	case errors.Is(err, ErrPrivilegedNotAllow):
+		record.Result = AuditRefused
+	default:
+		record.Result = AuditFailure
+	}
+	if err != nil {
+		record.Error = ctx.Secrets.Redact(err.Error())
+	}
+	record.Output = ctx.Secrets.Redact(output)
+
+	span.SetAttributes(attribute.String(tracing.AttrOutcome, record.Result))
+	ctx.endSpan(span, err)
+
+	ctx.writeAudit(record)
+
+	ctx.notify(msg, Event{Type: EventActionFinished, Action: action.Name, Text: record.Output, Error: record.Error})
+
+	return output, err
+}
+
+func (ctx *AgentCtx) executeAction(execCtx context.Context, cfg AgentConfigFile, action Action, args map[string]string, record *AuditRecord) (string, error) {
+
+	args, err := CheckArguments(action, args, cfg.KnowledgeBase)
+	if err != nil {
+		return "", err
+	}
+
+	plugin, ok := GetPlugin(action.Exec.Plugin)
+	var quote func(string) string
+	if quoter, isQuoter := plugin.(ArgumentQuoter); isQuoter {
+		quote = quoter.QuoteArgument
+		if quoted := quotedParameterPlaceholders(action.Exec.Parameters); len(quoted) > 0 {
+			return "", fmt.Errorf("%w: %s", ErrQuotedPlaceholder, quoted[0])
+		}
+	}
+
+	// The audited parameters never hold secret values
+	redacted, err := RenderParameters(action.Exec.Parameters, ctx.ParameterResolver(args, cfg.KnowledgeBase, false, quote))
+	if err != nil {
+		return "", err
+	}
+	record.Parameters = redacted
+
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrPluginNotFound, action.Exec.Plugin)
+	}
+
+	if privileged, _ := action.Exec.Parameters["privileged"].(bool); privileged && !cfg.Agent.AllowPrivileged {
+		return "", ErrPrivilegedNotAllow
+	}
+
+	params, err := RenderParameters(action.Exec.Parameters, ctx.ParameterResolver(args, cfg.KnowledgeBase, true, quote))
+	if err != nil {
+		return "", err
+	}
+
+	execCtx, span := tracing.Tracer(tracerName).Start(execCtx, "plugin.execute",
+		trace.WithAttributes(attribute.String(tracing.AttrPlugin, action.Exec.Plugin)))
+	output, err := plugin.Execute(execCtx, params)
+	ctx.endSpan(span, err)
+	return output, err
+}
+
+// quotedParameterPlaceholders returns the placeholders written inside quotes
+// in the string values of the given parameters
+func quotedParameterPlaceholders(value interface{}) []Placeholder {
+	var quoted []Placeholder
+	switch v := value.(type) {
+	case string:
+		quoted = QuotedPlaceholders(v)
+	case map[string]interface{}:
+		for _, item := range v {
+			quoted = append(quoted, quotedParameterPlaceholders(item)...)
+		}
+	case map[interface{}]interface{}:
+		for _, item := range v {
+			quoted = append(quoted, quotedParameterPlaceholders(item)...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			quoted = append(quoted, quotedParameterPlaceholders(item)...)
+		}
+	}
+	return quoted
+}
+
+func (ctx *AgentCtx) writeAudit(record AuditRecord) {
+
+	logger := GetLogger()
+
+	if record.Action != "" {
+		metrics.Actions.WithLabelValues(record.Action, record.Result).Inc()
+	}
+
+	if ctx.Audit == nil {
+		return
+	}
+	if _, err := ctx.Audit.Append(record); err != nil {
+		logger.Error("Error writing audit record: %s", err)
+	}
+}
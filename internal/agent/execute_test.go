@@ -0,0 +1,76 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+// quotingPlugin returns its command, the arguments are quoted with brackets
+type quotingPlugin struct{ echoPlugin }
+
+func (p *quotingPlugin) QuoteArgument(value string) string {
+	return "[" + value + "]"
+}
+
+func init() {
+	agent.RegisterPlugin("quoting", &quotingPlugin{})
+}
+
+func TestExecuteActionChecksAndQuotesArguments(t *testing.T) {
+	ctx := &agent.AgentCtx{
+		Storage: agent.NewMemStorage(),
+		AgentCfg: agent.AgentConfigFile{
+			KnowledgeBase: map[string]interface{}{
+				"service": map[string]interface{}{
+					"nginx": map[string]interface{}{"unit": "nginx.service"},
+				},
+			},
+		},
+	}
+	action := agent.Action{
+		Name: "restart",
+		Args: []string{"service", "reason"},
+		Exec: agent.ActionExecution{
+			Plugin: "quoting",
+			Parameters: map[string]interface{}{
+				"command": "restart ${service} ${service.unit} ${reason}",
+			},
+		},
+	}
+	msg := agent.Message{Channel: "console", User: "alice", Role: agent.RoleAdmin, Text: "restart nginx"}
+
+	output, err := ctx.ExecuteAction(context.Background(), msg, action, map[string]string{"service": "NGINX", "reason": "it's stuck; reboot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "restart [nginx] [nginx.service] [it's stuck; reboot]"; output != want {
+		t.Errorf("output = %q; want %q", output, want)
+	}
+
+	_, err = ctx.ExecuteAction(context.Background(), msg, action, map[string]string{"service": "nginx; rm -rf ~", "reason": "none"})
+	if !errors.Is(err, agent.ErrUnknownArgument) || !strings.Contains(err.Error(), "known values: nginx") {
+		t.Errorf("ExecuteAction() error = %v; want ErrUnknownArgument", err)
+	}
+
+	// Nothing defined yet, the value is only quoted
+	ctx.AgentCfg.KnowledgeBase["service"] = map[string]interface{}{}
+	action.Exec.Parameters = map[string]interface{}{"command": "restart ${service} ${reason}"}
+	output, err = ctx.ExecuteAction(context.Background(), msg, action, map[string]string{"service": "sshd", "reason": "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "restart [sshd] [none]"; output != want {
+		t.Errorf("output = %q; want %q", output, want)
+	}
+
+	// A quoted value inside quotes would be interpreted by the shell
+	action.Exec.Parameters = map[string]interface{}{"command": `echo "restarting ${service}"`}
+	_, err = ctx.ExecuteAction(context.Background(), msg, action, map[string]string{"service": "sshd", "reason": "none"})
+	if !errors.Is(err, agent.ErrQuotedPlaceholder) {
+		t.Errorf("ExecuteAction() error = %v; want ErrQuotedPlaceholder", err)
+	}
+}
@@ -0,0 +1,202 @@
+package agent
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// GitStorage is a read-only storage serving files straight from a git commit,
+// no checkout is required. Any write operation returns ErrReadOnlyStorage,
+// except under local/ which is kept on the filesystem of the repository as it
+// holds the audit log, the secrets key and the state of the channels.
+type GitStorage struct {
+	revision string
+	commit   *object.Commit
+	tree     *object.Tree
+	local    *FileStorage
+}
+
+// isLocal tells whether a path is under the local/ folder
+func isLocal(p string) bool {
+	p = cleanStoragePath(p)
+	return p == "local" || strings.HasPrefix(p, "local/")
+}
+
+// NewGitStorage opens the repository at the given path and resolves the given
+// revision, it can be a branch, a tag or a commit hash.
+func NewGitStorage(repoPath string, revision string) (*GitStorage, error) {
+
+	logger := GetLogger()
+
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		logger.Error("storage path is not a valid git repository")
+		return nil, errors.New("storage path is not a valid git repository")
+	}
+
+	if revision == "" {
+		revision = "HEAD"
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		logger.Error("Error resolving revision %s", revision)
+		return nil, err
+	}
+
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		logger.Error("Error getting commit %s", hash.String())
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		logger.Error("Error getting tree for commit %s", hash.String())
+		return nil, err
+	}
+
+	return &GitStorage{
+		revision: revision,
+		commit:   commit,
+		tree:     tree,
+		local:    &FileStorage{localPath: repoPath},
+	}, nil
+}
+
+func (s *GitStorage) Stat(p string) (os.FileInfo, error) {
+
+	if p == "" {
+		return nil, errors.New("sub path is empty")
+	}
+
+	if isLocal(p) {
+		return s.local.Stat(p)
+	}
+
+	p = cleanStoragePath(p)
+	if p == "" {
+		return &fileInfo{name: ".", mode: fs.ModeDir | 0755, modTime: s.commit.Committer.When}, nil
+	}
+
+	entry, err := s.tree.FindEntry(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: p, Err: fs.ErrNotExist}
+	}
+
+	if entry.Mode == filemode.Dir {
+		return &fileInfo{name: entry.Name, mode: fs.ModeDir | 0755, modTime: s.commit.Committer.When}, nil
+	}
+
+	mode, err := entry.Mode.ToOSFileMode()
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := s.tree.Size(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileInfo{name: entry.Name, size: size, mode: mode, modTime: s.commit.Committer.When}, nil
+}
+
+func (s *GitStorage) ReadFile(p string) ([]byte, error) {
+
+	if p == "" {
+		return nil, errors.New("sub path is empty")
+	}
+
+	if isLocal(p) {
+		return s.local.ReadFile(p)
+	}
+
+	p = cleanStoragePath(p)
+	file, err := s.tree.File(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(content), nil
+}
+
+func (s *GitStorage) ListFiles(p string) ([]string, error) {
+
+	if p == "" {
+		return nil, errors.New("sub path is empty")
+	}
+
+	if isLocal(p) {
+		return s.local.ListFiles(p)
+	}
+
+	tree := s.tree
+	p = cleanStoragePath(p)
+	if p != "" {
+		var err error
+		tree, err = s.tree.Tree(p)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: p, Err: fs.ErrNotExist}
+		}
+	}
+
+	fileNames := make([]string, 0, len(tree.Entries))
+	for _, entry := range tree.Entries {
+		fileNames = append(fileNames, entry.Name)
+	}
+	sort.Strings(fileNames)
+
+	return fileNames, nil
+}
+
+func (s *GitStorage) WriteFile(p string, data []byte, perm fs.FileMode) error {
+	if isLocal(p) {
+		return s.local.WriteFile(p, data, perm)
+	}
+	return &fs.PathError{Op: "write", Path: path.Clean(p), Err: ErrReadOnlyStorage}
+}
+
+func (s *GitStorage) Mkdir(p string, perm fs.FileMode) error {
+	if isLocal(p) {
+		return s.local.Mkdir(p, perm)
+	}
+	return &fs.PathError{Op: "mkdir", Path: path.Clean(p), Err: ErrReadOnlyStorage}
+}
+
+func (s *GitStorage) Remove(p string) error {
+	if isLocal(p) {
+		return s.local.Remove(p)
+	}
+	return &fs.PathError{Op: "remove", Path: path.Clean(p), Err: ErrReadOnlyStorage}
+}
+
+func (s *GitStorage) AppendFile(p string, data []byte, perm fs.FileMode) error {
+	if isLocal(p) {
+		return s.local.AppendFile(p, data, perm)
+	}
+	return &fs.PathError{Op: "write", Path: path.Clean(p), Err: ErrReadOnlyStorage}
+}
+
+// LocalPath returns the path on the local filesystem of a file under local/
+func (s *GitStorage) LocalPath(p string) string {
+	return s.local.LocalPath(p)
+}
+
+// GetVersion returns the hash of the commit being served
+func (s *GitStorage) GetVersion() (string, error) {
+	return s.commit.Hash.String(), nil
+}
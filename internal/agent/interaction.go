@@ -1,13 +1,16 @@
 package agent
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/a13labs/cobot/internal/nlp"
 )
 
-func getEmbeddings(ctx *AgentCtx, text string) ([]float64, error) {
-	embeddings, err := ctx.LLMClient.EmbeddingRequest(&nlp.LLMEmbeddingRequest{Prompt: text})
+func getEmbeddings(ctx *AgentCtx, msgCtx context.Context, text string) ([]float64, error) {
+	embeddings, err := ctx.LLMClient.EmbeddingRequest(msgCtx, &nlp.LLMEmbeddingRequest{Prompt: text})
 	if err != nil {
 		return nil, err
 	}
@@ -15,43 +18,72 @@ func getEmbeddings(ctx *AgentCtx, text string) ([]float64, error) {
 	return embeddings, nil
 }
 
-func isItemInList(ctx *AgentCtx, prompt string, items []string) (bool, error) {
+func isItemInList(ctx *AgentCtx, msgCtx context.Context, prompt string, items []string) (bool, error) {
 	list := ""
 	for _, item := range items {
 		list += fmt.Sprintf("-'%s'\n", item)
 	}
 	instr := fmt.Sprintf("Given list:\n%s\nGiven input:'%s'\n.Any item in the given list similar or related to the given input? true or false?", list, prompt)
-	msg, err := ctx.LLMClient.BoolRequest(instr)
+	msg, err := ctx.LLMClient.BoolRequest(msgCtx, instr)
 	if err != nil {
 		return false, err
 	}
 	return msg, nil
 }
 
-func filterListItems(ctx *AgentCtx, prompt string, items []string) ([]int, error) {
+func filterListItems(ctx *AgentCtx, msgCtx context.Context, prompt string, items []string) ([]int, error) {
 	list := ""
 	for i, item := range items {
 		list += fmt.Sprintf("-ID:%d,Text:'%s'\n", i, item)
 	}
 	instr := fmt.Sprintf("Given list:\n%s\nGiven input:'%s'\n.List all items of the given list which the text is similar or related to what is requested in the given input.Write the IDs of all matched items.", list, prompt)
-	msg, err := ctx.LLMClient.IntListRequest(instr)
+	msg, err := ctx.LLMClient.IntListRequest(msgCtx, instr)
 	if err != nil {
 		return nil, err
 	}
 	return msg, nil
 }
 
-func isItAQuestion(ctx *AgentCtx, prompt string) (bool, error) {
+func isItAQuestion(ctx *AgentCtx, msgCtx context.Context, prompt string) (bool, error) {
 
 	instr := fmt.Sprintf("Given input:'%s'\n.'true' if it is a question, 'false' if not.", prompt)
 
-	msg, err := ctx.LLMClient.BoolRequest(instr)
+	msg, err := ctx.LLMClient.BoolRequest(msgCtx, instr)
 	if err != nil {
 		return false, err
 	}
 	return msg, nil
 }
 
-func generateAMessage(ctx *AgentCtx, prompt string) (string, error) {
-	return ctx.LLMClient.MessageRequest(prompt)
+var ErrNoLLMClient = errors.New("no LLM client configured")
+
+// generateAMessage asks the LLM for a message, written in the given language
+// when it is not empty
+func generateAMessage(ctx *AgentCtx, msgCtx context.Context, prompt string, language string) (string, error) {
+	if ctx.LLMClient == nil {
+		return "", ErrNoLLMClient
+	}
+	if language != "" {
+		prompt += fmt.Sprintf("Answer in %s.", language)
+	}
+	return ctx.LLMClient.MessageRequest(msgCtx, prompt)
+}
+
+func extractArguments(ctx *AgentCtx, msgCtx context.Context, prompt string, action Action, kb map[string]interface{}) (map[string]string, error) {
+	if len(action.Args) == 0 {
+		return map[string]string{}, nil
+	}
+
+	list := ""
+	for _, arg := range action.Args {
+		known := ""
+		if entries, ok := KnowledgeBaseLookup(kb, []string{arg}); ok {
+			if names := kbEntryNames(entries); len(names) > 0 {
+				known = fmt.Sprintf(",Known values:'%s'", strings.Join(names, "','"))
+			}
+		}
+		list += fmt.Sprintf("-Name:%s%s\n", arg, known)
+	}
+	instr := fmt.Sprintf("Given arguments:\n%s\nGiven input:'%s'\n.Extract the value of each given argument from the given input.Use an empty string if the value is not in the given input.", list, prompt)
+	return ctx.LLMClient.StringMapRequest(msgCtx, instr, action.Args)
 }
@@ -0,0 +1,123 @@
+package agent
+
+/*
+	A job is an action being executed. The agent keeps the running jobs so they
+	can be listed, with the status of the agent, by the control endpoint, and
+	waited for when the agent stops.
+*/
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Job is an action being executed
+type Job struct {
+	Id      uint64    `json:"id"`
+	Action  string    `json:"action"`
+	Channel string    `json:"channel"`
+	User    string    `json:"user"`
+	Started time.Time `json:"started"`
+}
+
+// Status is a snapshot of the running agent
+type Status struct {
+	Name     string        `json:"name"`
+	Version  string        `json:"version"`
+	Model    string        `json:"model"`
+	Started  time.Time     `json:"started"`
+	Uptime   time.Duration `json:"uptime"`
+	Actions  []string      `json:"actions"`
+	Channels []string      `json:"channels"`
+	Jobs     int           `json:"jobs"`
+}
+
+// Versioner is implemented by the storages able to tell the version they serve
+type Versioner interface {
+	GetVersion() (string, error)
+}
+
+// startJob registers an action execution, the returned function removes it
+func (ctx *AgentCtx) startJob(msg Message, action string) func() {
+	ctx.jobsMu.Lock()
+	defer ctx.jobsMu.Unlock()
+
+	if ctx.jobs == nil {
+		ctx.jobs = map[uint64]Job{}
+	}
+	ctx.lastJobId++
+	id := ctx.lastJobId
+	ctx.jobs[id] = Job{Id: id, Action: action, Channel: msg.Channel, User: msg.User, Started: time.Now()}
+
+	return func() {
+		ctx.jobsMu.Lock()
+		defer ctx.jobsMu.Unlock()
+		delete(ctx.jobs, id)
+		if len(ctx.jobs) == 0 && ctx.jobsIdle != nil {
+			close(ctx.jobsIdle)
+			ctx.jobsIdle = nil
+		}
+	}
+}
+
+// WaitJobs waits until no action is being executed or the context is done
+func (ctx *AgentCtx) WaitJobs(waitCtx context.Context) error {
+	ctx.jobsMu.Lock()
+	if len(ctx.jobs) == 0 {
+		ctx.jobsMu.Unlock()
+		return nil
+	}
+	if ctx.jobsIdle == nil {
+		ctx.jobsIdle = make(chan struct{})
+	}
+	idle := ctx.jobsIdle
+	ctx.jobsMu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-waitCtx.Done():
+		return waitCtx.Err()
+	}
+}
+
+// Jobs returns the actions being executed, the oldest first
+func (ctx *AgentCtx) Jobs() []Job {
+	ctx.jobsMu.Lock()
+	defer ctx.jobsMu.Unlock()
+
+	jobs := make([]Job, 0, len(ctx.jobs))
+	for _, job := range ctx.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Id < jobs[j].Id })
+	return jobs
+}
+
+// Status returns a snapshot of the agent
+func (ctx *AgentCtx) Status() Status {
+
+	logger := GetLogger()
+
+	status := Status{
+		Name:     ctx.GetAgentName(),
+		Model:    ctx.UserArgs.LLMModel,
+		Started:  ctx.StartTime,
+		Uptime:   time.Since(ctx.StartTime).Round(time.Second),
+		Channels: ctx.ChannelNames(),
+		Jobs:     len(ctx.Jobs()),
+	}
+	if actionDB := ctx.Actions(); actionDB != nil {
+		status.Actions = append([]string{}, actionDB.ActionNames...)
+		sort.Strings(status.Actions)
+	}
+	if versioner, ok := ctx.Storage.(Versioner); ok {
+		version, err := versioner.GetVersion()
+		if err != nil {
+			logger.Warning("Error reading the storage version: %s", err)
+		}
+		status.Version = version
+	}
+	return status
+}
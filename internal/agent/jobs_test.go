@@ -0,0 +1,73 @@
+package agent_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+// blockingPlugin runs until its release channel is closed
+type blockingPlugin struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingPlugin) Execute(ctx context.Context, params map[string]interface{}) (string, error) {
+	p.started <- struct{}{}
+	<-p.release
+	return "done", nil
+}
+
+var blocking = &blockingPlugin{started: make(chan struct{}, 1), release: make(chan struct{})}
+
+func init() {
+	agent.RegisterPlugin("blocking", blocking)
+}
+
+func TestRunningActionsAreListedAsJobs(t *testing.T) {
+	ctx := &agent.AgentCtx{
+		Storage:   agent.NewMemStorage(),
+		UserArgs:  agent.AgentStartArgs{LLMModel: "mistral"},
+		StartTime: time.Now().Add(-time.Minute),
+	}
+	action := agent.Action{Name: "slow", Exec: agent.ActionExecution{Plugin: "blocking"}}
+	msg := agent.Message{Channel: "console", User: "alice", Role: agent.RoleAdmin, Text: "run slow"}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ctx.ExecuteAction(context.Background(), msg, action, nil)
+		done <- err
+	}()
+	<-blocking.started
+
+	jobs := ctx.Jobs()
+	if len(jobs) != 1 || jobs[0].Action != "slow" || jobs[0].User != "alice" || jobs[0].Channel != "console" {
+		t.Fatalf("Jobs() = %+v", jobs)
+	}
+	status := ctx.Status()
+	if status.Jobs != 1 || status.Model != "mistral" || status.Uptime < time.Minute {
+		t.Errorf("Status() = %+v", status)
+	}
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := ctx.WaitJobs(waitCtx); err != context.DeadlineExceeded {
+		t.Errorf("WaitJobs() = %v while the action runs", err)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- ctx.WaitJobs(context.Background()) }()
+
+	close(blocking.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-waited; err != nil {
+		t.Errorf("WaitJobs() = %v once the action finished", err)
+	}
+	if jobs := ctx.Jobs(); len(jobs) != 0 {
+		t.Errorf("Jobs() = %+v once the action finished", jobs)
+	}
+}
@@ -0,0 +1,167 @@
+package agent
+
+/*
+	The knowledge base is defined in the agent configuration, it holds the
+	entities the actions work with, grouped by collection:
+
+	knowledge_base:
+	  computer:
+	    fedora:
+	      mac: 00:68:EB:A7:75:54
+
+	Action parameters refer to it with ${kb:computer.mac}, where 'computer' is
+	an action argument selecting one of the entries of the collection.
+
+	An argument with a collection of the same name only accepts the names of
+	its entries, even when the collection is empty: the values extracted from
+	the message never reach a command line unless they are known.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// KnowledgeBaseLookup walks the given path in the knowledge base
+func KnowledgeBaseLookup(root interface{}, segments []string) (interface{}, bool) {
+	value := root
+	for _, segment := range segments {
+		switch m := value.(type) {
+		case map[string]interface{}:
+			v, ok := m[segment]
+			if !ok {
+				return nil, false
+			}
+			value = v
+		case map[interface{}]interface{}:
+			v, ok := m[segment]
+			if !ok {
+				return nil, false
+			}
+			value = v
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// kbEntries returns the entries of a collection, ok is false when the value is
+// not a collection
+func kbEntries(collection interface{}) ([]interface{}, bool) {
+	var entries []interface{}
+	switch m := collection.(type) {
+	case map[string]interface{}:
+		for _, v := range m {
+			entries = append(entries, v)
+		}
+	case map[interface{}]interface{}:
+		for _, v := range m {
+			entries = append(entries, v)
+		}
+	case nil:
+	default:
+		return nil, false
+	}
+	return entries, true
+}
+
+// kbEntryNames returns the names of the entries of a collection, sorted
+func kbEntryNames(collection interface{}) []string {
+	var names []string
+	switch m := collection.(type) {
+	case map[string]interface{}:
+		for k := range m {
+			names = append(names, k)
+		}
+	case map[interface{}]interface{}:
+		for k := range m {
+			names = append(names, fmt.Sprint(k))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ErrUnknownArgument is returned for an argument naming no entry of its
+// knowledge base collection
+var ErrUnknownArgument = errors.New("unknown argument value")
+
+// CheckArguments returns the arguments of an action with the values of the
+// arguments selecting a knowledge base entry replaced by the entry name, an
+// ErrUnknownArgument is returned when a value names no entry. An empty
+// collection accepts any value, the plugin quotes it.
+func CheckArguments(action Action, args map[string]string, kb map[string]interface{}) (map[string]string, error) {
+	checked := make(map[string]string, len(args))
+	for arg, value := range args {
+		checked[arg] = value
+	}
+	for _, arg := range action.Args {
+		collection, ok := KnowledgeBaseLookup(kb, []string{arg})
+		if !ok || checked[arg] == "" {
+			continue
+		}
+		names := kbEntryNames(collection)
+		if len(names) == 0 {
+			continue
+		}
+		entry := ""
+		for _, name := range names {
+			if strings.EqualFold(name, strings.TrimSpace(checked[arg])) {
+				entry = name
+				break
+			}
+		}
+		if entry == "" {
+			return nil, fmt.Errorf("%w: '%s' is not a known %s, known values: %s", ErrUnknownArgument, checked[arg], arg, strings.Join(names, ", "))
+		}
+		checked[arg] = entry
+	}
+	return checked, nil
+}
+
+// ArgumentResolver resolves the ${arg} and ${arg.field} placeholders. ${arg} and
+// ${arg.name} are replaced by the argument value, any other field is taken from
+// the knowledge base entry selected by the argument.
+func ArgumentResolver(args map[string]string, kb map[string]interface{}) PlaceholderResolver {
+	return func(p Placeholder) (string, bool, error) {
+		if p.Kind != "" {
+			return "", false, nil
+		}
+		segments := strings.Split(p.Name, ".")
+		value, ok := args[segments[0]]
+		if !ok || value == "" {
+			return "", false, fmt.Errorf("argument '%s' has no value", segments[0])
+		}
+		if len(segments) == 1 || (len(segments) == 2 && segments[1] == "name") {
+			return value, true, nil
+		}
+		field, ok := KnowledgeBaseLookup(kb, append([]string{segments[0], value}, segments[1:]...))
+		if !ok {
+			return "", false, fmt.Errorf("'%s' not found in the knowledge base for %s '%s'", strings.Join(segments[1:], "."), segments[0], value)
+		}
+		return fmt.Sprint(field), true, nil
+	}
+}
+
+// KnowledgeBaseResolver resolves the ${kb:path} placeholders, the path is either
+// absolute or relative to the entry selected by an argument
+func KnowledgeBaseResolver(args map[string]string, kb map[string]interface{}) PlaceholderResolver {
+	return func(p Placeholder) (string, bool, error) {
+		if p.Kind != "kb" {
+			return "", false, nil
+		}
+		segments := strings.Split(p.Name, ".")
+		if value, ok := args[segments[0]]; ok && value != "" {
+			if field, ok := KnowledgeBaseLookup(kb, append([]string{segments[0], value}, segments[1:]...)); ok {
+				return fmt.Sprint(field), true, nil
+			}
+		}
+		if field, ok := KnowledgeBaseLookup(kb, segments); ok {
+			return fmt.Sprint(field), true, nil
+		}
+		return "", false, fmt.Errorf("'%s' not found in the knowledge base", p.Name)
+	}
+}
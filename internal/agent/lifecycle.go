@@ -0,0 +1,192 @@
+package agent
+
+/*
+	The agent processes the messages and delivers the replies on goroutines
+	started by Start. Shutdown stops them:
+	- the new messages, and the ones waiting in their session, are refused with
+	  ErrAgentStopped
+	- the messages being processed, and their actions, are given until the
+	  deadline of the shutdown context to finish, they are cancelled afterwards
+	- the replies sent so far are delivered, then the log file is closed
+
+	Cancelling the context given to Start shuts the agent down without waiting.
+
+	A request failing before the user could be told why is replied with its
+	request id, the same id is in the logs and in the returned RequestError.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrAgentStarted    = errors.New("agent already started")
+	ErrAgentNotStarted = errors.New("agent not started")
+	ErrAgentStopped    = errors.New("agent stopped")
+	// ErrShutdownTimeout is returned by Shutdown when the work in flight was
+	// cancelled as it did not finish in time
+	ErrShutdownTimeout = errors.New("shutdown deadline exceeded, the work in flight was cancelled")
+	// ErrLLMRequest wraps the errors of the LLM server
+	ErrLLMRequest = errors.New("the language model could not be reached")
+	// ErrInternal wraps the unexpected errors, including panics
+	ErrInternal = errors.New("internal error")
+)
+
+// Time given to the cancelled work to return once the shutdown deadline is
+// exceeded, it is abandoned afterwards
+const cancelGrace = 5 * time.Second
+
+// RequestError is the error of a request that failed before the user was told
+// why, the user is replied with the request id
+type RequestError struct {
+	RequestId string
+	Err       error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("request %s: %s", e.RequestId, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Start starts processing the messages and delivering the replies, until
+// Shutdown is called or the given context is done
+func (ctx *AgentCtx) Start(startCtx context.Context) error {
+	ctx.lifecycleMu.Lock()
+	defer ctx.lifecycleMu.Unlock()
+
+	if ctx.stopping != nil {
+		return ErrAgentStarted
+	}
+
+	if ctx.InputChannel == nil {
+		ctx.InputChannel = make(chan Message)
+	}
+	if ctx.OutputChannel == nil {
+		ctx.OutputChannel = make(chan Reply)
+	}
+	if ctx.reloads == nil {
+		ctx.reloads = make(chan chan error)
+	}
+	maxConcurrency := ctx.UserArgs.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = DefaultArgs.MaxConcurrency
+	}
+	ctx.workers = make(chan struct{}, maxConcurrency)
+	ctx.runCtx, ctx.cancelRun = context.WithCancelCause(startCtx)
+	ctx.stopping = make(chan struct{})
+	ctx.inputDone = make(chan struct{})
+	ctx.outputStop = make(chan struct{})
+	ctx.stopped = make(chan struct{})
+
+	go func() {
+		ctx.processInput()
+		close(ctx.inputDone)
+	}()
+	go func() {
+		ctx.processOutput()
+		close(ctx.stopped)
+	}()
+	go func() {
+		select {
+		case <-startCtx.Done():
+			ctx.Shutdown(startCtx)
+		case <-ctx.stopped:
+		}
+	}()
+
+	return nil
+}
+
+// Shutdown stops the agent, the work in flight is cancelled when it does not
+// finish before the context is done and ErrShutdownTimeout is returned. The
+// calls made while the agent stops wait for it.
+func (ctx *AgentCtx) Shutdown(shutdownCtx context.Context) error {
+
+	logger := GetLogger()
+
+	ctx.lifecycleMu.Lock()
+	if ctx.stopping == nil {
+		ctx.lifecycleMu.Unlock()
+		return ErrAgentNotStarted
+	}
+	first := false
+	ctx.stopOnce.Do(func() {
+		first = true
+		close(ctx.stopping)
+	})
+	ctx.lifecycleMu.Unlock()
+
+	if !first {
+		select {
+		case <-ctx.stopped:
+			return nil
+		case <-shutdownCtx.Done():
+			return shutdownCtx.Err()
+		}
+	}
+
+	logger.Info("Stopping the agent")
+
+	var err error
+	select {
+	case <-ctx.inputDone:
+	case <-shutdownCtx.Done():
+		err = fmt.Errorf("%w: %w", ErrShutdownTimeout, shutdownCtx.Err())
+		logger.Warning("Cancelling the requests being processed and %d actions being executed", len(ctx.Jobs()))
+		ctx.cancelRun(ErrAgentStopped)
+		select {
+		case <-ctx.inputDone:
+		case <-time.After(cancelGrace):
+			logger.Error("The requests being processed did not stop, they are abandoned")
+		}
+	}
+	ctx.cancelRun(ErrAgentStopped)
+
+	// The replies sent so far are delivered before the output stops
+	ctx.flushOutput()
+	close(ctx.outputStop)
+	<-ctx.stopped
+
+	logger.Info("Agent stopped")
+	logger.Close()
+	return err
+}
+
+// Done returns a channel closed once the agent is stopped, nil when the agent
+// was not started
+func (ctx *AgentCtx) Done() <-chan struct{} {
+	ctx.lifecycleMu.Lock()
+	defer ctx.lifecycleMu.Unlock()
+	return ctx.stopped
+}
+
+// failRequest tells the sender of a message that the request failed, with the
+// request id to report, and returns the error as a RequestError
+func (ctx *AgentCtx) failRequest(msg Message, kind error, err error) error {
+
+	logger := GetLogger().ForMessage(msg)
+
+	// A cancelled request fails for the reason it was cancelled
+	if cause := cancellation(msg); cause != nil {
+		kind = cause
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: %w", kind, err)
+	} else {
+		err = kind
+	}
+	// The user cancelling the request was already replied
+	if errors.Is(kind, ErrRequestCancelled) {
+		logger.Info("Request cancelled: %s", err)
+		return &RequestError{RequestId: msg.RequestId, Err: err}
+	}
+	logger.Error("Request failed: %s", err)
+	ctx.Reply(msg, fmt.Sprintf("Sorry, your request could not be handled: %s. Please mention the request id %s when reporting it.", kind, msg.RequestId))
+	return &RequestError{RequestId: msg.RequestId, Err: err}
+}
@@ -0,0 +1,150 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/a13labs/cobot/internal/nlp"
+)
+
+// llmServer answers every chat request with the given JSON result, the first
+// request waits until release is closed or the request is cancelled
+func llmServer(t *testing.T, result string, release chan struct{}) *nlp.LLMClient {
+	t.Helper()
+
+	first := make(chan struct{}, 1)
+	first <- struct{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The cancellation is noticed once the body is read
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-first:
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+		default:
+		}
+		fmt.Fprintf(w, `{"message":{"role":"assistant","content":%q}}`, `{"result":`+result+`}`)
+	}))
+	t.Cleanup(server.Close)
+
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	portNumber, _ := strconv.Atoi(port)
+	return &nlp.LLMClient{Host: host, Port: portNumber, Model: "mistral"}
+}
+
+func startAgent(t *testing.T, llm *nlp.LLMClient) (*agent.AgentCtx, *fakeChannel) {
+	t.Helper()
+
+	ctx := &agent.AgentCtx{Storage: agent.NewMemStorage(), LLMClient: llm}
+	channel := &fakeChannel{name: "console"}
+	if err := ctx.AddChannel(channel); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return ctx, channel
+}
+
+func TestFailedRequestIsRepliedWithItsId(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	ctx, channel := startAgent(t, &nlp.LLMClient{Host: "127.0.0.1", Port: port})
+	defer ctx.Shutdown(context.Background())
+
+	err = ctx.ProcessMessage(agent.Message{Channel: "console", Conversation: "console", User: "alice", Role: agent.RoleAdmin, Text: "reboot", RequestId: "0123abcd"})
+
+	var requestErr *agent.RequestError
+	if !errors.As(err, &requestErr) || requestErr.RequestId != "0123abcd" || !errors.Is(err, agent.ErrLLMRequest) {
+		t.Fatalf("ProcessMessage() error = %v; want a RequestError wrapping ErrLLMRequest", err)
+	}
+	sent := channel.sentTo("console")
+	if len(sent) != 1 || !strings.Contains(sent[0], "0123abcd") {
+		t.Errorf("replies = %q; want the request id", sent)
+	}
+}
+
+func TestShutdownWaitsForTheRequestInFlight(t *testing.T) {
+	release := make(chan struct{})
+	ctx, channel := startAgent(t, llmServer(t, "true", release))
+
+	result := make(chan error, 1)
+	go func() {
+		result <- ctx.ProcessMessage(agent.Message{Channel: "console", Conversation: "console", User: "alice", Role: agent.RoleAdmin, Text: "what time is it?"})
+	}()
+
+	stopped := make(chan error, 1)
+	go func() {
+		// Let the message reach the agent first
+		time.Sleep(50 * time.Millisecond)
+		stopped <- ctx.Shutdown(context.Background())
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	if err := <-result; !errors.Is(err, agent.ErrNoActionFound) {
+		t.Errorf("ProcessMessage() error = %v; want ErrNoActionFound", err)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+	if sent := channel.sentTo("console"); len(sent) != 1 {
+		t.Errorf("replies = %q; want the reply of the request in flight", sent)
+	}
+
+	// The stopped agent refuses the messages
+	if err := ctx.ProcessMessage(agent.Message{Channel: "console", Conversation: "console", User: "alice", Role: agent.RoleAdmin, Text: "hi"}); !errors.Is(err, agent.ErrAgentStopped) {
+		t.Errorf("ProcessMessage() error = %v once stopped; want ErrAgentStopped", err)
+	}
+	if err := ctx.RequestReload(); !errors.Is(err, agent.ErrAgentStopped) {
+		t.Errorf("RequestReload() error = %v once stopped; want ErrAgentStopped", err)
+	}
+	if err := ctx.Shutdown(context.Background()); err != nil {
+		t.Errorf("second Shutdown() error = %v", err)
+	}
+}
+
+func TestShutdownCancelsAfterTheDeadline(t *testing.T) {
+	ctx, channel := startAgent(t, llmServer(t, "true", make(chan struct{})))
+
+	result := make(chan error, 1)
+	go func() {
+		result <- ctx.ProcessMessage(agent.Message{Channel: "console", Conversation: "console", User: "alice", Role: agent.RoleAdmin, Text: "reboot", RequestId: "feedbeef"})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ctx.Shutdown(shutdownCtx); !errors.Is(err, agent.ErrShutdownTimeout) {
+		t.Errorf("Shutdown() error = %v; want ErrShutdownTimeout", err)
+	}
+	if err := <-result; !errors.Is(err, agent.ErrAgentStopped) {
+		t.Errorf("ProcessMessage() error = %v; want the request cancelled as the agent stopped", err)
+	}
+	if sent := channel.sentTo("console"); len(sent) != 1 || !strings.Contains(sent[0], "feedbeef") {
+		t.Errorf("replies = %q; want the failure with the request id", sent)
+	}
+	select {
+	case <-ctx.Done():
+	default:
+		t.Error("Done() is not closed once the agent is stopped")
+	}
+}
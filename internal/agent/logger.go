@@ -1,86 +1,288 @@
 package agent
 
 /*
-	Provide a basic logger for the agent. The logger will have the following features:
-	- Log levels: INFO, WARNING, ERROR, DEBUG
-	- Log to stdout
-	- Log to file
+	Provide a structured logger for the agent. The logger has the following
+	features:
+	- Log levels: DEBUG, INFO, WARNING, ERROR, the messages below the configured
+	  level are dropped
+	- Text or JSON output, to stdout or to a file rotated by size or age, under
+	  the storage local/logs/ folder or at a given path
+	- Contextual fields (session, user, action, request id, ...) added with With
+	  or ForMessage, they are written with every message of the derived logger
+	- Secrets are redacted from the messages and the fields
+
+	There is a single output shared by all the loggers, GetLogger always returns
+	the same root logger and Configure changes where and how it writes.
 */
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Log levels
+const (
+	LogLevelDebug   = "debug"
+	LogLevelInfo    = "info"
+	LogLevelWarning = "warning"
+	LogLevelError   = "error"
+)
+
+// Log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Names of the contextual fields
+const (
+	FieldChannel   = "channel"
+	FieldSession   = "session"
+	FieldUser      = "user"
+	FieldAction    = "action"
+	FieldRequestId = "request_id"
+)
+
+// LogsDir holds the log files when the logs are kept in the storage
+const LogsDir = "local/logs"
+
+// LogFile is the log file in LogsDir
+const LogFile = LogsDir + "/cobot.log"
+
+// LogOptions configures the output of the logger
+type LogOptions struct {
+	// Level is debug, info, warning or error, info when empty
+	Level string
+	// Format is text or json, text when empty
+	Format string
+	// File is the path of the log file, the logs go to stdout when empty
+	File string
+	// ToStorage writes the logs to the storage LogFile when no file is given
+	ToStorage bool
+	// MaxSize in bytes of the file before it is rotated, 0 disables it
+	MaxSize int64
+	// RotateInterval rotates the file at every interval, 0 disables it
+	RotateInterval time.Duration
+	// MaxBackups is the number of rotated files kept, 0 keeps all of them
+	MaxBackups int
+}
+
+// logOutput is shared by a logger and the loggers derived from it
+type logOutput struct {
+	mu      sync.RWMutex
+	handler slog.Handler
+	level   slog.LevelVar
+	closer  io.Closer
+	redact  func(string) string
+}
+
 // Logger is the logger for the agent
 type Logger struct {
-	logFile *os.File
+	output *logOutput
+	attrs  []slog.Attr
 }
 
 // the global logger
-var logger *Logger
+var logger = newLogger(os.Stdout)
 
 func GetLogger() *Logger {
-	if logger == nil {
-		logger, _ = NewLogger("")
-	}
 	return logger
 }
 
-// NewLogger creates a new logger
+func newLogger(w io.Writer) *Logger {
+	output := &logOutput{}
+	output.handler = slog.NewTextHandler(w, &slog.HandlerOptions{Level: &output.level})
+	return &Logger{output: output}
+}
+
+// NewLogger creates a new logger writing to the given file, or to stdout when
+// no file is given
 func NewLogger(logFile string) (*Logger, error) {
-	// Create the log file if provided, otherwise log to stdout
+	return NewLoggerWithOptions(LogOptions{File: logFile})
+}
+
+// NewLoggerWithOptions creates a new logger with the given options, a storage
+// log file is not supported as there is no storage yet
+func NewLoggerWithOptions(options LogOptions) (*Logger, error) {
+	l := newLogger(os.Stdout)
+	if err := l.Configure(options); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
 
-	if logFile == "" {
-		return &Logger{}, nil
+// ParseLogLevel returns the level with the given name
+func ParseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case LogLevelDebug:
+		return slog.LevelDebug, nil
+	case LogLevelInfo, "":
+		return slog.LevelInfo, nil
+	case LogLevelWarning, "warn":
+		return slog.LevelWarn, nil
+	case LogLevelError:
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("unknown log level '%s'", name)
 	}
+}
+
+// Configure changes the output of the logger and of all the loggers sharing it,
+// the previous log file is closed
+func (l *Logger) Configure(options LogOptions) error {
 
-	f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	level, err := ParseLogLevel(options.Level)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &Logger{logFile: f}, nil
+	var w io.Writer = os.Stdout
+	var closer io.Closer
+	if options.File != "" {
+		file, err := OpenRotatingFile(options.File, options.MaxSize, options.RotateInterval, options.MaxBackups)
+		if err != nil {
+			return err
+		}
+		w, closer = file, file
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: &l.output.level}
+	var handler slog.Handler
+	switch strings.ToLower(options.Format) {
+	case LogFormatText, "":
+		handler = slog.NewTextHandler(w, handlerOptions)
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(w, handlerOptions)
+	default:
+		if closer != nil {
+			closer.Close()
+		}
+		return fmt.Errorf("unknown log format '%s'", options.Format)
+	}
+
+	l.output.mu.Lock()
+	previous := l.output.closer
+	l.output.handler = handler
+	l.output.closer = closer
+	l.output.level.Set(level)
+	l.output.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
 }
 
-// Close closes the logger
+// SetLevel changes the level of the logger, the messages below it are dropped
+func (l *Logger) SetLevel(name string) error {
+	level, err := ParseLogLevel(name)
+	if err != nil {
+		return err
+	}
+	l.output.level.Set(level)
+	return nil
+}
+
+// Close closes the log file, the logger writes to stdout afterwards
 func (l *Logger) Close() {
-	if l.logFile != nil {
-		l.logFile.Close()
+	l.output.mu.Lock()
+	defer l.output.mu.Unlock()
+
+	if l.output.closer != nil {
+		l.output.closer.Close()
+		l.output.closer = nil
+		l.output.handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: &l.output.level})
+	}
+}
+
+// SetRedactFunc sets a function applied to every message and field before it
+// is written, it is used to keep secrets out of the logs
+func (l *Logger) SetRedactFunc(f func(string) string) {
+	l.output.mu.Lock()
+	defer l.output.mu.Unlock()
+	l.output.redact = f
+}
+
+// With returns a logger adding the given fields, given as key value pairs, to
+// every message
+func (l *Logger) With(args ...interface{}) *Logger {
+	attrs := append([]slog.Attr{}, l.attrs...)
+	for i := 0; i < len(args); i += 2 {
+		key := fmt.Sprint(args[i])
+		if i+1 == len(args) {
+			attrs = append(attrs, slog.String("!BADKEY", key))
+			break
+		}
+		attrs = append(attrs, slog.Any(key, args[i+1]))
+	}
+	return &Logger{output: l.output, attrs: attrs}
+}
+
+// ForMessage returns a logger adding the fields identifying the given message
+func (l *Logger) ForMessage(msg Message) *Logger {
+	args := []interface{}{FieldChannel, msg.Channel, FieldSession, msg.Conversation, FieldUser, msg.User}
+	if msg.RequestId != "" {
+		args = append(args, FieldRequestId, msg.RequestId)
 	}
+	return l.With(args...)
 }
 
 // Info logs an info message
 func (l *Logger) Info(msg string, args ...interface{}) {
-	l.log("INFO", msg, args...)
+	l.log(slog.LevelInfo, msg, args...)
 }
 
 // Warning logs a warning message
 func (l *Logger) Warning(msg string, args ...interface{}) {
-	l.log("WARNING", msg, args...)
+	l.log(slog.LevelWarn, msg, args...)
 }
 
 // Error logs an error message
 func (l *Logger) Error(msg string, args ...interface{}) {
-	l.log("ERROR", msg, args...)
+	l.log(slog.LevelError, msg, args...)
 }
 
 // Debug logs a debug message
 func (l *Logger) Debug(msg string, args ...interface{}) {
-	l.log("DEBUG", msg, args...)
+	l.log(slog.LevelDebug, msg, args...)
 }
 
-func (l *Logger) log(level string, msg string, args ...interface{}) {
-	now := time.Now().Format(time.RFC3339)
+func (l *Logger) log(level slog.Level, msg string, args ...interface{}) {
+	ctx := context.Background()
+
+	// Held while writing so the file is not closed by Configure meanwhile
+	l.output.mu.RLock()
+	defer l.output.mu.RUnlock()
+
+	if !l.output.handler.Enabled(ctx, level) {
+		return
+	}
 
 	if len(args) > 0 {
 		msg = fmt.Sprintf(msg, args...)
 	}
 
-	if l.logFile != nil {
-		fmt.Fprintf(l.logFile, "[%s] [%s] %s\n", now, level, msg)
-	} else {
-		fmt.Fprintf(os.Stdout, "[%s] [%s] %s\n", now, level, msg)
+	redact := l.output.redact
+	if redact != nil {
+		msg = redact(msg)
+	}
+
+	record := slog.NewRecord(time.Now(), level, msg, 0)
+	for _, attr := range l.attrs {
+		if redact != nil {
+			if attr.Value.Kind() == slog.KindString {
+				attr = slog.String(attr.Key, redact(attr.Value.String()))
+			} else if err, ok := attr.Value.Any().(error); ok {
+				attr = slog.String(attr.Key, redact(err.Error()))
+			}
+		}
+		record.AddAttrs(attr)
 	}
+	l.output.handler.Handle(ctx, record)
 }
@@ -0,0 +1,121 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+func TestLoggerLevelsFieldsAndRedaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "cobot.log")
+	logger, err := agent.NewLoggerWithOptions(agent.LogOptions{Level: "warning", Format: "json", File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	logger.SetRedactFunc(func(text string) string { return strings.ReplaceAll(text, "hunter2", "[REDACTED]") })
+
+	msg := agent.Message{Channel: "telegram", Conversation: "42", User: "alice", RequestId: "abc"}
+	logger.Info("dropped")
+	logger.ForMessage(msg).With(agent.FieldAction, "deploy", "token", "hunter2").Warning("Action %s failed with %s", "deploy", "hunter2")
+	logger.Error("plain")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got:\n%s", data)
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"level":              "WARN",
+		"msg":                "Action deploy failed with [REDACTED]",
+		agent.FieldChannel:   "telegram",
+		agent.FieldSession:   "42",
+		agent.FieldUser:      "alice",
+		agent.FieldRequestId: "abc",
+		agent.FieldAction:    "deploy",
+		"token":              "[REDACTED]",
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Errorf("%s = %v; want %v", key, record[key], value)
+		}
+	}
+
+	if err := logger.SetLevel("verbose"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+	if _, err := agent.NewLoggerWithOptions(agent.LogOptions{Format: "xml"}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestRotatingFileBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cobot.log")
+	file, err := agent.OpenRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		// The rotated files are named after the time of the rotation
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	backups, err := file.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got %v", backups)
+	}
+	for i, expected := range []string{"second\n", "third\n"} {
+		if data, _ := os.ReadFile(backups[i]); string(data) != expected {
+			t.Errorf("backup %d = %q; want %q", i, data, expected)
+		}
+	}
+	if data, _ := os.ReadFile(path); string(data) != "fourth\n" {
+		t.Errorf("current file = %q", data)
+	}
+}
+
+func TestRotatingFileByInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cobot.log")
+	file, err := agent.OpenRotatingFile(path, 0, 50*time.Millisecond, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	file.Write([]byte("first\n"))
+	file.Write([]byte("second\n"))
+	time.Sleep(60 * time.Millisecond)
+	file.Write([]byte("third\n"))
+
+	backups, err := file.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("expected 1 backup, got %v", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != "first\nsecond\n" {
+		t.Errorf("backup = %q", data)
+	}
+}
@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Layout of the time in the name of the rotated files, they sort by time
+const rotatedTimeLayout = "20060102T150405.000"
+
+// RotatingFile is a log file rotated once it reaches a size, or at every
+// interval. The rotated files have the rotation time in their name and only
+// the newest ones are kept.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+	mu         sync.Mutex
+	file       *os.File
+	size       int64
+	period     time.Time
+}
+
+// OpenRotatingFile opens the log file at the given path, it is created with
+// its folder when it does not exist. A zero size or interval disables the
+// rotation on that criteria, zero backups keeps all the rotated files.
+func OpenRotatingFile(path string, maxSize int64, interval time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, interval: interval, maxBackups: maxBackups}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	// An existing file belongs to the period of its last write
+	f.period = f.periodOf(info.ModTime())
+	if info.Size() == 0 {
+		f.period = f.periodOf(time.Now())
+	}
+	return nil
+}
+
+func (f *RotatingFile) periodOf(t time.Time) time.Time {
+	if f.interval <= 0 {
+		return time.Time{}
+	}
+	return t.Truncate(f.interval)
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	// A failed rotation is retried on the next write, the data is still
+	// written to the current file
+	var rotateErr error
+	full := f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize
+	expired := f.interval > 0 && f.size > 0 && f.periodOf(time.Now()).After(f.period)
+	if full || expired {
+		rotateErr = f.rotate()
+		if f.file == nil {
+			return 0, rotateErr
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// rotate renames the current file and opens a new one, the current file is
+// reopened when it can't be renamed
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	ext := filepath.Ext(f.path)
+	base := strings.TrimSuffix(f.path, ext)
+	rotated := base + "-" + time.Now().Format(rotatedTimeLayout) + ext
+	if err := os.Rename(f.path, rotated); err != nil {
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		// The renamed file is still there to be written to
+		if restoreErr := os.Rename(rotated, f.path); restoreErr == nil {
+			f.open()
+		}
+		return err
+	}
+	return f.prune()
+}
+
+// prune removes the oldest rotated files beyond the backups kept
+func (f *RotatingFile) prune() error {
+	if f.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := f.Backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > f.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Backups returns the rotated files, the oldest first
+func (f *RotatingFile) Backups() ([]string, error) {
+	ext := filepath.Ext(f.path)
+	base := strings.TrimSuffix(f.path, ext)
+	backups, err := filepath.Glob(base + "-*" + ext)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
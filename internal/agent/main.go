@@ -1,67 +1,173 @@
 package agent
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/a13labs/cobot/internal/algo"
+	"github.com/a13labs/cobot/internal/metrics"
 	"github.com/a13labs/cobot/internal/nlp"
+	"github.com/a13labs/cobot/internal/tracing"
 	"github.com/go-yaml/yaml"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const tracerName = "github.com/a13labs/cobot/internal/agent"
+
 type AgentStartArgs struct {
-	StoragePath  string
-	LogFile      string
-	MinimumScore float64
-	LLMHost      string
-	LLMPort      int
-	LLMModel     string
+	StoragePath     string
+	StorageRevision string
+	Log             LogOptions
+	MinimumScore    float64
+	LLMHost         string
+	LLMPort         int
+	LLMModel        string
+	// Language of the replies when the agent configuration sets none
+	Language string
+	// MaxConcurrency is the maximum number of messages processed at once
+	MaxConcurrency int
 }
 
 var DefaultArgs = AgentStartArgs{
-	StoragePath:  "data",
-	LogFile:      "",
-	MinimumScore: 0.5,
-	LLMHost:      "localhost",
-	LLMPort:      11434,
-	LLMModel:     "mistral",
+	StoragePath:     "data",
+	StorageRevision: "",
+	MinimumScore:    0.5,
+	LLMHost:         "localhost",
+	LLMPort:         11434,
+	LLMModel:        "mistral",
+	Language:        "english",
+	MaxConcurrency:  4,
 }
 
 type agentDef struct {
 	Name            string `yaml:"name"`
+	Language        string `yaml:"language,omitempty"`
 	AllowReboot     bool   `yaml:"allow_reboot"`
 	AllowPrivileged bool   `yaml:"allow_privileged"`
 }
 
 type AgentConfigFile struct {
-	Agent   agentDef `yaml:"agent"`
-	Actions []string `yaml:"actions"`
+	Agent         agentDef               `yaml:"agent"`
+	Actions       []ActionRef            `yaml:"actions"`
+	Discovery     ActionDiscovery        `yaml:"discovery,omitempty"`
+	KnowledgeBase map[string]interface{} `yaml:"knowledge_base,omitempty"`
+	Users         []UserDef              `yaml:"users,omitempty"`
+}
+
+// Message is an input received from a channel
+type Message struct {
+	// Channel and Conversation identify where the replies must be sent
+	Channel      string
+	Conversation string
+	User         string
+	// Role of the user, a message without role is not allowed to do anything
+	// requiring one
+	Role string
+	// RequestId correlates the logs of the message, it is set when the
+	// message is dispatched
+	RequestId   string
+	Text        string
+	Attachments []Attachment
+	// Result receives the outcome once the message is handled and its replies
+	// are delivered, it must be buffered
+	Result chan<- error
+	// ctx carries the span of the message while it is processed
+	ctx context.Context
+	// worker and config are set once the message is taken by a worker, see
+	// workers.go
+	worker *worker
+	config *messageConfig
+}
+
+// Context returns the context the message is processed in, the LLM requests
+// and the actions run for the message are traced in it
+func (msg Message) Context() context.Context {
+	if msg.ctx == nil {
+		return context.Background()
+	}
+	return msg.ctx
+}
+
+// Reply is an output for a channel conversation
+type Reply struct {
+	Channel      string
+	Conversation string
+	Text         string
+	// FileName is set when the text may be sent as a file, it is sent as a
+	// file when it is too long for the channel
+	FileName string
+	// flushed is closed once the replies sent before are delivered
+	flushed chan struct{}
 }
 
 type AgentCtx struct {
-	Storage       *Storage
+	Storage   Storage
+	Secrets   *SecretStore
+	Audit     *AuditLog
+	LLMClient *nlp.LLMClient
+	// ActionDB and AgentCfg are replaced by a reload, the channels read them
+	// with Actions and Config
 	ActionDB      *ActionDB
-	LLMClient     *nlp.LLMClient
 	AgentCfg      AgentConfigFile
 	UserArgs      AgentStartArgs
-	WriterFunc    func(string) error
-	InputChannel  chan string
-	OutputChannel chan string
+	InputChannel  chan Message
+	OutputChannel chan Reply
+	channels      map[string]Channel
+	channelsMu    sync.RWMutex
+	prompts       map[string]*pendingPrompt
+	promptsMu     sync.Mutex
+	// StartTime is when the agent was started
+	StartTime time.Time
+	jobs      map[uint64]Job
+	jobsMu    sync.Mutex
+	jobsIdle  chan struct{}
+	lastJobId uint64
+	reloads   chan chan error
+	// lifecycle of the agent, see lifecycle.go
+	lifecycleMu sync.Mutex
+	stopOnce    sync.Once
+	runCtx      context.Context
+	cancelRun   context.CancelCauseFunc
+	stopping    chan struct{}
+	inputDone   chan struct{}
+	outputStop  chan struct{}
+	stopped     chan struct{}
+	// workers processing the messages, see workers.go
+	workers    chan struct{}
+	sessions   map[string]*session
+	sessionsMu sync.Mutex
+	sessionsWg sync.WaitGroup
+	configMu   sync.RWMutex
+	reloadMu   sync.Mutex
 }
 
+var (
+	ErrStorageInit   = errors.New("error initializing storage")
+	ErrLoggerInit    = errors.New("error initializing logger")
+	ErrAgentConfig   = errors.New("error loading agent configuration")
+	ErrSecretsInit   = errors.New("error loading secrets")
+	ErrAuditInit     = errors.New("error opening audit log")
+	ErrLLMClientInit = errors.New("error initializing LLM client")
+	ErrActionDBInit  = errors.New("error initializing action database")
+)
+
+// NewAgentCtx opens the storage and loads the agent configuration, the agent
+// processes the messages once started
 func NewAgentCtx(args *AgentStartArgs) (*AgentCtx, error) {
 
 	ctx := &AgentCtx{
-		UserArgs: *args,
+		UserArgs:  *args,
+		StartTime: time.Now(),
 	}
 
-	// Initialize the logger
 	var err error
-	logger, err = NewLogger(args.LogFile)
-	if err != nil {
-		return nil, err
-	}
-
 	// Set the user arguments
 	if ctx.UserArgs.StoragePath == "" {
 		ctx.UserArgs.StoragePath = DefaultArgs.StoragePath
@@ -78,179 +184,473 @@ func NewAgentCtx(args *AgentStartArgs) (*AgentCtx, error) {
 	if ctx.UserArgs.LLMModel == "" {
 		ctx.UserArgs.LLMModel = DefaultArgs.LLMModel
 	}
+	if ctx.UserArgs.Language == "" {
+		ctx.UserArgs.Language = DefaultArgs.Language
+	}
 
-	// Initialize the storage
-	ctx.Storage, err = NewStorage(ctx.UserArgs.StoragePath)
+	// Initialize the storage, when a revision is given the storage is served
+	// read-only straight from the git repository
+	if ctx.UserArgs.StorageRevision != "" {
+		ctx.Storage, err = NewGitStorage(ctx.UserArgs.StoragePath, ctx.UserArgs.StorageRevision)
+	} else {
+		ctx.Storage, err = NewFileStorage(ctx.UserArgs.StoragePath)
+	}
+	if err == nil {
+		// The audit log, the secrets key and the state of the channels are
+		// kept in local/, the agent must not run without them
+		err = CheckLocalWritable(ctx.Storage)
+	}
 	if err != nil {
-		return nil, errors.New("error initializing storage")
+		return nil, fmt.Errorf("%w: %w", ErrStorageInit, err)
+	}
+
+	// Configure the logger, the logs are kept in the storage unless a file is
+	// given
+	logOptions := ctx.UserArgs.Log
+	if logOptions.File == "" && logOptions.ToStorage {
+		pather, ok := ctx.Storage.(LocalPather)
+		if !ok {
+			return nil, fmt.Errorf("%w: the storage cannot keep the logs, a log file is required", ErrLoggerInit)
+		}
+		logOptions.File = pather.LocalPath(LogFile)
+	}
+	if err := logger.Configure(logOptions); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLoggerInit, err)
 	}
 
 	// Load the agent configuration
+	ctx.AgentCfg, err = LoadAgentConfig(ctx.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the secrets, from now on secret values are redacted from the logs
+	ctx.Secrets, err = NewSecretStore(ctx.Storage, false)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSecretsInit, err)
+	}
+	logger.SetRedactFunc(ctx.Secrets.Redact)
+
+	// Open the audit log
+	ctx.Audit, err = OpenAuditLog(ctx.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAuditInit, err)
+	}
+
+	// Initialize the LLM client
+	ctx.LLMClient, err = nlp.NewLLMClient(ctx.UserArgs.LLMHost, ctx.UserArgs.LLMPort, ctx.UserArgs.LLMModel)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLLMClientInit, err)
+	}
+	ctx.LLMClient.Redact = ctx.Secrets.Redact
+
+	// Initialize the action database
+	ctx.ActionDB, err = NewActionDB(ctx.AgentCfg, ctx.Storage, ctx.LLMClient)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrActionDBInit, err)
+	}
+
+	ctx.InputChannel = make(chan Message)
+	ctx.OutputChannel = make(chan Reply)
+	ctx.reloads = make(chan chan error)
+
+	return ctx, nil
+}
+
+// LoadAgentConfig loads the agent configuration from the given storage. If the
+// configuration file does not exist a default one is created.
+func LoadAgentConfig(storage Storage) (AgentConfigFile, error) {
+
+	logger := GetLogger()
+
+	var agentCfg AgentConfigFile
+
 	// Check if the local folder has the required structure
 	// If not, create the required structure
-	_, err = ctx.Storage.Stat("agent-config.yaml")
+	_, err := storage.Stat("agent-config.yaml")
 	if err != nil {
 		logger.Info("Agent configuration file not found. Creating a default agent configuration file.")
-		ctx.AgentCfg = AgentConfigFile{
+		agentCfg = AgentConfigFile{
 			Agent: agentDef{
 				Name:            "default",
 				AllowReboot:     false,
 				AllowPrivileged: false,
 			},
-			Actions: []string{},
-		}
-		agentCfgData, err := yaml.Marshal(ctx.AgentCfg)
-		if err != nil {
-			logger.Error("Error marshalling agent configuration")
-			return nil, errors.New("error marshalling agent configuration")
+			Actions: []ActionRef{},
 		}
-		err = ctx.Storage.WriteFile("agent-config.yaml", agentCfgData, 0644)
+		agentCfgData, err := yaml.Marshal(agentCfg)
 		if err != nil {
-			logger.Error("Error writing agent configuration file")
-			return nil, errors.New("error writing agent configuration file")
+			return agentCfg, fmt.Errorf("%w: error marshalling agent configuration: %w", ErrAgentConfig, err)
 		}
-	} else {
-		logger.Info("Loading agent configuration from storage")
-
-		// Load the agent configuration file
-		agentCfgData, err := ctx.Storage.ReadFile("agent-config.yaml")
+		err = storage.WriteFile("agent-config.yaml", agentCfgData, 0644)
 		if err != nil {
-			logger.Error("Error reading agent configuration file")
-			return nil, errors.New("error reading agent configuration file")
-		}
-
-		// Unmarshal the agent configuration file
-		if err := yaml.Unmarshal(agentCfgData, &ctx.AgentCfg); err != nil {
-			logger.Error("Error parsing agent configuration file")
-			return nil, errors.New("error parsing agent configuration file")
-		}
-
-		// Check if the agent configuration file has the required fields
-		if ctx.AgentCfg.Agent.Name == "" {
-			logger.Error("Agent name is empty")
-			return nil, errors.New("agent name is empty")
+			return agentCfg, fmt.Errorf("%w: error writing agent configuration file: %w", ErrAgentConfig, err)
 		}
-
+		return agentCfg, nil
 	}
 
-	// Initialize the LLM client
-	ctx.LLMClient = nlp.NewLLMClient(ctx.UserArgs.LLMHost, ctx.UserArgs.LLMPort, ctx.UserArgs.LLMModel)
-	if ctx.LLMClient == nil {
-		return nil, errors.New("error initializing LLM client")
-	}
+	logger.Info("Loading agent configuration from storage")
 
-	// Initialize the action database
-	ctx.ActionDB, err = NewActionDB(algo.StringList(ctx.AgentCfg.Actions), ctx.Storage, ctx.LLMClient)
+	// Load the agent configuration file
+	agentCfgData, err := storage.ReadFile("agent-config.yaml")
 	if err != nil {
-		return nil, errors.New("error initializing action database")
+		return agentCfg, fmt.Errorf("%w: error reading agent configuration file: %w", ErrAgentConfig, err)
 	}
 
-	ctx.WriterFunc = func(msg string) error {
-		return nil
+	// Unmarshal the agent configuration file
+	agentCfg, err = parseAgentConfig(agentCfgData)
+	if err != nil {
+		return agentCfg, fmt.Errorf("%w: error parsing agent configuration file: %w", ErrAgentConfig, err)
 	}
 
-	ctx.InputChannel = make(chan string)
-	ctx.OutputChannel = make(chan string)
+	// Check if the agent configuration file has the required fields
+	if agentCfg.Agent.Name == "" {
+		return agentCfg, fmt.Errorf("%w: agent name is empty", ErrAgentConfig)
+	}
 
-	go ctx.processInput()
-	go ctx.processOutput()
+	return agentCfg, nil
+}
 
-	return ctx, nil
+// parseAgentConfig decodes the agent configuration file
+func parseAgentConfig(data []byte) (AgentConfigFile, error) {
+	var agentCfg AgentConfigFile
+	err := yaml.Unmarshal(data, &agentCfg)
+	return agentCfg, err
 }
 
-func (ctx *AgentCtx) SetWriterFunc(f func(string) error) {
-	ctx.WriterFunc = f
+// Reload loads the agent configuration and the actions from the storage again,
+// the messages being processed keep the previous ones
+func (ctx *AgentCtx) Reload() error {
+
+	logger := GetLogger()
+
+	ctx.reloadMu.Lock()
+	defer ctx.reloadMu.Unlock()
+
+	cfg, err := LoadAgentConfig(ctx.Storage)
+	if err != nil {
+		metrics.ConfigReloads.WithLabelValues("failure").Inc()
+		return err
+	}
+	actionDB, err := NewActionDB(cfg, ctx.Storage, ctx.LLMClient)
+	if err != nil {
+		metrics.ConfigReloads.WithLabelValues("failure").Inc()
+		return err
+	}
+	metrics.ConfigReloads.WithLabelValues("success").Inc()
+	ctx.configMu.Lock()
+	ctx.AgentCfg = cfg
+	ctx.ActionDB = actionDB
+	ctx.configMu.Unlock()
+
+	logger.Info("Agent configuration reloaded, %d actions available", len(actionDB.ActionNames))
+	return nil
 }
 
-func (ctx *AgentCtx) processInput() {
+// RequestReload reloads the configuration, it waits until the configuration is
+// loaded
+func (ctx *AgentCtx) RequestReload() error {
+	result := make(chan error, 1)
+	select {
+	case ctx.reloads <- result:
+	case <-ctx.stopping:
+		return ErrAgentStopped
+	}
+	return <-result
+}
 
-	for msg := range ctx.InputChannel {
-		if msg == "exit" {
-			break
+// handleMessage processes a message, a panic fails the request instead of
+// stopping the agent
+func (ctx *AgentCtx) handleMessage(msg Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ctx.failRequest(msg, ErrInternal, fmt.Errorf("panic: %v", r))
 		}
-		ctx.process(msg)
+	}()
+	return ctx.process(msg)
+}
+
+// send queues a reply, it is dropped once the agent is stopped
+func (ctx *AgentCtx) send(reply Reply) bool {
+	select {
+	case ctx.OutputChannel <- reply:
+		return true
+	case <-ctx.stopped:
+		GetLogger().Warning("Dropping a reply to %s, the agent is stopped", reply.Channel)
+		return false
+	}
+}
+
+// sendOrdered sends a reply after the replies sent so far, it is delivered
+// right away when the agent is not started
+func (ctx *AgentCtx) sendOrdered(reply Reply) error {
+	if ctx.OutputChannel == nil {
+		return ctx.Deliver(reply)
+	}
+	if !ctx.send(reply) {
+		return ErrAgentStopped
 	}
+	return nil
+}
 
+// flushOutput waits until the replies sent so far are delivered
+func (ctx *AgentCtx) flushOutput() {
+	if ctx.OutputChannel == nil {
+		return
+	}
+	flushed := make(chan struct{})
+	if ctx.send(Reply{flushed: flushed}) {
+		<-flushed
+	}
 }
 
+// processOutput delivers the replies until the agent stops
 func (ctx *AgentCtx) processOutput() {
-	for msg := range ctx.OutputChannel {
-		if msg == "exit" {
-			break
+
+	logger := GetLogger()
+
+	for {
+		select {
+		case reply := <-ctx.OutputChannel:
+			if reply.flushed != nil {
+				close(reply.flushed)
+				continue
+			}
+			reply.Text = ctx.Secrets.Redact(reply.Text)
+			if err := ctx.Deliver(reply); err != nil {
+				logger.Error("Error sending reply to %s: %s", reply.Channel, err)
+			}
+		case <-ctx.outputStop:
+			return
 		}
-		ctx.WriterFunc(msg)
 	}
 }
 
-func (ctx *AgentCtx) process(userInput string) {
+// process handles a message, the error tells why no action ran or why the
+// action failed
+func (ctx *AgentCtx) process(msg Message) (err error) {
+
+	logger := GetLogger().ForMessage(msg)
+
+	var span trace.Span
+	msg.ctx, span = tracing.Tracer(tracerName).Start(msg.Context(), "agent.process",
+		trace.WithAttributes(
+			attribute.String(tracing.AttrChannel, msg.Channel),
+			attribute.String(tracing.AttrSession, msg.Conversation),
+			attribute.String(tracing.AttrUser, msg.User),
+			attribute.String(tracing.AttrRequestId, msg.RequestId),
+		))
+	defer func() { ctx.endSpan(span, err) }()
+
+	userInput := msg.Text
+	logger.Debug("Processing message")
 
-	isQuestion, err := isItAQuestion(ctx, userInput)
+	if IsChatCommand(userInput) {
+		defer metrics.ObserveStage(metrics.StageCommand, time.Now())
+		return ctx.handleChatCommand(msg)
+	}
+
+	start := time.Now()
+	isQuestion, err := isItAQuestion(ctx, msg.Context(), userInput)
+	metrics.ObserveStage(metrics.StageQuestion, start)
 	if err != nil {
-		logger.Error("Error parsing user input: %s", err)
-		return
+		return ctx.failRequest(msg, ErrLLMRequest, err)
 	}
 
 	if isQuestion {
-		ctx.Inform("Currently questions are not handled, only commands. No action will be taken.")
-		return
+		ctx.Inform(msg, "Currently questions are not handled, only commands. No action will be taken.")
+		return ErrNoActionFound
 	}
 
-	validAction, err := isItemInList(ctx, userInput, ctx.ActionDB.GetActionDescriptions())
+	actionDB := ctx.actionsOf(msg)
+
+	start = time.Now()
+	validAction, err := isItemInList(ctx, msg.Context(), userInput, actionDB.GetActionDescriptions())
+	metrics.ObserveStage(metrics.StageMatch, start)
 	if err != nil {
-		logger.Error("Error parsing user input: %s", err)
-		return
+		return ctx.failRequest(msg, ErrLLMRequest, err)
+	}
+	if !validAction {
+		ctx.Inform(msg, "No actions were found. No action will be taken.")
+		return ErrNoActionFound
 	}
-	if validAction {
 
-		actions, err := filterListItems(ctx, userInput, ctx.ActionDB.GetActionDescriptions())
-		if err != nil {
-			logger.Error("Error parsing user input: %s", err)
-			return
-		}
+	start = time.Now()
+	actions, err := filterListItems(ctx, msg.Context(), userInput, actionDB.GetActionDescriptions())
+	metrics.ObserveStage(metrics.StageSelect, start)
+	if err != nil {
+		return ctx.failRequest(msg, ErrLLMRequest, err)
+	}
 
-		if len(actions) == 0 {
-			ctx.Inform("No actions were found. No action will be taken.")
-			return
+	var names []string
+	for _, action := range actions {
+		if action >= 0 && action < len(actionDB.ActionNames) {
+			names = append(names, actionDB.ActionNames[action])
 		}
+	}
+
+	switch len(names) {
+	case 0:
+		ctx.Inform(msg, "No actions were found. No action will be taken.")
+		return ErrNoActionFound
+	case 1:
+		return ctx.runAction(msg, names[0])
+	default:
+		return ctx.disambiguate(msg, names)
+	}
+}
 
-		for _, action := range actions {
-			logger.Info("Action: %s", ctx.ActionDB.ActionNames[action])
+// disambiguate asks the user which of the matching actions must run
+func (ctx *AgentCtx) disambiguate(msg Message, names []string) error {
+
+	logger := GetLogger().ForMessage(msg)
+
+	answer, err := ctx.Ask(msg, "Your request matches several actions, which one should run?", append(names, cancelAnswer))
+	if err != nil {
+		if cause := cancellation(msg); cause != nil {
+			return ctx.failRequest(msg, cause, err)
 		}
-	} else {
-		ctx.Inform("No actions were found. No action will be taken.")
+		logger.Error("Error asking for an action: %s", err)
+		ctx.Inform(msg, fmt.Sprintf("The request matches several actions (%s) and none was selected. No action will be taken.", strings.Join(names, ", ")))
+		return fmt.Errorf("%w: %w", ErrActionCancelled, err)
 	}
+	for _, name := range names {
+		if strings.EqualFold(strings.TrimSpace(answer), name) {
+			return ctx.runAction(msg, name)
+		}
+	}
+	ctx.Reply(msg, "No action will be taken.")
+	return ErrActionCancelled
 }
 
 func (ctx *AgentCtx) DispatchInput(userInput string) {
-	ctx.InputChannel <- userInput
+	ctx.DispatchMessage(Message{Text: userInput})
+}
+
+// DispatchMessage sends a message to the agent, when the agent is waiting for
+// an answer of the sender the message is taken as the answer. The result of a
+// message sent while the agent stops is ErrAgentStopped.
+func (ctx *AgentCtx) DispatchMessage(msg Message) {
+	if msg.RequestId == "" {
+		msg.RequestId = newRequestId()
+	}
+	metrics.MessagesReceived.WithLabelValues(msg.Channel).Inc()
+	if ctx.Answer(msg.Channel, msg.Conversation, msg.User, "", msg.Text) == nil {
+		if msg.Result != nil {
+			msg.Result <- nil
+		}
+		return
+	}
+	select {
+	case ctx.InputChannel <- msg:
+	case <-ctx.stopping:
+		if msg.Result != nil {
+			msg.Result <- ErrAgentStopped
+		}
+	}
+}
+
+// newRequestId returns a random id correlating the logs of a message
+func newRequestId() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// ProcessMessage sends a message to the agent and waits until it is handled
+// and its replies are delivered. The error tells why no action ran or why the
+// action failed.
+func (ctx *AgentCtx) ProcessMessage(msg Message) error {
+	result := make(chan error, 1)
+	msg.Result = result
+	ctx.DispatchMessage(msg)
+	return <-result
+}
+
+// Config returns the agent configuration, it is not replaced by a reload once
+// returned
+func (ctx *AgentCtx) Config() AgentConfigFile {
+	ctx.configMu.RLock()
+	defer ctx.configMu.RUnlock()
+	return ctx.AgentCfg
+}
+
+// Actions returns the action database, it is not replaced by a reload once
+// returned
+func (ctx *AgentCtx) Actions() *ActionDB {
+	ctx.configMu.RLock()
+	defer ctx.configMu.RUnlock()
+	return ctx.ActionDB
 }
 
 func (ctx *AgentCtx) GetAgentName() string {
-	return ctx.AgentCfg.Agent.Name
+	return ctx.Config().Agent.Name
 }
 
-func (ctx *AgentCtx) SayHello() {
-	prompt := fmt.Sprintf("Your name is '%s'.You are polite.Inform the user you are ready to receive orders and greet him.", ctx.AgentCfg.Agent.Name)
-	msg, err := generateAMessage(ctx, prompt)
+// languageOf returns the language of the replies, the one of the agent
+// configuration or the one the agent was started with
+func (ctx *AgentCtx) languageOf(cfg AgentConfigFile) string {
+	if cfg.Agent.Language != "" {
+		return cfg.Agent.Language
+	}
+	return ctx.UserArgs.Language
+}
+
+// SayHello greets the conversation of the given message
+func (ctx *AgentCtx) SayHello(to Message) {
+	prompt := fmt.Sprintf("Your name is '%s'.You are polite.Inform the user you are ready to receive orders and greet him.", ctx.GetAgentName())
+	msg, err := generateAMessage(ctx, to.Context(), prompt, ctx.languageOf(ctx.Config()))
 	if err != nil {
+		GetLogger().ForMessage(to).Warning("Error generating the welcome message: %s", err)
 		return
 	}
-	ctx.OutputChannel <- msg
+	ctx.Reply(to, msg)
 }
 
+// SayGoodBye returns a goodbye message, channels send it themselves as the
+// agent may be already shutting down
 func (ctx *AgentCtx) SayGoodBye() (string, error) {
-	msg, err := generateAMessage(ctx, "Your name is '%s'.You are polite.Inform the user you are shutting down and say goodbye.")
+	prompt := fmt.Sprintf("Your name is '%s'.You are polite.Inform the user you are shutting down and say goodbye.", ctx.GetAgentName())
+	msg, err := generateAMessage(ctx, context.Background(), prompt, ctx.languageOf(ctx.Config()))
 	if err != nil {
-		ctx.OutputChannel <- "error interacting with LLM"
+		return "", err
+	}
+	return ctx.Secrets.Redact(msg), nil
+}
+
+// Reply sends the given text as is to the conversation of the given message
+func (ctx *AgentCtx) Reply(to Message, text string) {
+	ctx.send(Reply{Channel: to.Channel, Conversation: to.Conversation, Text: text})
+}
+
+// ReplyFile sends a text to the conversation of the given message, as a file
+// with the given name when the channel cannot send it as a single message
+func (ctx *AgentCtx) ReplyFile(to Message, name string, text string) {
+	ctx.send(Reply{Channel: to.Channel, Conversation: to.Conversation, Text: text, FileName: name})
+}
+
+// Inform tells the conversation of the given message about an event, using
+// the LLM to phrase it. The event is sent as is when the LLM fails.
+func (ctx *AgentCtx) Inform(to Message, text string) {
+
+	logger := GetLogger().ForMessage(to)
+
+	cfg := ctx.configOf(to)
+	prompt := fmt.Sprintf("Your name is '%s'.You are polite,inform the user,using your words,of the following event:'%s'.", cfg.Agent.Name, text)
+	msg, err := generateAMessage(ctx, to.Context(), prompt, ctx.languageOf(cfg))
+	if err != nil || strings.TrimSpace(msg) == "" {
+		logger.Warning("Error phrasing a reply, the event is sent as is: %v", err)
+		msg = text
 	}
-	return msg, nil
+	ctx.Reply(to, msg)
 }
 
-func (ctx *AgentCtx) Inform(text string) {
-	prompt := fmt.Sprintf("Your name is '%s'.You are polite,inform the user,using your words,of the following event:'%s'.", ctx.AgentCfg.Agent.Name, text)
-	msg, err := generateAMessage(ctx, prompt)
+// endSpan ends a span, recording the error if any without the secrets
+func (ctx *AgentCtx) endSpan(span trace.Span, err error) {
 	if err != nil {
-		ctx.OutputChannel <- "error interacting with LLM"
+		text := ctx.Secrets.Redact(err.Error())
+		span.RecordError(errors.New(text))
+		span.SetStatus(codes.Error, text)
 	}
-	ctx.OutputChannel <- msg
+	span.End()
 }
@@ -0,0 +1,196 @@
+package agent
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemStorage is a storage kept entirely in memory, it is mainly used for tests
+type MemStorage struct {
+	mu    sync.RWMutex
+	files map[string]*memEntry
+}
+
+type memEntry struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func NewMemStorage() *MemStorage {
+	return &MemStorage{
+		files: map[string]*memEntry{
+			".": {mode: fs.ModeDir | 0755, modTime: time.Now()},
+		},
+	}
+}
+
+// NewMemStorageFromMap creates a memory storage populated with the given files,
+// parent folders are created as required.
+func NewMemStorageFromMap(files map[string]string) *MemStorage {
+	s := NewMemStorage()
+	for name, content := range files {
+		name = cleanStoragePath(name)
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := s.files[dir]; !ok {
+				s.files[dir] = &memEntry{mode: fs.ModeDir | 0755, modTime: time.Now()}
+			}
+		}
+		s.files[name] = &memEntry{data: []byte(content), mode: 0644, modTime: time.Now()}
+	}
+	return s
+}
+
+func cleanStoragePath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+func (s *MemStorage) lookup(p string) (string, *memEntry, error) {
+	if p == "" {
+		return "", nil, errors.New("sub path is empty")
+	}
+	p = cleanStoragePath(p)
+	if p == "" {
+		p = "."
+	}
+	return p, s.files[p], nil
+}
+
+func (s *MemStorage) Stat(p string) (os.FileInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, entry, err := s.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, &fs.PathError{Op: "stat", Path: p, Err: fs.ErrNotExist}
+	}
+	return &fileInfo{name: path.Base(p), size: int64(len(entry.data)), mode: entry.mode, modTime: entry.modTime}, nil
+}
+
+func (s *MemStorage) ReadFile(p string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, entry, err := s.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	if entry.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: p, Err: errors.New("is a directory")}
+	}
+	data := make([]byte, len(entry.data))
+	copy(data, entry.data)
+	return data, nil
+}
+
+func (s *MemStorage) WriteFile(p string, data []byte, perm fs.FileMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.writeFile(p, data, perm)
+}
+
+// writeFile creates or replaces a file, the write lock must be held
+func (s *MemStorage) writeFile(p string, data []byte, perm fs.FileMode) error {
+	p, entry, err := s.lookup(p)
+	if err != nil {
+		return err
+	}
+	if entry != nil && entry.mode.IsDir() {
+		return &fs.PathError{Op: "open", Path: p, Err: errors.New("is a directory")}
+	}
+	if parent, ok := s.files[path.Dir(p)]; !ok || !parent.mode.IsDir() {
+		return &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	s.files[p] = &memEntry{data: buf, mode: perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (s *MemStorage) AppendFile(p string, data []byte, perm fs.FileMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exist := s.files[cleanStoragePath(p)]
+	if exist && !entry.mode.IsDir() {
+		entry.data = append(entry.data, data...)
+		entry.modTime = time.Now()
+		return nil
+	}
+	return s.writeFile(p, data, perm)
+}
+
+func (s *MemStorage) ListFiles(p string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, entry, err := s.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil || !entry.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: p, Err: fs.ErrNotExist}
+	}
+
+	var fileNames []string
+	for name := range s.files {
+		if name != "." && path.Dir(name) == p {
+			fileNames = append(fileNames, path.Base(name))
+		}
+	}
+	sort.Strings(fileNames)
+	return fileNames, nil
+}
+
+func (s *MemStorage) Mkdir(p string, perm fs.FileMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, entry, err := s.lookup(p)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		return &fs.PathError{Op: "mkdir", Path: p, Err: fs.ErrExist}
+	}
+	if parent, ok := s.files[path.Dir(p)]; !ok || !parent.mode.IsDir() {
+		return &fs.PathError{Op: "mkdir", Path: p, Err: fs.ErrNotExist}
+	}
+	s.files[p] = &memEntry{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (s *MemStorage) Remove(p string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, entry, err := s.lookup(p)
+	if err != nil {
+		return err
+	}
+	if entry == nil || p == "." {
+		return &fs.PathError{Op: "remove", Path: p, Err: fs.ErrNotExist}
+	}
+	if entry.mode.IsDir() {
+		for name := range s.files {
+			if name != p && path.Dir(name) == p {
+				return &fs.PathError{Op: "remove", Path: p, Err: errors.New("directory not empty")}
+			}
+		}
+	}
+	delete(s.files, p)
+	return nil
+}
@@ -0,0 +1,72 @@
+package agent
+
+/*
+	Plugins execute the actions. An action selects its plugin using the exec.plugin
+	field and the plugin receives the action parameters once rendered. Plugins
+	register themselves from an init function, the same way the cli commands do:
+
+		func init() {
+			agent.RegisterPlugin("shell", &shellPlugin{})
+		}
+*/
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/a13labs/cobot/internal/algo"
+)
+
+// Plugin is implemented by anything able to execute an action
+type Plugin interface {
+	// Execute runs the action with the rendered parameters and returns its output
+	Execute(ctx context.Context, params map[string]interface{}) (string, error)
+}
+
+// ArgumentQuoter is implemented by the plugins running the rendered parameters
+// as a command line, the values of all the placeholders are quoted with
+// QuoteArgument before they are substituted so they are never interpreted. The
+// placeholders of such a plugin must not be written inside quotes.
+type ArgumentQuoter interface {
+	QuoteArgument(value string) string
+}
+
+var (
+	pluginsMu sync.RWMutex
+	plugins   = map[string]Plugin{}
+)
+
+// RegisterPlugin makes a plugin available under the given name, registering
+// the same name twice panics.
+func RegisterPlugin(name string, plugin Plugin) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+
+	if _, exist := plugins[name]; exist {
+		panic("plugin already registered: " + name)
+	}
+	plugins[name] = plugin
+}
+
+// GetPlugin returns the plugin registered under the given name
+func GetPlugin(name string) (Plugin, bool) {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+
+	plugin, ok := plugins[name]
+	return plugin, ok
+}
+
+// PluginNames returns the names of all the registered plugins, sorted
+func PluginNames() algo.StringList {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+
+	names := make(algo.StringList, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
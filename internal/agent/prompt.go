@@ -0,0 +1,174 @@
+package agent
+
+/*
+	The agent may need to ask the user something while it handles a message,
+	e.g. to confirm an action. A prompt is bound to the conversation and to the
+	user that sent the message, only that user can answer it.
+
+	Channels implementing Prompter present the prompt themselves (buttons, a
+	dedicated frame, ...) and report the answer with Answer. On the other
+	channels the prompt is sent as a text reply and the next message of the user
+	in the conversation is taken as the answer.
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PromptTimeout is how long the agent waits for an answer
+var PromptTimeout = 2 * time.Minute
+
+var (
+	ErrPromptTimeout  = errors.New("no answer received in time")
+	ErrPromptNotFound = errors.New("prompt not found or already answered")
+)
+
+// Option added to the choices so the user can refuse them all
+const cancelAnswer = "cancel"
+
+// Answers accepted as a confirmation
+var confirmAnswers = []string{"yes", "y"}
+
+// Prompt is a question asked to the user, Options lists the accepted answers
+// when it is not empty
+type Prompt struct {
+	Id      string   `json:"id"`
+	Text    string   `json:"text"`
+	Options []string `json:"options,omitempty"`
+}
+
+// Prompter is implemented by the channels able to present prompts
+type Prompter interface {
+	SendPrompt(conversation string, prompt Prompt) error
+}
+
+// PromptCloser is implemented by the prompters updating a prompt once it is
+// answered or expired, err is nil when it was answered
+type PromptCloser interface {
+	ClosePrompt(conversation string, prompt Prompt, answer string, err error)
+}
+
+type pendingPrompt struct {
+	prompt Prompt
+	user   string
+	answer chan string
+}
+
+func promptKey(channel string, conversation string) string {
+	return channel + "/" + conversation
+}
+
+// Ask asks a question to the sender of the given message and waits for the
+// answer, until the prompt times out or the message is cancelled. The worker
+// of the message is free for the other messages while it waits.
+func (ctx *AgentCtx) Ask(msg Message, text string, options []string) (string, error) {
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	pending := &pendingPrompt{
+		prompt: Prompt{Id: hex.EncodeToString(buf), Text: ctx.Secrets.Redact(text), Options: options},
+		user:   msg.User,
+		answer: make(chan string, 1),
+	}
+
+	key := promptKey(msg.Channel, msg.Conversation)
+	ctx.promptsMu.Lock()
+	if ctx.prompts == nil {
+		ctx.prompts = map[string]*pendingPrompt{}
+	}
+	// A new prompt replaces the previous one of the conversation
+	ctx.prompts[key] = pending
+	ctx.promptsMu.Unlock()
+
+	defer func() {
+		ctx.promptsMu.Lock()
+		if ctx.prompts[key] == pending {
+			delete(ctx.prompts, key)
+		}
+		ctx.promptsMu.Unlock()
+	}()
+
+	channel, ok := ctx.GetChannel(msg.Channel)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrChannelNotFound, msg.Channel)
+	}
+	// The question follows the replies already sent to the conversation
+	if prompter, ok := channel.(Prompter); ok {
+		ctx.flushOutput()
+		if err := prompter.SendPrompt(msg.Conversation, pending.prompt); err != nil {
+			return "", err
+		}
+	} else {
+		question := pending.prompt.Text
+		if len(options) > 0 {
+			question = fmt.Sprintf("%s (%s)", question, strings.Join(options, "/"))
+		}
+		if err := ctx.sendOrdered(Reply{Channel: msg.Channel, Conversation: msg.Conversation, Text: question}); err != nil {
+			return "", err
+		}
+	}
+
+	// The worker is given back while the sender answers
+	msg.worker.release()
+
+	var answer string
+	var err error
+	select {
+	case answer = <-pending.answer:
+	case <-time.After(PromptTimeout):
+		err = ErrPromptTimeout
+	case <-msg.Context().Done():
+		err = msg.Context().Err()
+	}
+	if closer, ok := channel.(PromptCloser); ok {
+		closer.ClosePrompt(msg.Conversation, pending.prompt, answer, err)
+	}
+	if workerErr := msg.worker.acquire(msg.Context()); err == nil {
+		err = workerErr
+	}
+	return answer, err
+}
+
+// Confirm asks the sender of the given message a yes/no question
+func (ctx *AgentCtx) Confirm(msg Message, text string) (bool, error) {
+	answer, err := ctx.Ask(msg, text, []string{"yes", "no"})
+	if err != nil {
+		return false, err
+	}
+	return IsConfirmation(answer), nil
+}
+
+// IsConfirmation returns true if the given answer confirms a prompt
+func IsConfirmation(answer string) bool {
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	for _, accepted := range confirmAnswers {
+		if answer == accepted {
+			return true
+		}
+	}
+	return false
+}
+
+// Answer answers the pending prompt of a conversation. An empty id answers any
+// pending prompt. ErrPromptNotFound is returned when the prompt expired, was
+// already answered or was asked to another user.
+func (ctx *AgentCtx) Answer(channel string, conversation string, user string, id string, answer string) error {
+	ctx.promptsMu.Lock()
+	defer ctx.promptsMu.Unlock()
+
+	key := promptKey(channel, conversation)
+	pending, ok := ctx.prompts[key]
+	if !ok || pending.user != user || (id != "" && pending.prompt.Id != id) {
+		return ErrPromptNotFound
+	}
+	delete(ctx.prompts, key)
+	pending.answer <- answer
+	return nil
+}
@@ -0,0 +1,137 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+type promptChannel struct {
+	fakeChannel
+	prompts chan agent.Prompt
+}
+
+func (c *promptChannel) SendPrompt(conversation string, prompt agent.Prompt) error {
+	c.prompts <- prompt
+	return nil
+}
+
+func TestAskOnTextChannel(t *testing.T) {
+	ctx := &agent.AgentCtx{}
+	channel := &fakeChannel{name: "console"}
+	if err := ctx.AddChannel(channel); err != nil {
+		t.Fatal(err)
+	}
+	msg := agent.Message{Channel: "console", Conversation: "console", User: "alice", Text: "reboot"}
+
+	result := make(chan bool)
+	go func() {
+		confirmed, err := ctx.Confirm(msg, "Reboot?")
+		if err != nil {
+			t.Error(err)
+		}
+		result <- confirmed
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(channel.sentTo("console")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("prompt was not sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sent := channel.sentTo("console"); sent[0] != "Reboot? (yes/no)" {
+		t.Errorf("prompt text = %q", sent[0])
+	}
+
+	// Only the user that sent the message can answer
+	if err := ctx.Answer("console", "console", "mallory", "", "yes"); !errors.Is(err, agent.ErrPromptNotFound) {
+		t.Errorf("Answer() by another user error = %v; want ErrPromptNotFound", err)
+	}
+
+	ctx.DispatchMessage(agent.Message{Channel: "console", Conversation: "console", User: "alice", Text: "Yes"})
+	if !<-result {
+		t.Errorf("Confirm() = false; want true")
+	}
+
+	// The prompt was answered, it can not be answered again
+	if err := ctx.Answer("console", "console", "alice", "", "yes"); !errors.Is(err, agent.ErrPromptNotFound) {
+		t.Errorf("Answer() error = %v; want ErrPromptNotFound", err)
+	}
+}
+
+func TestAskFollowsTheQueuedReplies(t *testing.T) {
+	ctx, channel := startAgent(t, nil)
+	defer ctx.Shutdown(context.Background())
+	msg := agent.Message{Channel: "console", Conversation: "console", User: "alice", Text: "reboot"}
+
+	ctx.Reply(msg, "Rebooting soon.")
+	result := make(chan error)
+	go func() {
+		_, err := ctx.Confirm(msg, "Reboot?")
+		result <- err
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(channel.sentTo("console")) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("prompt was not sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sent := channel.sentTo("console"); sent[0] != "Rebooting soon." || sent[1] != "Reboot? (yes/no)" {
+		t.Errorf("sent = %q; want the reply before the prompt", sent)
+	}
+
+	if err := ctx.Answer("console", "console", "alice", "", "no"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Confirm() error = %v", err)
+	}
+}
+
+func TestAskOnPrompter(t *testing.T) {
+	ctx := &agent.AgentCtx{}
+	channel := &promptChannel{fakeChannel: fakeChannel{name: "ws"}, prompts: make(chan agent.Prompt, 1)}
+	if err := ctx.AddChannel(channel); err != nil {
+		t.Fatal(err)
+	}
+	msg := agent.Message{Channel: "ws", Conversation: "1", User: "alice"}
+
+	result := make(chan string)
+	go func() {
+		answer, _ := ctx.Ask(msg, "Which computer?", []string{"fedora", "mac"})
+		result <- answer
+	}()
+
+	prompt := <-channel.prompts
+	if err := ctx.Answer("ws", "1", "alice", "wrong-id", "fedora"); !errors.Is(err, agent.ErrPromptNotFound) {
+		t.Errorf("Answer() with a wrong id error = %v", err)
+	}
+	if err := ctx.Answer("ws", "1", "alice", prompt.Id, "mac"); err != nil {
+		t.Fatal(err)
+	}
+	if answer := <-result; answer != "mac" {
+		t.Errorf("Ask() = %q; want mac", answer)
+	}
+}
+
+func TestAskTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { agent.PromptTimeout = timeout }(agent.PromptTimeout)
+	agent.PromptTimeout = 10 * time.Millisecond
+
+	ctx := &agent.AgentCtx{}
+	ctx.AddChannel(&fakeChannel{name: "console"})
+	msg := agent.Message{Channel: "console", Conversation: "console", User: "alice"}
+
+	if _, err := ctx.Confirm(msg, "Reboot?"); !errors.Is(err, agent.ErrPromptTimeout) {
+		t.Errorf("Confirm() error = %v; want ErrPromptTimeout", err)
+	}
+	if err := ctx.Answer("console", "console", "alice", "", "yes"); !errors.Is(err, agent.ErrPromptNotFound) {
+		t.Errorf("Answer() on an expired prompt error = %v", err)
+	}
+}
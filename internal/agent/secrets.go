@@ -0,0 +1,267 @@
+package agent
+
+/*
+	Secrets are kept encrypted in the storage (secrets.enc) so they can be
+	versioned together with the actions. The encryption key is never stored in
+	the git repository, it is read from the COBOT_SECRETS_KEY environment variable
+	or from local/secrets.key. Both hold a base64 encoded 256 bits key.
+
+	Secrets are referenced from action parameters using ${secret:name}. Any secret
+	value known by the store is redacted from logs, chat output and LLM prompts.
+*/
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/a13labs/cobot/internal/algo"
+)
+
+const (
+	SecretsFile    = "secrets.enc"
+	SecretsKeyFile = "local/secrets.key"
+	SecretsKeyEnv  = "COBOT_SECRETS_KEY"
+
+	secretsHeader = "cobot-secrets-v1\n"
+
+	// RedactedText replaces any secret value found in a text
+	RedactedText = "******"
+
+	// Shorter values are refused, they are redacted everywhere they appear so
+	// common words and single characters would be mangled
+	minSecretLength = 4
+)
+
+var ErrNoSecretsKey = errors.New("secrets key not found, set " + SecretsKeyEnv + " or create " + SecretsKeyFile)
+
+var secretNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+type SecretStore struct {
+	mu      sync.RWMutex
+	storage Storage
+	key     []byte
+	secrets map[string]string
+}
+
+// NewSecretStore loads the secrets from the given storage. When createKey is
+// true and no key is available a new one is generated and written to
+// local/secrets.key, the local/ folder is ignored by git beforehand.
+func NewSecretStore(storage Storage, createKey bool) (*SecretStore, error) {
+
+	s := &SecretStore{
+		storage: storage,
+		secrets: map[string]string{},
+	}
+
+	key, err := loadSecretsKey(storage)
+	if err != nil && !errors.Is(err, ErrNoSecretsKey) {
+		return nil, err
+	}
+	s.key = key
+
+	data, err := storage.ReadFile(SecretsFile)
+	if err != nil {
+		if _, statErr := storage.Stat(SecretsFile); statErr == nil {
+			return nil, err
+		}
+		// No secrets defined yet
+		data = nil
+	}
+
+	if data != nil {
+		if s.key == nil {
+			return nil, ErrNoSecretsKey
+		}
+		if err := s.decrypt(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.key == nil && createKey {
+		if s.key, err = createSecretsKey(storage); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func loadSecretsKey(storage Storage) ([]byte, error) {
+	encoded := os.Getenv(SecretsKeyEnv)
+	if encoded == "" {
+		data, err := storage.ReadFile(SecretsKeyFile)
+		if err != nil {
+			return nil, ErrNoSecretsKey
+		}
+		encoded = string(data)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("invalid secrets key, expected a base64 encoded 32 bytes key")
+	}
+	return key, nil
+}
+
+func createSecretsKey(storage Storage) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	// The key must never reach the git repository next to the secrets
+	if err := IgnoreLocal(storage); err != nil {
+		return nil, err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(key) + "\n"
+	if err := storage.WriteFile(SecretsKeyFile, []byte(encoded), 0600); err != nil {
+		return nil, err
+	}
+
+	GetLogger().Info("Created a new secrets key in %s, keep a copy of it in a safe place", SecretsKeyFile)
+	return key, nil
+}
+
+func (s *SecretStore) decrypt(data []byte) error {
+	text := string(data)
+	if !strings.HasPrefix(text, secretsHeader) {
+		return errors.New("invalid secrets file format")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(text, secretsHeader)))
+	if err != nil {
+		return errors.New("invalid secrets file encoding")
+	}
+
+	gcm, err := newGCM(s.key)
+	if err != nil {
+		return err
+	}
+	if len(raw) < gcm.NonceSize() {
+		return errors.New("invalid secrets file content")
+	}
+
+	plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], []byte(secretsHeader))
+	if err != nil {
+		return errors.New("unable to decrypt secrets, wrong key?")
+	}
+
+	return json.Unmarshal(plain, &s.secrets)
+}
+
+func (s *SecretStore) save() error {
+	if s.key == nil {
+		return ErrNoSecretsKey
+	}
+
+	plain, err := json.Marshal(s.secrets)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(s.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plain, []byte(secretsHeader))
+	data := secretsHeader + base64.StdEncoding.EncodeToString(sealed) + "\n"
+	return s.storage.WriteFile(SecretsFile, []byte(data), 0644)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Set stores a secret and writes the secrets file
+func (s *SecretStore) Set(name string, value string) error {
+	if !secretNameRe.MatchString(name) {
+		return fmt.Errorf("invalid secret name '%s'", name)
+	}
+	if len(value) < minSecretLength {
+		return fmt.Errorf("secret '%s' is too short, at least %d characters are required", name, minSecretLength)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.secrets[name] = value
+	return s.save()
+}
+
+// Get returns the value of a secret
+func (s *SecretStore) Get(name string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.secrets[name]
+	return value, ok
+}
+
+// Remove deletes a secret and writes the secrets file
+func (s *SecretStore) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.secrets[name]; !ok {
+		return fmt.Errorf("secret '%s' not found", name)
+	}
+	delete(s.secrets, name)
+	return s.save()
+}
+
+// Names returns the names of all the secrets, sorted
+func (s *SecretStore) Names() algo.StringList {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make(algo.StringList, 0, len(s.secrets))
+	for name := range s.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Redact replaces every secret value found in the given text
+func (s *SecretStore) Redact(text string) string {
+	if s == nil {
+		return text
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Replace the longest values first, a secret may contain another one
+	values := make([]string, 0, len(s.secrets))
+	for _, value := range s.secrets {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	for _, value := range values {
+		text = strings.ReplaceAll(text, value, RedactedText)
+	}
+	return text
+}
@@ -0,0 +1,126 @@
+package agent_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+func TestSecretStore(t *testing.T) {
+	t.Setenv(agent.SecretsKeyEnv, "")
+	s := agent.NewMemStorage()
+
+	// Without a key secrets can't be written
+	store, err := agent.NewSecretStore(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("token", "abcdef"); !errors.Is(err, agent.ErrNoSecretsKey) {
+		t.Errorf("Set without key error = %v; want ErrNoSecretsKey", err)
+	}
+}
+
+func TestSecretStoreRoundTrip(t *testing.T) {
+	s := agent.NewMemStorage()
+
+	store, err := agent.NewSecretStore(s, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat(agent.SecretsKeyFile); err != nil {
+		t.Fatalf("key file was not created: %v", err)
+	}
+	if data, err := s.ReadFile(agent.LocalIgnoreFile); err != nil || string(data) != "*\n" {
+		t.Errorf("%s = %q, %v; want the local files ignored", agent.LocalIgnoreFile, data, err)
+	}
+	if err := store.Set("api.token", "s3cr3t-value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("password", "hunter22"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("bad name", "x"); err == nil {
+		t.Errorf("Set with an invalid name should fail")
+	}
+	if err := store.Set("pin", "123"); err == nil {
+		t.Errorf("Set with a too short value should fail")
+	}
+
+	data, err := s.ReadFile(agent.SecretsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cr3t-value") {
+		t.Errorf("secrets file holds plaintext values")
+	}
+
+	reloaded, err := agent.NewSecretStore(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := reloaded.Get("api.token"); !ok || value != "s3cr3t-value" {
+		t.Errorf("Get(api.token) = %q, %v", value, ok)
+	}
+	if names := reloaded.Names(); len(names) != 2 || names[0] != "api.token" {
+		t.Errorf("Names() = %v", names)
+	}
+
+	if err := reloaded.Remove("password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Remove("password"); err == nil {
+		t.Errorf("Remove of a missing secret should fail")
+	}
+
+	// A wrong key must not decrypt the file
+	if err := s.Remove(agent.SecretsKeyFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := agent.NewSecretStore(s, false); !errors.Is(err, agent.ErrNoSecretsKey) {
+		t.Errorf("NewSecretStore without key error = %v; want ErrNoSecretsKey", err)
+	}
+	t.Setenv(agent.SecretsKeyEnv, "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	if _, err := agent.NewSecretStore(s, false); err == nil {
+		t.Errorf("NewSecretStore with a wrong key should fail")
+	}
+}
+
+func TestSecretsRedactionAndRendering(t *testing.T) {
+	s := agent.NewMemStorage()
+	store, err := agent.NewSecretStore(s, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("pw", "hunter22"); err != nil {
+		t.Fatal(err)
+	}
+
+	params := map[string]interface{}{
+		"command":    "login --password ${secret:pw} ${service.name}",
+		"privileged": false,
+		"env":        []interface{}{"PW=${secret:pw}"},
+	}
+	rendered, err := agent.RenderParameters(params, agent.SecretResolver(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered["command"] != "login --password hunter22 ${service.name}" {
+		t.Errorf("command = %q", rendered["command"])
+	}
+	if rendered["env"].([]interface{})[0] != "PW=hunter22" {
+		t.Errorf("env = %v", rendered["env"])
+	}
+	if params["command"] != "login --password ${secret:pw} ${service.name}" {
+		t.Errorf("RenderParameters modified its input")
+	}
+
+	if _, err := agent.RenderParameters(map[string]interface{}{"x": "${secret:missing}"}, agent.SecretResolver(store)); err == nil {
+		t.Errorf("unknown secret should fail to render")
+	}
+
+	if got := store.Redact("password is hunter22."); got != "password is "+agent.RedactedText+"." {
+		t.Errorf("Redact() = %q", got)
+	}
+}
@@ -16,7 +16,7 @@ package agent
 		- plugin1.yaml
 		- plugin2.yaml
 		- ...
-	- local/ (folder containing local files, not stored in git)
+	- local/ (folder containing local files, ignored by git through local/.gitignore)
 		- logs/ (folder containing log files)
 		- plugins/ (folder containing plugin binary files)
 			- plugin1/
@@ -27,6 +27,10 @@ package agent
 				- resources/ (folder containing plugin resources)
 			- ...
 		- cache/ (folder containing cache files)
+		- uploads/ (folder containing the files received from the users)
+		- telegram/ (folder containing the Telegram channel state)
+		- control.sock (socket of the control channel)
+		- cobot.pid (process id of the agent installed as a service)
 
 	When initializing the storage, a path to an existing git repository must be provided.
 	It is the responsibility of the caller to ensure that the git repository is properly
@@ -35,23 +39,141 @@ package agent
 	The agent will create the structure of the storage in the git repository, if it does not exist.
 
 	The agent will load the configuration and actions from the storage.
+
+	Storage is an interface so the agent can work against different backends:
+	- FileStorage: a git working tree checked out on the local filesystem
+	- MemStorage: an in-memory tree, mainly useful for tests
+	- GitStorage: a read-only view of a given commit or branch, served straight
+	  from the git object database without a checkout, local/ is still kept on
+	  the filesystem
 */
 
 import (
 	"errors"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/a13labs/cobot/internal/algo"
 	"github.com/a13labs/cobot/internal/io"
 	"gopkg.in/src-d/go-git.v4"
 )
 
-type Storage struct {
+// Storage is the set of operations the agent requires from a storage backend.
+// Paths are always relative to the storage root and use '/' as separator.
+type Storage interface {
+	Stat(path string) (os.FileInfo, error)
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte, perm fs.FileMode) error
+	ListFiles(path string) ([]string, error)
+	Mkdir(path string, perm fs.FileMode) error
+	Remove(path string) error
+}
+
+// Appender is implemented by the backends able to append data to a file
+// without rewriting it, the file is created when it does not exist
+type Appender interface {
+	AppendFile(path string, data []byte, perm fs.FileMode) error
+}
+
+// AppendFile appends data to a file using the backend Appender implementation
+// when available, otherwise the file is rewritten
+func AppendFile(storage Storage, path string, data []byte, perm fs.FileMode) error {
+	if appender, ok := storage.(Appender); ok {
+		return appender.AppendFile(path, data, perm)
+	}
+	current, err := storage.ReadFile(path)
+	if err != nil {
+		if _, statErr := storage.Stat(path); statErr == nil {
+			return err
+		}
+		current = nil
+	}
+	return storage.WriteFile(path, append(current, data...), perm)
+}
+
+// EnsureDir creates the given folder and its parents when they don't exist
+func EnsureDir(storage Storage, dir string, perm fs.FileMode) error {
+	current := ""
+	for _, segment := range strings.Split(cleanStoragePath(dir), "/") {
+		if segment == "" {
+			continue
+		}
+		current = strings.TrimPrefix(current+"/"+segment, "/")
+		if fi, err := storage.Stat(current); err == nil {
+			if !fi.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: current, Err: errors.New("not a directory")}
+			}
+			continue
+		}
+		if err := storage.Mkdir(current, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrReadOnlyStorage is returned by backends that do not support writing
+var ErrReadOnlyStorage = errors.New("storage is read-only")
+
+// CheckLocalWritable returns an error when the files of the local/ folder
+// can't be written
+func CheckLocalWritable(storage Storage) error {
+	const probe = "local/.write-test"
+	if err := EnsureDir(storage, "local", 0700); err != nil {
+		return err
+	}
+	if err := storage.WriteFile(probe, nil, 0600); err != nil {
+		return err
+	}
+	return storage.Remove(probe)
+}
+
+// LocalIgnoreFile keeps the files of the local/ folder out of the git repository
+const LocalIgnoreFile = "local/.gitignore"
+
+// IgnoreLocal makes git ignore everything under local/, the .gitignore file
+// included, so the local files are never committed by mistake
+func IgnoreLocal(storage Storage) error {
+	data, err := storage.ReadFile(LocalIgnoreFile)
+	if err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(line) == "*" {
+				return nil
+			}
+		}
+		if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+			data = append(data, '\n')
+		}
+	} else if err := EnsureDir(storage, "local", 0700); err != nil {
+		return err
+	}
+	return storage.WriteFile(LocalIgnoreFile, append(data, "*\n"...), 0644)
+}
+
+// fileInfo is a minimal os.FileInfo used by the non filesystem backends
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+// FileStorage is a storage backed by a git working tree on the local filesystem
+type FileStorage struct {
 	localPath string
 }
 
-func NewStorage(path string) (*Storage, error) {
+func NewFileStorage(path string) (*FileStorage, error) {
 
 	logger := GetLogger()
 
@@ -63,12 +185,17 @@ func NewStorage(path string) (*Storage, error) {
 		return nil, errors.New("storage path is not a valid git repository")
 	}
 
-	return &Storage{
+	return &FileStorage{
 		localPath: path,
 	}, nil
 }
 
-func (s *Storage) Stat(path string) (os.FileInfo, error) {
+// LocalPath returns the path of a storage file on the local filesystem
+func (s *FileStorage) LocalPath(path string) string {
+	return filepath.Join(s.localPath, path)
+}
+
+func (s *FileStorage) Stat(path string) (os.FileInfo, error) {
 
 	logger := GetLogger()
 
@@ -78,17 +205,22 @@ func (s *Storage) Stat(path string) (os.FileInfo, error) {
 		return nil, errors.New("sub path is empty")
 	}
 
-	// Get the file info
+	// Get the file info, a missing file is not an error for the callers
+	// checking if it exists
 	fileInfo, err := os.Stat(s.localPath + "/" + path)
+	if errors.Is(err, fs.ErrNotExist) {
+		logger.Debug("File %s does not exist", path)
+		return nil, err
+	}
 	if err != nil {
-		logger.Error("Error getting file info for path " + path)
+		logger.Error("Error getting file info for path %s: %s", path, err)
 		return nil, err
 	}
 
 	return fileInfo, nil
 }
 
-func (s *Storage) ReadFile(path string) ([]byte, error) {
+func (s *FileStorage) ReadFile(path string) ([]byte, error) {
 
 	logger := GetLogger()
 
@@ -108,7 +240,7 @@ func (s *Storage) ReadFile(path string) ([]byte, error) {
 	return data, nil
 }
 
-func (s *Storage) WriteFile(path string, data []byte, perm fs.FileMode) error {
+func (s *FileStorage) WriteFile(path string, data []byte, perm fs.FileMode) error {
 
 	logger := GetLogger()
 
@@ -128,7 +260,33 @@ func (s *Storage) WriteFile(path string, data []byte, perm fs.FileMode) error {
 	return nil
 }
 
-func (s *Storage) OpenFileStream(path string) (*io.BinaryFileStream, error) {
+func (s *FileStorage) AppendFile(path string, data []byte, perm fs.FileMode) error {
+
+	logger := GetLogger()
+
+	// Check if the sub path is empty
+	if path == "" {
+		logger.Error("sub path is empty")
+		return errors.New("sub path is empty")
+	}
+
+	// Append to the file
+	f, err := os.OpenFile(s.localPath+"/"+path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, perm)
+	if err != nil {
+		logger.Error("Error opening file")
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		logger.Error("Error appending to file")
+		return err
+	}
+
+	return f.Sync()
+}
+
+func (s *FileStorage) OpenFileStream(path string) (*io.BinaryFileStream, error) {
 
 	logger := GetLogger()
 
@@ -149,7 +307,7 @@ func (s *Storage) OpenFileStream(path string) (*io.BinaryFileStream, error) {
 
 }
 
-func (s *Storage) RemoveFile(path string) error {
+func (s *FileStorage) RemoveFile(path string) error {
 
 	logger := GetLogger()
 
@@ -169,7 +327,7 @@ func (s *Storage) RemoveFile(path string) error {
 	return nil
 }
 
-func (s *Storage) ListFiles(path string) ([]string, error) {
+func (s *FileStorage) ListFiles(path string) ([]string, error) {
 
 	logger := GetLogger()
 
@@ -194,7 +352,7 @@ func (s *Storage) ListFiles(path string) ([]string, error) {
 	return fileNames, nil
 }
 
-func (s *Storage) MkdirAll(path string, perm fs.FileMode) error {
+func (s *FileStorage) MkdirAll(path string, perm fs.FileMode) error {
 
 	logger := GetLogger()
 
@@ -214,7 +372,7 @@ func (s *Storage) MkdirAll(path string, perm fs.FileMode) error {
 	return nil
 }
 
-func (s *Storage) Remove(path string) error {
+func (s *FileStorage) Remove(path string) error {
 
 	logger := GetLogger()
 
@@ -234,7 +392,7 @@ func (s *Storage) Remove(path string) error {
 	return nil
 }
 
-func (s *Storage) RemoveAll(path string) error {
+func (s *FileStorage) RemoveAll(path string) error {
 
 	logger := GetLogger()
 
@@ -254,7 +412,7 @@ func (s *Storage) RemoveAll(path string) error {
 	return nil
 }
 
-func (s *Storage) Mkdir(path string, perm fs.FileMode) error {
+func (s *FileStorage) Mkdir(path string, perm fs.FileMode) error {
 
 	logger := GetLogger()
 
@@ -274,7 +432,7 @@ func (s *Storage) Mkdir(path string, perm fs.FileMode) error {
 	return nil
 }
 
-func (s *Storage) HasLocalChanges() bool {
+func (s *FileStorage) HasLocalChanges() bool {
 
 	logger := GetLogger()
 
@@ -300,7 +458,7 @@ func (s *Storage) HasLocalChanges() bool {
 	return !status.IsClean()
 }
 
-func (s *Storage) Status(wildcard string) ([]string, error) {
+func (s *FileStorage) Status(wildcard string) ([]string, error) {
 
 	logger := GetLogger()
 
@@ -333,7 +491,7 @@ func (s *Storage) Status(wildcard string) ([]string, error) {
 	return changedFiles, nil
 }
 
-func (s *Storage) GetVersion() (string, error) {
+func (s *FileStorage) GetVersion() (string, error) {
 
 	logger := GetLogger()
 
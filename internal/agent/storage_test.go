@@ -0,0 +1,253 @@
+package agent_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/a13labs/cobot/internal/algo"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+const testAgentConfig = `agent:
+  name: tester
+actions:
+  - wake_up
+`
+
+const testWakeUpAction = `description: wake up a remote computer
+name: wake_up
+args:
+  - computer
+exec:
+  plugin: shell
+  parameters:
+    command: wakeonlan ${kb:computer.mac}
+`
+
+func testStorageContract(t *testing.T, s agent.Storage) {
+	if err := s.Mkdir("actions", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteFile("actions/a.yaml", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteFile("actions/b.yaml", []byte("bb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := s.Stat("actions")
+	if err != nil || !fi.IsDir() {
+		t.Errorf("Stat(actions) = %v, %v; want a directory", fi, err)
+	}
+	fi, err = s.Stat("actions/b.yaml")
+	if err != nil || fi.Size() != 2 {
+		t.Errorf("Stat(actions/b.yaml) = %v, %v; want size 2", fi, err)
+	}
+	if _, err := s.Stat("missing.yaml"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat(missing.yaml) error = %v; want not exist", err)
+	}
+
+	data, err := s.ReadFile("actions/a.yaml")
+	if err != nil || string(data) != "a" {
+		t.Errorf("ReadFile(actions/a.yaml) = %q, %v; want \"a\"", data, err)
+	}
+
+	names, err := s.ListFiles("actions")
+	if err != nil || !reflect.DeepEqual(names, []string{"a.yaml", "b.yaml"}) {
+		t.Errorf("ListFiles(actions) = %v, %v", names, err)
+	}
+
+	if err := s.Remove("actions/a.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat("actions/a.yaml"); err == nil {
+		t.Errorf("Stat(actions/a.yaml) succeeded after Remove")
+	}
+}
+
+func TestMemStorage(t *testing.T) {
+	s := agent.NewMemStorage()
+	testStorageContract(t, s)
+
+	if err := s.WriteFile("missing/file.yaml", []byte("x"), 0644); err == nil {
+		t.Errorf("WriteFile into a missing folder should fail")
+	}
+	if err := s.Remove("actions"); err == nil {
+		t.Errorf("Remove of a non empty folder should fail")
+	}
+}
+
+func TestMemStorageConcurrentAppends(t *testing.T) {
+	s := agent.NewMemStorage()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := agent.AppendFile(s, "log", []byte("x"), 0600); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := s.ReadFile("log")
+	if err != nil || len(data) != 50 {
+		t.Errorf("ReadFile() = %d bytes, %v; want the 50 appends", len(data), err)
+	}
+}
+
+func TestIgnoreLocal(t *testing.T) {
+	s := agent.NewMemStorageFromMap(map[string]string{agent.LocalIgnoreFile: "*.tmp"})
+
+	for i := 0; i < 2; i++ {
+		if err := agent.IgnoreLocal(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := s.ReadFile(agent.LocalIgnoreFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "*.tmp\n*\n" {
+		t.Errorf("%s = %q; want the existing patterns kept and * added once", agent.LocalIgnoreFile, data)
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := git.PlainInit(dir, false); err != nil {
+		t.Fatal(err)
+	}
+	s, err := agent.NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorageContract(t, s)
+}
+
+func TestGitStorage(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "actions"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "agent-config.yaml"), []byte(testAgentConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "actions", "wake_up.yaml"), []byte(testWakeUpAction), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("."); err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	if _, err := wt.Commit("initial", &git.CommitOptions{Author: sig}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes in the working tree must not be visible
+	if err := os.WriteFile(filepath.Join(dir, "agent-config.yaml"), []byte("agent:\n  name: changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := agent.NewGitStorage(dir, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := agent.LoadAgentConfig(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Agent.Name != "tester" {
+		t.Errorf("Agent name = %q; want tester", cfg.Agent.Name)
+	}
+
+	fi, err := s.Stat("actions")
+	if err != nil || !fi.IsDir() {
+		t.Errorf("Stat(actions) = %v, %v; want a directory", fi, err)
+	}
+	names, err := s.ListFiles("actions")
+	if err != nil || !reflect.DeepEqual(names, []string{"wake_up.yaml"}) {
+		t.Errorf("ListFiles(actions) = %v, %v", names, err)
+	}
+
+	if err := s.WriteFile("agent-config.yaml", []byte{}, 0644); !errors.Is(err, agent.ErrReadOnlyStorage) {
+		t.Errorf("WriteFile error = %v; want ErrReadOnlyStorage", err)
+	}
+	if err := s.Remove("agent-config.yaml"); !errors.Is(err, agent.ErrReadOnlyStorage) {
+		t.Errorf("Remove error = %v; want ErrReadOnlyStorage", err)
+	}
+
+	// The local files are kept on the filesystem
+	if err := agent.CheckLocalWritable(s); err != nil {
+		t.Errorf("CheckLocalWritable() = %v", err)
+	}
+	audit, err := agent.OpenAuditLog(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := audit.Append(agent.AuditRecord{Channel: "console", User: "alice", Result: agent.AuditSuccess}); err != nil {
+		t.Errorf("Append() = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, agent.AuditLogFile)); err != nil {
+		t.Errorf("audit log not written to the filesystem: %v", err)
+	}
+
+	db, err := agent.NewActionDB(cfg, s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db.Actions["wake_up"]; !ok {
+		t.Errorf("wake_up action not loaded from git storage")
+	}
+}
+
+func TestLoadAgentConfigCreatesDefault(t *testing.T) {
+	s := agent.NewMemStorage()
+	cfg, err := agent.LoadAgentConfig(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Agent.Name != "default" {
+		t.Errorf("Agent name = %q; want default", cfg.Agent.Name)
+	}
+	if _, err := s.Stat("agent-config.yaml"); err != nil {
+		t.Errorf("default configuration was not written: %v", err)
+	}
+}
+
+func TestActionDBOnMemStorage(t *testing.T) {
+	s := agent.NewMemStorageFromMap(map[string]string{
+		"agent-config.yaml":    testAgentConfig,
+		"actions/wake_up.yaml": testWakeUpAction,
+	})
+	cfg := agent.AgentConfigFile{Actions: []agent.ActionRef{{Name: "wake_up"}, {Name: "missing"}}}
+	db, err := agent.NewActionDB(cfg, s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(db.ActionNames, algo.StringList{"wake_up"}) {
+		t.Errorf("ActionNames = %v; want [wake_up]", db.ActionNames)
+	}
+	action, err := db.GetAction("wake_up")
+	if err != nil || action.Exec.Plugin != "shell" {
+		t.Errorf("GetAction(wake_up) = %v, %v", action, err)
+	}
+}
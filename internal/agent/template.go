@@ -0,0 +1,197 @@
+package agent
+
+/*
+	Action parameters may contain placeholders that are expanded before the
+	action runs:
+	- ${service.name}      value taken from the action arguments
+	- ${kb:computer.mac}   value taken from the knowledge base
+	- ${secret:name}       value taken from the secrets store
+*/
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var placeholderRe = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// Placeholder is a parsed ${kind:name} reference, Kind is empty for arguments
+type Placeholder struct {
+	Kind string
+	Name string
+}
+
+func (p Placeholder) String() string {
+	if p.Kind == "" {
+		return "${" + p.Name + "}"
+	}
+	return "${" + p.Kind + ":" + p.Name + "}"
+}
+
+// PlaceholderResolver returns the value of a placeholder, ok is false when the
+// resolver does not handle it and the placeholder must be kept as is.
+type PlaceholderResolver func(p Placeholder) (value string, ok bool, err error)
+
+func parsePlaceholder(expr string) Placeholder {
+	kind, name, found := strings.Cut(expr, ":")
+	if !found {
+		return Placeholder{Name: strings.TrimSpace(expr)}
+	}
+	return Placeholder{Kind: strings.TrimSpace(kind), Name: strings.TrimSpace(name)}
+}
+
+// FindPlaceholders returns all the placeholders in the given text
+func FindPlaceholders(text string) []Placeholder {
+	var placeholders []Placeholder
+	for _, match := range placeholderRe.FindAllStringSubmatch(text, -1) {
+		placeholders = append(placeholders, parsePlaceholder(match[1]))
+	}
+	return placeholders
+}
+
+// QuotedPlaceholders returns the placeholders of a command line written inside
+// single or double quotes, their quoted value would be quoted again
+func QuotedPlaceholders(text string) []Placeholder {
+	var placeholders []Placeholder
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\\' && quote != '\'':
+			i++
+		case c == '\'' || c == '"':
+			if quote == 0 {
+				quote = c
+			} else if quote == c {
+				quote = 0
+			}
+		case c == '$' && quote != 0:
+			if loc := placeholderRe.FindStringSubmatchIndex(text[i:]); loc != nil && loc[0] == 0 {
+				placeholders = append(placeholders, parsePlaceholder(text[i+loc[2]:i+loc[3]]))
+				i += loc[1] - 1
+			}
+		}
+	}
+	return placeholders
+}
+
+// ExpandPlaceholders replaces all the placeholders in the given text
+func ExpandPlaceholders(text string, resolver PlaceholderResolver) (string, error) {
+	var resolveErr error
+	result := placeholderRe.ReplaceAllStringFunc(text, func(match string) string {
+		if resolveErr != nil {
+			return match
+		}
+		value, ok, err := resolver(parsePlaceholder(match[2 : len(match)-1]))
+		if err != nil {
+			resolveErr = err
+			return match
+		}
+		if !ok {
+			return match
+		}
+		return value
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	return result, nil
+}
+
+// RenderParameters expands the placeholders of all the string values found in
+// the given parameters, nested maps and lists included. The given map is not
+// modified.
+func RenderParameters(params map[string]interface{}, resolver PlaceholderResolver) (map[string]interface{}, error) {
+	rendered := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		v, err := renderValue(value, resolver)
+		if err != nil {
+			return nil, fmt.Errorf("parameter '%s': %w", key, err)
+		}
+		rendered[key] = v
+	}
+	return rendered, nil
+}
+
+func renderValue(value interface{}, resolver PlaceholderResolver) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return ExpandPlaceholders(v, resolver)
+	case map[string]interface{}:
+		return RenderParameters(v, resolver)
+	case map[interface{}]interface{}:
+		rendered := make(map[interface{}]interface{}, len(v))
+		for key, item := range v {
+			r, err := renderValue(item, resolver)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = r
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := renderValue(item, resolver)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	default:
+		return value, nil
+	}
+}
+
+// SecretResolver resolves ${secret:name} placeholders from the given store
+func SecretResolver(secrets *SecretStore) PlaceholderResolver {
+	return func(p Placeholder) (string, bool, error) {
+		if p.Kind != "secret" {
+			return "", false, nil
+		}
+		if secrets == nil {
+			return "", false, fmt.Errorf("secret '%s' not found", p.Name)
+		}
+		value, ok := secrets.Get(p.Name)
+		if !ok {
+			return "", false, fmt.Errorf("secret '%s' not found", p.Name)
+		}
+		return value, true, nil
+	}
+}
+
+// RedactedSecretResolver resolves the ${secret:name} placeholders with the
+// redacted text, it is used when the parameters are logged or audited
+func RedactedSecretResolver() PlaceholderResolver {
+	return func(p Placeholder) (string, bool, error) {
+		if p.Kind != "secret" {
+			return "", false, nil
+		}
+		return RedactedText, true, nil
+	}
+}
+
+// QuotingResolver returns a resolver quoting the values of the given one
+func QuotingResolver(resolver PlaceholderResolver, quote func(string) string) PlaceholderResolver {
+	return func(p Placeholder) (string, bool, error) {
+		value, ok, err := resolver(p)
+		if err != nil || !ok {
+			return value, ok, err
+		}
+		return quote(value), true, nil
+	}
+}
+
+// ChainResolvers returns a resolver trying each given resolver in order
+func ChainResolvers(resolvers ...PlaceholderResolver) PlaceholderResolver {
+	return func(p Placeholder) (string, bool, error) {
+		for _, resolver := range resolvers {
+			value, ok, err := resolver(p)
+			if err != nil || ok {
+				return value, ok, err
+			}
+		}
+		return "", false, nil
+	}
+}
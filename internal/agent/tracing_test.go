@@ -0,0 +1,75 @@
+package agent_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/a13labs/cobot/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestExecuteActionIsTraced(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	s := agent.NewMemStorage()
+	secrets, err := agent.NewSecretStore(s, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := secrets.Set("token", "very-secret-token"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := &agent.AgentCtx{Storage: s, Secrets: secrets}
+
+	msg := agent.Message{Channel: "console", User: "alice", Role: agent.RoleAdmin, Text: "restart"}
+	action := agent.Action{Name: "restart", Exec: agent.ActionExecution{Plugin: "echo", Parameters: map[string]interface{}{"command": "restart"}}}
+	if _, err := ctx.ExecuteAction(context.Background(), msg, action, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := agent.Action{Name: "missing", Exec: agent.ActionExecution{Plugin: "very-secret-token"}}
+	if _, err := ctx.ExecuteAction(context.Background(), msg, missing, nil); err == nil {
+		t.Fatal("expected an error for a missing plugin")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("recorded %d spans; want 3", len(spans))
+	}
+
+	plugin, action1, action2 := spans[0], spans[1], spans[2]
+	if plugin.Name() != "plugin.execute" || plugin.Parent().SpanID() != action1.SpanContext().SpanID() {
+		t.Errorf("plugin span = %s, parent %s", plugin.Name(), plugin.Parent().SpanID())
+	}
+	if !hasAttribute(plugin.Attributes(), tracing.AttrPlugin, "echo") {
+		t.Errorf("plugin span attributes = %v", plugin.Attributes())
+	}
+	if action1.Name() != "agent.action" || !hasAttribute(action1.Attributes(), tracing.AttrAction, "restart") ||
+		!hasAttribute(action1.Attributes(), tracing.AttrOutcome, agent.AuditSuccess) {
+		t.Errorf("action span = %s %v", action1.Name(), action1.Attributes())
+	}
+	if action2.Status().Code != codes.Error || !hasAttribute(action2.Attributes(), tracing.AttrOutcome, agent.AuditFailure) {
+		t.Errorf("failed action span status = %v, attributes %v", action2.Status(), action2.Attributes())
+	}
+	if action2.Status().Description != "plugin not found: "+agent.RedactedText {
+		t.Errorf("the span error is not redacted: %q", action2.Status().Description)
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, key string, value string) bool {
+	for _, attr := range attrs {
+		if string(attr.Key) == key && attr.Value.AsString() == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package agent
+
+/*
+	Files received from the users are saved in the storage under local/uploads,
+	one folder per channel and conversation. Actions declare the arguments filled
+	with these files in their 'files' list, the argument value is the path of the
+	file, on the local filesystem when the storage has one.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const UploadsDir = "local/uploads"
+
+// MaxUploadSize is the largest file accepted from a user
+const MaxUploadSize = 20 << 20
+
+var ErrUploadTooLarge = fmt.Errorf("file is larger than %d MB", MaxUploadSize>>20)
+
+var unsafeNameRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Attachment is a file received with a message, Path is the storage path
+type Attachment struct {
+	Name string
+	Path string
+}
+
+// FileSender is implemented by the channels able to send files, long action
+// outputs are sent as files instead of being split
+type FileSender interface {
+	SendFile(conversation string, name string, data []byte) error
+}
+
+// LocalPather is implemented by the storages backed by the local filesystem
+type LocalPather interface {
+	LocalPath(path string) string
+}
+
+func safeName(name string) string {
+	name = strings.Trim(unsafeNameRe.ReplaceAllString(filepath.Base(name), "_"), "._")
+	if name == "" {
+		return "file"
+	}
+	return name
+}
+
+// SaveUpload saves a file received with the given message
+func (ctx *AgentCtx) SaveUpload(msg Message, name string, data []byte) (Attachment, error) {
+	if len(data) > MaxUploadSize {
+		return Attachment{}, ErrUploadTooLarge
+	}
+	if ctx.Storage == nil {
+		return Attachment{}, errors.New("no storage")
+	}
+
+	dir := UploadsDir + "/" + safeName(msg.Channel) + "/" + safeName(msg.Conversation)
+	if err := EnsureDir(ctx.Storage, dir, 0700); err != nil {
+		return Attachment{}, err
+	}
+
+	name = safeName(name)
+	file := dir + "/" + time.Now().UTC().Format("20060102T150405.000000000") + "-" + name
+	if err := ctx.Storage.WriteFile(file, data, 0600); err != nil {
+		return Attachment{}, err
+	}
+	return Attachment{Name: name, Path: file}, nil
+}
+
+// AttachmentPath returns the path given to the actions for an attachment
+func (ctx *AgentCtx) AttachmentPath(attachment Attachment) string {
+	if pather, ok := ctx.Storage.(LocalPather); ok {
+		return pather.LocalPath(attachment.Path)
+	}
+	return attachment.Path
+}
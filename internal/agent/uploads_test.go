@@ -0,0 +1,32 @@
+package agent_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+func TestSaveUpload(t *testing.T) {
+	ctx := &agent.AgentCtx{Storage: agent.NewMemStorage()}
+	msg := agent.Message{Channel: "telegram", Conversation: "-100"}
+
+	attachment, err := ctx.SaveUpload(msg, "../../etc/my report.txt", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.Name != "my_report.txt" {
+		t.Errorf("unexpected name %q", attachment.Name)
+	}
+	if !strings.HasPrefix(attachment.Path, agent.UploadsDir+"/telegram/-100/") || !strings.HasSuffix(attachment.Path, "-my_report.txt") {
+		t.Errorf("unexpected path %q", attachment.Path)
+	}
+	if data, err := ctx.Storage.ReadFile(attachment.Path); err != nil || string(data) != "data" {
+		t.Errorf("unexpected content %q: %v", data, err)
+	}
+
+	if _, err := ctx.SaveUpload(msg, "big.bin", make([]byte, agent.MaxUploadSize+1)); !errors.Is(err, agent.ErrUploadTooLarge) {
+		t.Errorf("expected ErrUploadTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,469 @@
+package agent
+
+/*
+	The validator checks the agent configuration and every action file found in
+	the storage, reporting problems with the file and line where they were found:
+	- unknown fields and values of the wrong type
+	- missing names and descriptions
+	- plugins that are not registered
+	- placeholders not satisfied by the declared args or by the knowledge base,
+	  or written inside quotes for a plugin quoting their values
+	- duplicated action names
+	- users and roles
+
+	The action files the agent does not load, because they are not listed or are
+	excluded from discovery, are checked as well and reported with a warning.
+
+	The files are decoded and the actions selected by the same code the agent
+	loads them with, the YAML nodes are only used to locate the problems.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/a13labs/cobot/internal/algo"
+	goyaml "github.com/go-yaml/yaml"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationIssue is a problem found by the validator, Line is 0 when the
+// problem is not related to a specific line. A warning does not prevent the
+// agent from working
+type ValidationIssue struct {
+	File    string
+	Line    int
+	Message string
+	Warning bool
+}
+
+func (i ValidationIssue) String() string {
+	message := i.Message
+	if i.Warning {
+		message = "warning: " + message
+	}
+	if i.Line == 0 {
+		return fmt.Sprintf("%s: %s", i.File, message)
+	}
+	return fmt.Sprintf("%s:%d: %s", i.File, i.Line, message)
+}
+
+type validator struct {
+	storage Storage
+	issues  []ValidationIssue
+}
+
+func (v *validator) report(file string, line int, format string, args ...interface{}) {
+	v.issues = append(v.issues, ValidationIssue{File: file, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warn(file string, line int, format string, args ...interface{}) {
+	v.issues = append(v.issues, ValidationIssue{File: file, Line: line, Message: fmt.Sprintf(format, args...), Warning: true})
+}
+
+// ValidateStorage validates the agent configuration and all the action files
+// found in the given storage. An empty result means no problems were found.
+func ValidateStorage(storage Storage) []ValidationIssue {
+
+	v := &validator{storage: storage}
+	names := map[string]ValidationIssue{}
+
+	cfg, inline, _ := v.validateConfig("agent-config.yaml")
+
+	sources, _, err := actionSources(cfg, storage)
+	if err != nil {
+		v.report("actions", 0, "actions folder not found")
+	}
+	loaded := map[string]bool{}
+	for _, source := range sources {
+		if source.inline != nil {
+			v.validateActionNode(source.file, inline[source.inline], *source.inline, "", cfg.KnowledgeBase, names)
+			continue
+		}
+		loaded[source.file] = true
+		v.validateAction(source.file, source.name, cfg.KnowledgeBase, names)
+	}
+
+	// The files not loaded are checked on their own, their names can't clash
+	// with the loaded actions
+	files, _ := WalkFiles(storage, "actions")
+	for _, file := range files {
+		if path.Ext(file) != ".yaml" || loaded[file] {
+			continue
+		}
+		v.warn(file, 0, "file is not loaded, it is not listed in the agent configuration nor discovered")
+		v.validateAction(file, strings.TrimSuffix(path.Base(file), ".yaml"), cfg.KnowledgeBase, map[string]ValidationIssue{})
+	}
+
+	return v.issues
+}
+
+// parse decodes a file with the given decoder of the agent and returns the
+// root node locating its fields
+func (v *validator) parse(file string, t reflect.Type, decode func(data []byte) error) (*yaml.Node, bool) {
+	data, err := v.storage.ReadFile(file)
+	if err != nil {
+		v.report(file, 0, "unable to read file")
+		return nil, false
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		v.report(file, 0, "file is empty")
+		return nil, false
+	}
+
+	if err := decode(data); err != nil {
+		v.reportYAMLError(file, err)
+		return nil, false
+	}
+
+	// The problems of a file the nodes can't be built for are not located
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode}, true
+	}
+	root := doc.Content[0]
+
+	v.checkFields(file, root, t, "")
+	return root, true
+}
+
+var yamlLineRe = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+func (v *validator) reportYAMLError(file string, err error) {
+	messages := []string{err.Error()}
+	if typeErr, ok := err.(*goyaml.TypeError); ok {
+		messages = typeErr.Errors
+	}
+	for _, msg := range messages {
+		if m := yamlLineRe.FindStringSubmatch(msg); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			v.report(file, line, "%s", m[2])
+		} else {
+			v.report(file, 0, "%s", msg)
+		}
+	}
+}
+
+// checkFields reports the mapping keys that have no matching field in the given type
+func (v *validator) checkFields(file string, node *yaml.Node, t reflect.Type, context string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// Actions list entries are either a name or an inline action
+	if t == reflect.TypeOf(ActionRef{}) {
+		t = reflect.TypeOf(Action{})
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				if context == "" {
+					v.report(file, key.Line, "unknown field '%s'", key.Value)
+				} else {
+					v.report(file, key.Line, "unknown field '%s' in '%s'", key.Value, context)
+				}
+				continue
+			}
+			v.checkFields(file, value, fieldType, strings.TrimPrefix(context+"."+key.Value, "."))
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for _, item := range node.Content {
+			v.checkFields(file, item, t.Elem(), context)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.checkFields(file, node.Content[i+1], t.Elem(), context+"."+node.Content[i].Value)
+		}
+	}
+}
+
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// validateConfig checks the agent configuration, the nodes of the inline
+// actions are returned for them to be checked with the other actions
+func (v *validator) validateConfig(file string) (AgentConfigFile, map[*Action]*yaml.Node, bool) {
+	var cfg AgentConfigFile
+	inline := map[*Action]*yaml.Node{}
+
+	if _, err := v.storage.Stat(file); err != nil {
+		v.report(file, 0, "agent configuration file not found")
+		return cfg, inline, false
+	}
+
+	root, ok := v.parse(file, reflect.TypeOf(cfg), func(data []byte) (err error) {
+		cfg, err = parseAgentConfig(data)
+		return err
+	})
+	if !ok {
+		return cfg, inline, false
+	}
+
+	agentNode := mappingValue(root, "agent")
+	if agentNode == nil {
+		v.report(file, root.Line, "missing 'agent' section")
+	} else if cfg.Agent.Name == "" {
+		v.report(file, agentNode.Line, "agent name is empty")
+	}
+
+	if usersNode := mappingValue(root, "users"); usersNode != nil && len(usersNode.Content) == len(cfg.Users) {
+		v.validateUsers(file, usersNode, cfg.Users)
+	}
+
+	actionsNode := mappingValue(root, "actions")
+	if actionsNode == nil || len(actionsNode.Content) != len(cfg.Actions) {
+		for _, ref := range cfg.Actions {
+			if ref.Inline != nil {
+				inline[ref.Inline] = root
+			}
+		}
+		return cfg, inline, true
+	}
+
+	listed := map[string]bool{}
+	for i, item := range actionsNode.Content {
+		ref := cfg.Actions[i]
+		if ref.Inline != nil {
+			inline[ref.Inline] = item
+			continue
+		}
+		if listed[ref.Name] {
+			v.report(file, item.Line, "action '%s' is listed more than once", ref.Name)
+		}
+		listed[ref.Name] = true
+		if _, err := v.storage.Stat("actions/" + ref.Name + ".yaml"); err != nil {
+			v.report(file, item.Line, "action '%s' is listed but actions/%s.yaml does not exist", ref.Name, ref.Name)
+		}
+	}
+
+	return cfg, inline, true
+}
+
+func (v *validator) validateUsers(file string, node *yaml.Node, users []UserDef) {
+	names := map[string]bool{}
+	telegramIds := map[int64]string{}
+
+	for i, item := range node.Content {
+		user := users[i]
+		if user.Name == "" {
+			v.report(file, item.Line, "user name is empty")
+		} else if names[user.Name] {
+			v.report(file, item.Line, "user '%s' is declared more than once", user.Name)
+		}
+		names[user.Name] = true
+
+		if !ValidRole(user.Role) {
+			line := item.Line
+			if roleNode := mappingValue(item, "role"); roleNode != nil {
+				line = roleNode.Line
+			}
+			v.report(file, line, "user '%s' has an invalid role '%s', valid roles: %s, %s, %s", user.Name, user.Role, RoleViewer, RoleOperator, RoleAdmin)
+		}
+
+		if user.Telegram.Id == 0 && user.Telegram.Username == "" {
+			v.report(file, item.Line, "user '%s' has no identity on any channel", user.Name)
+		}
+		if id := user.Telegram.Id; id != 0 {
+			if previous, exist := telegramIds[id]; exist {
+				v.report(file, item.Line, "telegram id %d is used by users '%s' and '%s'", id, previous, user.Name)
+			}
+			telegramIds[id] = user.Name
+		}
+	}
+}
+
+// validateAction checks an action file, name is the action name the file
+// must have
+func (v *validator) validateAction(file string, name string, kb map[string]interface{}, names map[string]ValidationIssue) {
+	var action Action
+
+	root, ok := v.parse(file, reflect.TypeOf(action), func(data []byte) (err error) {
+		action, err = parseAction(data)
+		return err
+	})
+	if !ok {
+		return
+	}
+
+	v.validateActionNode(file, root, action, name, kb, names)
+}
+
+// validateActionNode checks an action definition, expectedName is the name the
+// action must have, empty when any name is accepted
+func (v *validator) validateActionNode(file string, root *yaml.Node, action Action, expectedName string, kb map[string]interface{}, names map[string]ValidationIssue) {
+
+	nameLine := root.Line
+	if node := mappingValue(root, "name"); node != nil {
+		nameLine = node.Line
+	}
+
+	switch {
+	case action.Name == "":
+		v.report(file, nameLine, "action name is empty")
+	case expectedName != "" && action.Name != expectedName:
+		v.report(file, nameLine, "action name '%s' does not match the file name '%s'", action.Name, expectedName)
+	}
+
+	if action.Name != "" && action.IsEnabled() {
+		if previous, exist := names[action.Name]; exist {
+			v.report(file, nameLine, "duplicated action name '%s', already defined in %s:%d", action.Name, previous.File, previous.Line)
+		} else {
+			names[action.Name] = ValidationIssue{File: file, Line: nameLine}
+		}
+	}
+
+	if strings.TrimSpace(action.Description) == "" {
+		line := root.Line
+		if node := mappingValue(root, "description"); node != nil {
+			line = node.Line
+		}
+		v.report(file, line, "action description is empty")
+	}
+
+	execNode := mappingValue(root, "exec")
+	pluginLine := root.Line
+	if execNode != nil {
+		pluginLine = execNode.Line
+		if node := mappingValue(execNode, "plugin"); node != nil {
+			pluginLine = node.Line
+		}
+	}
+	if action.Role != "" && !ValidRole(action.Role) {
+		line := root.Line
+		if node := mappingValue(root, "role"); node != nil {
+			line = node.Line
+		}
+		v.report(file, line, "invalid role '%s', valid roles: %s, %s, %s", action.Role, RoleViewer, RoleOperator, RoleAdmin)
+	}
+
+	quotes := false
+	if action.Exec.Plugin == "" {
+		v.report(file, pluginLine, "no plugin defined")
+	} else if plugin, ok := GetPlugin(action.Exec.Plugin); !ok {
+		v.report(file, pluginLine, "plugin '%s' is not registered, available plugins: %s", action.Exec.Plugin, strings.Join(PluginNames(), ", "))
+	} else {
+		_, quotes = plugin.(ArgumentQuoter)
+	}
+
+	if paramsNode := mappingValue(execNode, "parameters"); paramsNode != nil {
+		args := append(algo.StringList{}, action.Args...)
+		v.checkPlaceholders(file, paramsNode, append(args, action.Files...), kb, quotes)
+	}
+}
+
+// checkPlaceholders checks the placeholders of the parameters, quotes tells
+// whether the plugin quotes their values itself
+func (v *validator) checkPlaceholders(file string, node *yaml.Node, args algo.StringList, kb map[string]interface{}, quotes bool) {
+	if node.Kind == yaml.ScalarNode {
+		for _, p := range FindPlaceholders(node.Value) {
+			if msg := checkPlaceholder(p, args, kb); msg != "" {
+				v.report(file, node.Line, "placeholder %s %s", p.String(), msg)
+			}
+		}
+		if quotes {
+			for _, p := range QuotedPlaceholders(node.Value) {
+				v.report(file, node.Line, "placeholder %s is inside quotes, the plugin quotes its value", p.String())
+			}
+		}
+		return
+	}
+	for _, child := range node.Content {
+		v.checkPlaceholders(file, child, args, kb, quotes)
+	}
+}
+
+// checkPlaceholder returns why a placeholder can't be satisfied, or an empty string
+func checkPlaceholder(p Placeholder, args algo.StringList, kb map[string]interface{}) string {
+	segments := strings.Split(p.Name, ".")
+
+	switch p.Kind {
+	case "":
+		if !args.Contains(segments[0]) {
+			return fmt.Sprintf("does not match any declared argument (args: %s)", strings.Join(args, ", "))
+		}
+	case "kb":
+		// An absolute path in the knowledge base
+		if _, ok := KnowledgeBaseLookup(kb, segments); ok {
+			return ""
+		}
+		// A path relative to the entity selected by an argument
+		if !args.Contains(segments[0]) {
+			return "does not match any declared argument nor knowledge base path"
+		}
+		collection, ok := KnowledgeBaseLookup(kb, segments[:1])
+		if !ok {
+			return fmt.Sprintf("refers to '%s' which is not in the knowledge base", segments[0])
+		}
+		if len(segments) > 1 && !anyEntryHasPath(collection, segments[1:]) {
+			return fmt.Sprintf("no knowledge base entry under '%s' has '%s'", segments[0], strings.Join(segments[1:], "."))
+		}
+	case "secret":
+		if !secretNameRe.MatchString(p.Name) {
+			return "has an invalid secret name"
+		}
+	default:
+		return fmt.Sprintf("has an unknown kind '%s'", p.Kind)
+	}
+	return ""
+}
+
+func anyEntryHasPath(collection interface{}, segments []string) bool {
+	entries, ok := kbEntries(collection)
+	if !ok {
+		return false
+	}
+	if len(entries) == 0 {
+		// Nothing defined yet, anything is accepted
+		return true
+	}
+	for _, entry := range entries {
+		if _, ok := KnowledgeBaseLookup(entry, segments); ok {
+			return true
+		}
+	}
+	return false
+}
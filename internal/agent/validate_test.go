@@ -0,0 +1,236 @@
+package agent_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+type echoPlugin struct{}
+
+func (p *echoPlugin) Execute(ctx context.Context, params map[string]interface{}) (string, error) {
+	command, _ := params["command"].(string)
+	return command, nil
+}
+
+func init() {
+	agent.RegisterPlugin("echo", &echoPlugin{})
+}
+
+func TestValidateStorage(t *testing.T) {
+	s := agent.NewMemStorageFromMap(map[string]string{
+		"agent-config.yaml": `agent:
+  name: tester
+  colour: blue
+actions:
+  - good
+  - good
+  - missing
+discovery:
+  enabled: true
+knowledge_base:
+  computer:
+    fedora:
+      mac: 00:11:22:33:44:55
+`,
+		"actions/good.yaml": `description: wake up a computer
+name: good
+args:
+  - computer
+exec:
+  plugin: echo
+  parameters:
+    command: wakeonlan ${kb:computer.mac} ${secret:token}
+`,
+		"actions/broken.yaml": `name: broken
+args:
+  - service
+exec:
+  plugin: missing
+  parameters:
+  command: restart ${service.name} ${host} ${kb:computer.ip}
+`,
+		"actions/copy.yaml": `description: a copy
+name: good
+exec:
+  plugin: echo
+`,
+	})
+
+	issues := agent.ValidateStorage(s)
+
+	expected := []string{
+		"agent-config.yaml:3: unknown field 'colour' in 'agent'",
+		"agent-config.yaml:6: action 'good' is listed more than once",
+		"agent-config.yaml:7: action 'missing' is listed but actions/missing.yaml does not exist",
+		"actions/broken.yaml:1: action description is empty",
+		"actions/broken.yaml:5: plugin 'missing' is not registered",
+		"actions/broken.yaml:7: unknown field 'command' in 'exec'",
+		"actions/copy.yaml:2: action name 'good' does not match the file name 'copy'",
+		"actions/copy.yaml:2: duplicated action name 'good', already defined in actions/good.yaml:2",
+	}
+
+	var got []string
+	for _, issue := range issues {
+		got = append(got, issue.String())
+	}
+	all := strings.Join(got, "\n")
+
+	for _, e := range expected {
+		if !strings.Contains(all, e) {
+			t.Errorf("expected issue %q, got:\n%s", e, all)
+		}
+	}
+	for _, issue := range got {
+		if strings.Contains(issue, "good.yaml") && strings.Contains(issue, "placeholder") {
+			t.Errorf("unexpected placeholder issue: %s", issue)
+		}
+	}
+}
+
+func TestValidatePlaceholders(t *testing.T) {
+	s := agent.NewMemStorageFromMap(map[string]string{
+		"agent-config.yaml": `agent:
+  name: tester
+discovery:
+  enabled: true
+knowledge_base:
+  computer:
+    fedora:
+      mac: 00:11:22:33:44:55
+`,
+		"actions/act.yaml": `description: test placeholders
+name: act
+args:
+  - computer
+files:
+  - script
+exec:
+  plugin: echo
+  parameters:
+    command: run ${computer.name} ${script}
+    other: ${service.name} ${kb:computer.ip} ${kb:printer.ip} ${env:HOME}
+`,
+	})
+
+	var got []string
+	for _, issue := range agent.ValidateStorage(s) {
+		got = append(got, issue.String())
+	}
+	all := strings.Join(got, "\n")
+
+	for _, e := range []string{
+		"actions/act.yaml:11: placeholder ${service.name} does not match any declared argument",
+		"actions/act.yaml:11: placeholder ${kb:computer.ip} no knowledge base entry under 'computer' has 'ip'",
+		"actions/act.yaml:11: placeholder ${kb:printer.ip} does not match any declared argument nor knowledge base path",
+		"actions/act.yaml:11: placeholder ${env:HOME} has an unknown kind 'env'",
+	} {
+		if !strings.Contains(all, e) {
+			t.Errorf("expected issue %q, got:\n%s", e, all)
+		}
+	}
+	if len(got) != 4 {
+		t.Errorf("expected 4 issues, got:\n%s", all)
+	}
+}
+
+func TestValidateQuotedPlaceholders(t *testing.T) {
+	s := agent.NewMemStorageFromMap(map[string]string{
+		"agent-config.yaml": `agent:
+  name: tester
+discovery:
+  enabled: true
+`,
+		"actions/act.yaml": `description: test quoted placeholders
+name: act
+args:
+  - msg
+exec:
+  plugin: quoting
+  parameters:
+    command: echo ${msg} "${msg}" '${msg}' \"${msg}\"
+`,
+	})
+
+	var got []string
+	for _, issue := range agent.ValidateStorage(s) {
+		got = append(got, issue.String())
+	}
+	all := strings.Join(got, "\n")
+	if want := "actions/act.yaml:8: placeholder ${msg} is inside quotes, the plugin quotes its value"; !strings.Contains(all, want) {
+		t.Errorf("expected issue %q, got:\n%s", want, all)
+	}
+	if len(got) != 2 {
+		t.Errorf("expected 2 issues, got:\n%s", all)
+	}
+}
+
+func TestValidateFollowsDiscovery(t *testing.T) {
+	action := `description: an action
+name: act
+exec:
+  plugin: echo
+`
+	s := agent.NewMemStorageFromMap(map[string]string{
+		"agent-config.yaml": `agent:
+  name: tester
+discovery:
+  enabled: true
+  exclude:
+    - old/*
+`,
+		"actions/act.yaml":     action,
+		"actions/old/act.yaml": action,
+		"actions/new/act.yaml": action + "enabled: false\n",
+	})
+
+	issues := agent.ValidateStorage(s)
+	if len(issues) != 1 || !issues[0].Warning || issues[0].File != "actions/old/act.yaml" {
+		t.Errorf("ValidateStorage() = %v; want only a warning for the excluded action", issues)
+	}
+}
+
+func TestValidateUnlistedFiles(t *testing.T) {
+	s := agent.NewMemStorageFromMap(map[string]string{
+		"agent-config.yaml": `agent:
+  name: tester
+actions:
+  - good
+`,
+		"actions/good.yaml": `description: an action
+name: good
+exec:
+  plugin: echo
+`,
+		"actions/foo.yaml": `name: foo
+colour: blue
+exec:
+  plugin: missing
+  parameters:
+    command: run ${x}
+`,
+	})
+
+	var got []string
+	for _, issue := range agent.ValidateStorage(s) {
+		got = append(got, issue.String())
+		if issue.File == "actions/good.yaml" {
+			t.Errorf("unexpected issue for a listed action: %s", issue)
+		}
+	}
+	all := strings.Join(got, "\n")
+
+	for _, e := range []string{
+		"actions/foo.yaml: warning: file is not loaded",
+		"actions/foo.yaml:2: unknown field 'colour'",
+		"actions/foo.yaml:1: action description is empty",
+		"actions/foo.yaml:4: plugin 'missing' is not registered",
+		"actions/foo.yaml:6: placeholder ${x} does not match any declared argument",
+	} {
+		if !strings.Contains(all, e) {
+			t.Errorf("expected issue %q, got:\n%s", e, all)
+		}
+	}
+}
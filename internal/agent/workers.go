@@ -0,0 +1,286 @@
+package agent
+
+/*
+	The messages are processed by a pool of workers. The messages of a session,
+	a conversation of a channel, are processed one at a time in the order they
+	were received, while the sessions are processed in parallel, up to
+	MaxConcurrency messages at once.
+
+	A user saying "stop" or "cancel" cancels their request being processed in
+	the session, its LLM requests, prompts and action included, and drops
+	their requests waiting in the session.
+
+	A message waiting for an answer of its sender gives its worker back until
+	the answer arrives, the other sessions are not held up by the open prompts.
+
+	A message is processed with the configuration of when it was taken by a
+	worker, a reload does not wait for the messages being processed.
+*/
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/a13labs/cobot/internal/metrics"
+)
+
+// ErrRequestCancelled is the error of the requests cancelled by their sender
+var ErrRequestCancelled = errors.New("request cancelled by the user")
+
+// The messages cancelling the requests of their sender
+var cancelRequests = []string{"stop", "cancel", "/stop", "/cancel"}
+
+// IsCancelRequest returns true if the given input cancels the requests of its
+// sender
+func IsCancelRequest(text string) bool {
+	text = strings.ToLower(strings.TrimRight(strings.TrimSpace(text), ".!"))
+	for _, request := range cancelRequests {
+		if text == request {
+			return true
+		}
+	}
+	return false
+}
+
+// session holds the messages of a conversation waiting to be processed
+type session struct {
+	queue []Message
+	// user and cancel of the message being processed, cancel is nil when
+	// no message is processed
+	user   string
+	cancel context.CancelCauseFunc
+}
+
+func sessionKey(msg Message) string {
+	return promptKey(msg.Channel, msg.Conversation)
+}
+
+// cancellation returns why the request of a message was cancelled, nil when
+// it was not
+func cancellation(msg Message) error {
+	if msg.Context().Err() == nil {
+		return nil
+	}
+	return context.Cause(msg.Context())
+}
+
+// processInput queues the messages in their session until the agent stops,
+// it returns once the sessions are done
+func (ctx *AgentCtx) processInput() {
+
+	defer ctx.sessionsWg.Wait()
+
+	for {
+		// A stopping agent takes no new message
+		select {
+		case <-ctx.stopping:
+			return
+		default:
+		}
+
+		select {
+		case msg, ok := <-ctx.InputChannel:
+			if !ok {
+				return
+			}
+			if IsCancelRequest(msg.Text) {
+				ctx.cancelRequests(msg)
+				ctx.complete(msg, nil)
+				continue
+			}
+			ctx.enqueue(msg)
+		case result := <-ctx.reloads:
+			ctx.sessionsWg.Add(1)
+			go func() {
+				defer ctx.sessionsWg.Done()
+				result <- ctx.Reload()
+			}()
+		case <-ctx.stopping:
+			return
+		}
+	}
+
+}
+
+// enqueue adds a message to its session, the session is processed until it
+// has no message left
+func (ctx *AgentCtx) enqueue(msg Message) {
+
+	metrics.QueueDepth.WithLabelValues("agent").Inc()
+
+	ctx.sessionsMu.Lock()
+	defer ctx.sessionsMu.Unlock()
+
+	if ctx.sessions == nil {
+		ctx.sessions = make(map[string]*session)
+	}
+	key := sessionKey(msg)
+	s, exist := ctx.sessions[key]
+	if !exist {
+		s = &session{}
+		ctx.sessions[key] = s
+		ctx.sessionsWg.Add(1)
+		go ctx.runSession(key, s)
+	}
+	s.queue = append(s.queue, msg)
+}
+
+// runSession processes the messages of a session in order, the messages left
+// once the agent stops are refused
+func (ctx *AgentCtx) runSession(key string, s *session) {
+
+	defer ctx.sessionsWg.Done()
+
+	queue := metrics.QueueDepth.WithLabelValues("agent")
+
+	for {
+		ctx.sessionsMu.Lock()
+		if len(s.queue) == 0 {
+			delete(ctx.sessions, key)
+			ctx.sessionsMu.Unlock()
+			return
+		}
+		msg := s.queue[0]
+		s.queue = s.queue[1:]
+		queue.Dec()
+
+		select {
+		case <-ctx.stopping:
+			ctx.sessionsMu.Unlock()
+			ctx.complete(msg, ErrAgentStopped)
+			continue
+		default:
+		}
+
+		var cancel context.CancelCauseFunc
+		msg.ctx, cancel = context.WithCancelCause(ctx.runCtx)
+		s.user, s.cancel = msg.User, cancel
+		ctx.sessionsMu.Unlock()
+
+		err := ctx.runMessage(msg)
+
+		ctx.sessionsMu.Lock()
+		s.user, s.cancel = "", nil
+		ctx.sessionsMu.Unlock()
+		cancel(nil)
+
+		ctx.complete(msg, err)
+	}
+}
+
+// runMessage processes a message once a worker is free
+func (ctx *AgentCtx) runMessage(msg Message) error {
+
+	msg.worker = &worker{pool: ctx.workers}
+	if err := msg.worker.acquire(msg.Context()); err != nil {
+		return ctx.failRequest(msg, ErrInternal, err)
+	}
+	defer msg.worker.release()
+
+	ctx.configMu.RLock()
+	msg.config = &messageConfig{agent: ctx.AgentCfg, actions: ctx.ActionDB}
+	ctx.configMu.RUnlock()
+
+	return ctx.handleMessage(msg)
+}
+
+// worker is the worker slot of a message being processed
+type worker struct {
+	pool chan struct{}
+	held bool
+}
+
+// acquire waits for a free worker, a nil worker is always acquired
+func (w *worker) acquire(waitCtx context.Context) error {
+	if w == nil || w.held {
+		return nil
+	}
+	select {
+	case w.pool <- struct{}{}:
+		w.held = true
+		return nil
+	case <-waitCtx.Done():
+		return waitCtx.Err()
+	}
+}
+
+// release gives the worker back to the pool
+func (w *worker) release() {
+	if w != nil && w.held {
+		<-w.pool
+		w.held = false
+	}
+}
+
+// messageConfig is the configuration a message is processed with
+type messageConfig struct {
+	agent   AgentConfigFile
+	actions *ActionDB
+}
+
+// configOf returns the configuration the message is processed with, the
+// current one for a message not taken by a worker
+func (ctx *AgentCtx) configOf(msg Message) AgentConfigFile {
+	if msg.config != nil {
+		return msg.config.agent
+	}
+	return ctx.Config()
+}
+
+// actionsOf returns the actions the message is processed with, the current
+// ones for a message not taken by a worker
+func (ctx *AgentCtx) actionsOf(msg Message) *ActionDB {
+	if msg.config != nil {
+		return msg.config.actions
+	}
+	return ctx.Actions()
+}
+
+// cancelRequests cancels the request of the sender of a message being
+// processed in its session and drops the ones waiting
+func (ctx *AgentCtx) cancelRequests(msg Message) {
+
+	logger := GetLogger().ForMessage(msg)
+
+	cancelled := false
+	var dropped []Message
+
+	ctx.sessionsMu.Lock()
+	if s, exist := ctx.sessions[sessionKey(msg)]; exist {
+		if s.cancel != nil && s.user == msg.User {
+			s.cancel(ErrRequestCancelled)
+			cancelled = true
+		}
+		kept := s.queue[:0]
+		for _, queued := range s.queue {
+			if queued.User == msg.User {
+				dropped = append(dropped, queued)
+			} else {
+				kept = append(kept, queued)
+			}
+		}
+		s.queue = kept
+	}
+	ctx.sessionsMu.Unlock()
+
+	if !cancelled && len(dropped) == 0 {
+		ctx.Reply(msg, "You have no request being processed.")
+		return
+	}
+
+	logger.Info("Request cancelled by the user, %d waiting requests dropped", len(dropped))
+	ctx.Reply(msg, "Your request was cancelled.")
+	for _, queued := range dropped {
+		metrics.QueueDepth.WithLabelValues("agent").Dec()
+		ctx.complete(queued, ErrRequestCancelled)
+	}
+}
+
+// complete sends the result of a message once its replies are delivered
+func (ctx *AgentCtx) complete(msg Message, err error) {
+	if msg.Result != nil {
+		ctx.flushOutput()
+		msg.Result <- err
+	}
+}
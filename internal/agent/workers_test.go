@@ -0,0 +1,251 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/a13labs/cobot/internal/nlp"
+)
+
+func TestIsCancelRequest(t *testing.T) {
+	tests := map[string]bool{
+		"stop":       true,
+		" Cancel! ":  true,
+		"/cancel":    true,
+		"/STOP":      true,
+		"stop nginx": false,
+		"":           false,
+	}
+	for text, want := range tests {
+		if got := agent.IsCancelRequest(text); got != want {
+			t.Errorf("IsCancelRequest(%q) = %v; want %v", text, got, want)
+		}
+	}
+}
+
+func TestSessionsAreProcessedInParallelAndInOrder(t *testing.T) {
+	release := make(chan struct{})
+	ctx, channel := startAgent(t, llmServer(t, "true", release))
+	defer ctx.Shutdown(context.Background())
+
+	send := func(conversation string, text string) chan error {
+		result := make(chan error, 1)
+		go func() {
+			result <- ctx.ProcessMessage(agent.Message{Channel: "console", Conversation: conversation, User: "alice", Text: text})
+		}()
+		return result
+	}
+
+	// The first request waits for the LLM server
+	first := send("kitchen", "what time is it?")
+	time.Sleep(50 * time.Millisecond)
+	second := send("kitchen", "what day is it?")
+
+	select {
+	case err := <-send("garden", "what time is it?"):
+		if !errors.Is(err, agent.ErrNoActionFound) {
+			t.Errorf("ProcessMessage() error = %v in another session; want ErrNoActionFound", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a session is blocked by the request of another session")
+	}
+
+	select {
+	case err := <-second:
+		t.Fatalf("second message processed before the first one, error = %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-first; !errors.Is(err, agent.ErrNoActionFound) {
+		t.Errorf("first ProcessMessage() error = %v; want ErrNoActionFound", err)
+	}
+	if err := <-second; !errors.Is(err, agent.ErrNoActionFound) {
+		t.Errorf("second ProcessMessage() error = %v; want ErrNoActionFound", err)
+	}
+	if sent := channel.sentTo("kitchen"); len(sent) != 2 {
+		t.Errorf("replies = %q; want one reply per message", sent)
+	}
+}
+
+func TestUserCancelsTheirRequest(t *testing.T) {
+	ctx, channel := startAgent(t, llmServer(t, "true", make(chan struct{})))
+	defer ctx.Shutdown(context.Background())
+
+	message := func(user string, text string) agent.Message {
+		return agent.Message{Channel: "console", Conversation: "console", User: user, Text: text}
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- ctx.ProcessMessage(message("alice", "reboot"))
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// Another user cannot cancel the request
+	if err := ctx.ProcessMessage(message("bob", "stop")); err != nil {
+		t.Fatalf("ProcessMessage(stop) error = %v", err)
+	}
+	if err := ctx.ProcessMessage(message("alice", "Stop!")); err != nil {
+		t.Fatalf("ProcessMessage(stop) error = %v", err)
+	}
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, agent.ErrRequestCancelled) {
+			t.Errorf("ProcessMessage() error = %v; want the request cancelled by the user", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request was not cancelled")
+	}
+
+	want := []string{"You have no request being processed.", "Your request was cancelled."}
+	sent := channel.sentTo("console")
+	if len(sent) != len(want) {
+		t.Fatalf("replies = %q; want %q", sent, want)
+	}
+	for i := range want {
+		if sent[i] != want[i] {
+			t.Errorf("reply %d = %q; want %q", i, sent[i], want[i])
+		}
+	}
+}
+
+func TestConfigIsReadWhileReloading(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	ctx, _ := startAgent(t, llmServer(t, "true", release))
+	defer ctx.Shutdown(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			if err := ctx.RequestReload(); err != nil {
+				t.Errorf("RequestReload() error = %v", err)
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if name := ctx.GetAgentName(); name != "default" {
+				t.Errorf("GetAgentName() = %q; want default", name)
+			}
+			if ctx.Actions() == nil {
+				t.Error("Actions() = nil once reloaded")
+			}
+			return
+		default:
+			ctx.GetAgentName()
+			ctx.Actions()
+		}
+	}
+}
+
+// actionLLMServer answers the agent requests so that any message selects the
+// first action
+func actionLLMServer(t *testing.T) *nlp.LLMClient {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		result := `"ok"`
+		switch {
+		case strings.Contains(string(body), "if it is a question"):
+			result = "false"
+		case strings.Contains(string(body), "true or false"):
+			result = "true"
+		case strings.Contains(string(body), "Write the IDs"):
+			result = "[0]"
+		}
+		fmt.Fprintf(w, `{"message":{"role":"assistant","content":%q}}`, `{"result":`+result+`}`)
+	}))
+	t.Cleanup(server.Close)
+
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	portNumber, _ := strconv.Atoi(port)
+	return &nlp.LLMClient{Host: host, Port: portNumber, Model: "mistral"}
+}
+
+func TestPendingPromptHoldsNeitherTheWorkerNorTheReload(t *testing.T) {
+	ctx := &agent.AgentCtx{
+		Storage: agent.NewMemStorageFromMap(map[string]string{
+			"agent-config.yaml": `agent:
+  name: tester
+actions:
+  - name: reboot
+    description: reboot the host
+    confirm: true
+    exec:
+      plugin: echo
+      parameters:
+        command: rebooting
+`,
+		}),
+		LLMClient: actionLLMServer(t),
+		UserArgs:  agent.AgentStartArgs{MaxConcurrency: 1},
+	}
+	channel := &promptChannel{fakeChannel: fakeChannel{name: "ws"}, prompts: make(chan agent.Prompt, 1)}
+	if err := ctx.AddChannel(channel); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Shutdown(context.Background())
+
+	result := make(chan error, 1)
+	go func() {
+		result <- ctx.ProcessMessage(agent.Message{Channel: "ws", Conversation: "kitchen", User: "alice", Role: agent.RoleAdmin, Text: "reboot"})
+	}()
+	var prompt agent.Prompt
+	select {
+	case prompt = <-channel.prompts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the action was not confirmed")
+	}
+
+	within := func(what string, f func() error) {
+		t.Helper()
+		done := make(chan error, 1)
+		go func() { done <- f() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("%s error = %v", what, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s is blocked by the pending prompt", what)
+		}
+	}
+	within("RequestReload()", ctx.RequestReload)
+	within("ProcessMessage() in another session", func() error {
+		return ctx.ProcessMessage(agent.Message{Channel: "ws", Conversation: "garden", User: "bob", Role: agent.RoleViewer, Text: "/actions"})
+	})
+
+	if err := ctx.Answer("ws", "kitchen", "alice", prompt.Id, "yes"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("ProcessMessage() error = %v once confirmed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the confirmed action did not run")
+	}
+}
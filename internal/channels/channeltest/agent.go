@@ -0,0 +1,38 @@
+// Package channeltest holds the helpers shared by the tests of the channels
+package channeltest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+// FakeAgent processes the messages of the agent until its input channel is
+// closed. The messages found in replies are answered with their reply, the
+// messages of the users without the admin role are refused, "fail" makes the
+// action fail and any other message is run once confirmed.
+func FakeAgent(agentCtx *agent.AgentCtx, replies map[string]string) {
+	for msg := range agentCtx.InputChannel {
+		var err error
+		reply, isCommand := replies[msg.Text]
+		switch {
+		case msg.Role != agent.RoleAdmin:
+			err = agent.ErrNotAuthorized
+		case isCommand:
+			agentCtx.Deliver(agent.Reply{Channel: msg.Channel, Conversation: msg.Conversation, Text: reply})
+		case strings.HasPrefix(msg.Text, "fail"):
+			err = fmt.Errorf("%w: exit status 3", agent.ErrActionFailed)
+		default:
+			confirmed, askErr := agentCtx.Confirm(msg, "Run "+msg.Text+"?")
+			if askErr != nil || !confirmed {
+				err = agent.ErrActionCancelled
+			} else {
+				agentCtx.Deliver(agent.Reply{Channel: msg.Channel, Conversation: msg.Conversation, Text: "ran " + msg.Text})
+			}
+		}
+		if msg.Result != nil {
+			msg.Result <- err
+		}
+	}
+}
@@ -1,47 +1,526 @@
 package consoleChannel
 
+/*
+	The console channel runs in one of three modes:
+	- interactive: a REPL with line editing and a persistent history, used when
+	  the console is a terminal
+	- script: the lines read from the input are run in order, used when the
+	  input is piped
+	- execute: a single message is run, the input is only read to answer the
+	  questions of the agent
+
+	The messages are handled one at a time, the replies of a message are shown
+	before the next one is read and the questions of the agent are answered
+	with the next line. The interactive console keeps reading while a message
+	is handled, "stop", "cancel" or Ctrl-C cancel it. Besides the agent chat
+	commands the console handles /history and /quit itself.
+*/
+
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/a13labs/cobot/internal/agent"
+	"github.com/chzyer/readline"
 )
 
-// ForEachInput calls the given callback function for each line of input.
-func forEachInput(r io.Reader, callback func(text string) error) error {
-	scanner := bufio.NewScanner(r)
+const ChannelName = "console"
+
+// The console has a single conversation
+const conversation = "console"
+
+// The history is kept in the storage when it has a local path
+const historyDir = "local/console"
+const historyFile = historyDir + "/history"
+
+const historyLimit = 1000
+const defaultHistoryLines = 20
+
+const consoleCommandsHelp = `/history [n] show the last n lines entered
+/quit        leave the console`
+
+// Options configures the console channel
+type Options struct {
+	// In and Out default to stdin and stdout
+	In  io.Reader
+	Out io.Writer
+	// Interactive enables the line editing, the history and the greetings
+	Interactive bool
+	// HistoryFile keeps the history of the interactive mode, the storage
+	// local/console/history file when empty
+	HistoryFile string
+	// Execute is a message run instead of reading the input
+	Execute string
+}
+
+type ConsoleChannel struct {
+	options  Options
+	userName string
+	prompts  chan agent.Prompt
+	mu       sync.Mutex
+	history  []string
+	result   error
+	// out is where the replies are written, the line editor while it runs
+	out io.Writer
+}
+
+// New returns a console channel, it reads from stdin and writes to stdout
+// unless other streams are given
+func New(options Options) *ConsoleChannel {
+	if options.In == nil {
+		options.In = os.Stdin
+	}
+	if options.Out == nil {
+		options.Out = os.Stdout
+	}
+	userName := "unknown"
+	if u, err := user.Current(); err == nil {
+		userName = u.Username
+	}
+	return &ConsoleChannel{options: options, userName: userName, prompts: make(chan agent.Prompt, 1), out: options.Out}
+}
+
+// IsTerminal returns true when stdin and stdout are terminals
+func IsTerminal() bool {
+	return readline.DefaultIsTerminal()
+}
+
+func (c *ConsoleChannel) Name() string {
+	return ChannelName
+}
+
+func (c *ConsoleChannel) Capabilities() agent.ChannelCapabilities {
+	return agent.ChannelCapabilities{}
+}
+
+func (c *ConsoleChannel) Send(conversation string, text string) error {
+	c.mu.Lock()
+	out := c.out
+	c.mu.Unlock()
+	_, err := fmt.Fprintln(out, text)
+	return err
+}
+
+// setOutput changes where the replies are written
+func (c *ConsoleChannel) setOutput(out io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.out = out
+}
+
+// SendPrompt shows a question of the agent, it is answered with the next line
+// of the input
+func (c *ConsoleChannel) SendPrompt(conversation string, prompt agent.Prompt) error {
+	question := prompt.Text
+	if len(prompt.Options) > 0 {
+		question = fmt.Sprintf("%s (%s)", question, strings.Join(prompt.Options, "/"))
+	}
+	if err := c.Send(conversation, question); err != nil {
+		return err
+	}
+
+	// A new prompt replaces the previous one
+	select {
+	case <-c.prompts:
+	default:
+	}
+	c.prompts <- prompt
+	return nil
+}
+
+// Result returns the first error of the messages run in the script or execute
+// mode, nil when all of them succeeded
+func (c *ConsoleChannel) Result() error {
+	return c.result
+}
+
+// Start runs the console until the end of the input, /quit or the context is
+// done.
+func (c *ConsoleChannel) Start(ctx context.Context, agentCtx *agent.AgentCtx) error {
+	switch {
+	case c.options.Execute != "":
+		return c.execute(ctx, agentCtx)
+	case c.options.Interactive:
+		return c.repl(ctx, agentCtx)
+	default:
+		return c.script(ctx, agentCtx)
+	}
+}
+
+func (c *ConsoleChannel) message(text string) agent.Message {
+	return agent.Message{Channel: ChannelName, Conversation: conversation, User: c.userName, Role: agent.RoleAdmin, Text: text}
+}
+
+// inputLine is a line of the input, interrupted is set when Ctrl-C was pressed
+// instead
+type inputLine struct {
+	text        string
+	interrupted bool
+}
+
+// lineReader reads a line each time one is requested, the input is read in the
+// background so the console still stops when the context is done
+type lineReader struct {
+	requests chan string
+	lines    chan inputLine
+	done     chan struct{}
+	// reading is set from the request of a line until it is received, it is
+	// only used by the console goroutine
+	reading bool
+	// setPrompt changes the prompt of the line being read, nil when the
+	// input has no prompt
+	setPrompt func(prompt string)
+	// typedAhead holds the lines entered while a message was handled, they
+	// are returned first
+	typedAhead []string
+}
+
+func newLineReader(readLine func(prompt string) (string, error)) *lineReader {
+	r := &lineReader{requests: make(chan string), lines: make(chan inputLine), done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		for prompt := range r.requests {
+			line, err := readLine(prompt)
+			if errors.Is(err, readline.ErrInterrupt) {
+				r.lines <- inputLine{interrupted: true}
+				continue
+			}
+			if err != nil {
+				return
+			}
+			r.lines <- inputLine{text: line}
+		}
+	}()
+	return r
+}
+
+// read requests a line unless one is being read already, it returns false at
+// the end of the input or when the context is done
+func (r *lineReader) read(ctx context.Context, prompt string) bool {
+	if r.reading {
+		if r.setPrompt != nil {
+			r.setPrompt(prompt)
+		}
+		return true
+	}
+	select {
+	case r.requests <- prompt:
+		r.reading = true
+		return true
+	case <-r.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// next returns the next line, Ctrl-C only clears the line being typed. It
+// returns false at the end of the input or when the context is done.
+func (r *lineReader) next(ctx context.Context, prompt string) (string, bool) {
+	if len(r.typedAhead) > 0 {
+		line := r.typedAhead[0]
+		r.typedAhead = r.typedAhead[1:]
+		return line, true
+	}
 	for {
-		fmt.Print("> ")
-		scanner.Scan()
-		text := scanner.Text()
-		if text == "" {
-			break
+		if !r.read(ctx, prompt) {
+			return "", false
 		}
-		if err := callback(text); err != nil {
+		select {
+		case line := <-r.lines:
+			r.reading = false
+			if !line.interrupted {
+				return line.text, true
+			}
+		case <-r.done:
+			return "", false
+		case <-ctx.Done():
+			return "", false
+		}
+	}
+}
+
+func scanLines(in io.Reader) func(string) (string, error) {
+	scanner := bufio.NewScanner(in)
+	return func(string) (string, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return scanner.Text(), nil
+	}
+}
+
+// handle runs a message, the questions of the agent are answered with the next
+// lines of the input. The interactive console reads the input until the message
+// is handled, "stop", "cancel" and Ctrl-C cancel it and the other lines are run
+// next.
+func (c *ConsoleChannel) handle(ctx context.Context, agentCtx *agent.AgentCtx, input *lineReader, text string) error {
+
+	done := make(chan error, 1)
+	go func() {
+		done <- agentCtx.ProcessMessage(c.message(text))
+	}()
+
+	var question *agent.Prompt
+	ended := false
+	for {
+		if !ended && question != nil {
+			ended = !input.read(ctx, "? ")
+		} else if !ended && c.options.Interactive {
+			ended = !input.read(ctx, "")
+		}
+		if ended && question != nil {
+			// At the end of the input the question is left unanswered
+			agentCtx.Answer(ChannelName, conversation, c.userName, question.Id, "")
+			question = nil
+		}
+
+		var lines <-chan inputLine
+		var inputDone <-chan struct{}
+		if !ended && input.reading {
+			lines, inputDone = input.lines, input.done
+		}
+
+		select {
+		case err := <-done:
 			return err
+		case prompt := <-c.prompts:
+			question = &prompt
+		case line := <-lines:
+			input.reading = false
+			switch {
+			case line.interrupted || (question == nil && agent.IsCancelRequest(line.text)):
+				// The agent may be busy with the message, the console is not
+				// held until the cancellation is taken. An open question is
+				// answered by the cancellation.
+				go agentCtx.DispatchMessage(c.message("cancel"))
+				question = nil
+			case question != nil:
+				agentCtx.Answer(ChannelName, conversation, c.userName, question.Id, line.text)
+				question = nil
+			default:
+				input.typedAhead = append(input.typedAhead, line.text)
+			}
+		case <-inputDone:
+			ended = true
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
+}
+
+// run handles a line, it returns false when the console must stop
+func (c *ConsoleChannel) run(ctx context.Context, agentCtx *agent.AgentCtx, input *lineReader, line string) (bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return true, nil
+	}
+
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case "/quit", "exit", "quit":
+		return false, nil
+	case "/history":
+		c.Send(conversation, c.historyLines(fields[1:]))
+		return true, nil
+	case "/help":
+		c.Send(conversation, consoleCommandsHelp)
+	}
+	return true, c.handle(ctx, agentCtx, input, line)
+}
+
+func (c *ConsoleChannel) historyLines(args []string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := defaultHistoryLines
+	if len(args) > 0 {
+		value, err := strconv.Atoi(args[0])
+		if err != nil || value <= 0 {
+			return "Usage: /history [number of lines]"
+		}
+		n = value
+	}
+
+	start := len(c.history) - n
+	if start < 0 {
+		start = 0
+	}
+	lines := make([]string, 0, len(c.history)-start)
+	for i := start; i < len(c.history); i++ {
+		lines = append(lines, fmt.Sprintf("%5d  %s", i+1, c.history[i]))
+	}
+	if len(lines) == 0 {
+		return "The history is empty"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// execute runs the message given in the options, the input only answers the
+// questions of the agent
+func (c *ConsoleChannel) execute(ctx context.Context, agentCtx *agent.AgentCtx) error {
+	input := newLineReader(scanLines(c.options.In))
+	c.result = c.handle(ctx, agentCtx, input, c.options.Execute)
 	return nil
 }
 
-// StartBot initializes and starts the bot with a channel listener.
-func Start(ctx *agent.AgentCtx) {
+// script runs the lines of the input in order, the first error is kept as the
+// result
+func (c *ConsoleChannel) script(ctx context.Context, agentCtx *agent.AgentCtx) error {
+	input := newLineReader(scanLines(c.options.In))
+	for {
+		line, ok := input.next(ctx, "")
+		if !ok {
+			return nil
+		}
+		more, err := c.run(ctx, agentCtx, input, line)
+		if err != nil && c.result == nil {
+			c.result = err
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// repl reads the user input with line editing until /quit, the end of the
+// input or the context is done.
+func (c *ConsoleChannel) repl(ctx context.Context, agentCtx *agent.AgentCtx) error {
+
+	logger := agent.GetLogger()
 
-	ctx.SetWriterFunc(func(text string) error { fmt.Println(text); return nil })
+	path := c.historyPath(agentCtx)
+	c.loadHistory(path)
 
-	fn := func(userInput string) error {
-		ctx.DispatchInput(userInput)
-		return nil
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:       "> ",
+		HistoryFile:  path,
+		HistoryLimit: historyLimit,
+		AutoComplete: readline.NewPrefixCompleter(
+			readline.PcItem("/help"),
+			readline.PcItem("/actions"),
+			readline.PcItem("/kb"),
+			readline.PcItem("/reload"),
+			readline.PcItem("/audit"),
+			readline.PcItem("/history"),
+			readline.PcItem("/quit"),
+		),
+		Stdin:  io.NopCloser(c.options.In),
+		Stdout: c.options.Out,
+	})
+	if err != nil {
+		return err
+	}
+	defer rl.Close()
+
+	// The replies written while a line is typed must not mangle it
+	c.setOutput(rl)
+	defer c.setOutput(c.options.Out)
+
+	input := newLineReader(func(prompt string) (string, error) {
+		rl.SetPrompt(prompt)
+		line, err := rl.Readline()
+		if err == nil && strings.TrimSpace(line) != "" {
+			c.mu.Lock()
+			c.history = append(c.history, line)
+			c.mu.Unlock()
+		}
+		return line, err
+	})
+	input.setPrompt = func(prompt string) {
+		rl.SetPrompt(prompt)
+		rl.Refresh()
+	}
+
+	agentCtx.SayHello(c.message(""))
+	defer c.sayGoodBye(agentCtx)
+
+	for {
+		line, ok := input.next(ctx, "> ")
+		if !ok {
+			return nil
+		}
+		more, err := c.run(ctx, agentCtx, input, line)
+		if err != nil {
+			logger.Debug("Console message not handled: %s", err)
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// historyPath returns the history file, empty when there is none
+func (c *ConsoleChannel) historyPath(agentCtx *agent.AgentCtx) string {
+
+	logger := agent.GetLogger()
+
+	if c.options.HistoryFile != "" {
+		return c.options.HistoryFile
+	}
+	pather, ok := agentCtx.Storage.(agent.LocalPather)
+	if !ok {
+		return ""
+	}
+	if err := agent.EnsureDir(agentCtx.Storage, historyDir, 0700); err != nil {
+		logger.Warning("The console history is not kept: %s", err)
+		return ""
 	}
+	return pather.LocalPath(historyFile)
+}
 
-	ctx.SayHello()
-	err := forEachInput(os.Stdin, fn)
+// loadHistory reads the history file, it is created readable only by the user
+func (c *ConsoleChannel) loadHistory(path string) {
+	if path == "" {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
 	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			c.history = append(c.history, line)
+		}
+	}
+}
+
+func (c *ConsoleChannel) sayGoodBye(agentCtx *agent.AgentCtx) {
+	if msg, err := agentCtx.SayGoodBye(); err == nil {
+		c.Send(conversation, msg)
+	}
+}
+
+// ExitCode returns the process exit code for the result of the console: 0 on
+// success, 1 when an action failed or the message could not be handled and 2
+// when no action ran.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, agent.ErrActionFailed):
+		return 1
+	case errors.Is(err, agent.ErrNoActionFound),
+		errors.Is(err, agent.ErrMissingArguments),
+		errors.Is(err, agent.ErrActionCancelled),
+		errors.Is(err, agent.ErrNotAuthorized),
+		errors.Is(err, agent.ErrUnknownCommand):
+		return 2
+	default:
+		return 1
 	}
-	ctx.SayGoodBye()
 }
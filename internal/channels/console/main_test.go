@@ -0,0 +1,129 @@
+package consoleChannel_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/a13labs/cobot/internal/channels/channeltest"
+	consoleChannel "github.com/a13labs/cobot/internal/channels/console"
+)
+
+func runConsole(t *testing.T, options consoleChannel.Options) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	options.Out = &out
+	channel := consoleChannel.New(options)
+
+	agentCtx := &agent.AgentCtx{InputChannel: make(chan agent.Message)}
+	if err := agentCtx.AddChannel(channel); err != nil {
+		t.Fatal(err)
+	}
+	go channeltest.FakeAgent(agentCtx, map[string]string{"/help": "agent help"})
+	defer close(agentCtx.InputChannel)
+
+	done := make(chan error, 1)
+	go func() { done <- channel.Start(context.Background(), agentCtx) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the console")
+	}
+	return out.String(), channel.Result()
+}
+
+func TestScriptRunsLinesInOrder(t *testing.T) {
+	out, result := runConsole(t, consoleChannel.Options{
+		In: strings.NewReader("restart nginx\nyes\n\n/help\nstatus\nno\n/quit\nuptime\n"),
+	})
+
+	for _, expected := range []string{"ran restart nginx", "/history [n]", "agent help"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in the output:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, "ran status") || strings.Contains(out, "uptime") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	if !errors.Is(result, agent.ErrActionCancelled) || consoleChannel.ExitCode(result) != 2 {
+		t.Errorf("expected the cancelled status as result, got %v", result)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	out, result := runConsole(t, consoleChannel.Options{In: strings.NewReader("yes\n"), Execute: "restart nginx"})
+	if !strings.Contains(out, "ran restart nginx") || result != nil || consoleChannel.ExitCode(result) != 0 {
+		t.Errorf("unexpected result %v, output:\n%s", result, out)
+	}
+
+	_, result = runConsole(t, consoleChannel.Options{In: strings.NewReader(""), Execute: "fail now"})
+	if consoleChannel.ExitCode(result) != 1 {
+		t.Errorf("expected exit code 1 for %v", result)
+	}
+}
+
+func TestInteractiveCancel(t *testing.T) {
+	in, input := io.Pipe()
+	var out bytes.Buffer
+	channel := consoleChannel.New(consoleChannel.Options{In: in, Out: &out, Interactive: true, HistoryFile: filepath.Join(t.TempDir(), "history")})
+
+	// The request runs until the user cancels it
+	agentCtx := &agent.AgentCtx{InputChannel: make(chan agent.Message)}
+	if err := agentCtx.AddChannel(channel); err != nil {
+		t.Fatal(err)
+	}
+	cancelled := make(chan struct{})
+	go func() {
+		for msg := range agentCtx.InputChannel {
+			if agent.IsCancelRequest(msg.Text) {
+				close(cancelled)
+				continue
+			}
+			go func(msg agent.Message) {
+				select {
+				case <-cancelled:
+					msg.Result <- agent.ErrRequestCancelled
+				case <-time.After(5 * time.Second):
+					msg.Result <- nil
+				}
+			}(msg)
+		}
+	}()
+	defer close(agentCtx.InputChannel)
+
+	done := make(chan error, 1)
+	go func() { done <- channel.Start(context.Background(), agentCtx) }()
+
+	start := time.Now()
+	io.WriteString(input, "sleep\n")
+	io.WriteString(input, "stop\n")
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the request was not cancelled")
+	}
+	io.WriteString(input, "/quit\n")
+	input.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for the console")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the console stopped after %s; want the request cancelled", elapsed)
+	}
+}
@@ -0,0 +1,95 @@
+package controlChannel
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+// ErrRequestFailed is returned when the agent reports an error for a request
+var ErrRequestFailed = errors.New("request failed")
+
+// Client talks to an agent over its control socket
+type Client struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+// Dial connects to the control socket at the given path
+func Dial(path string) (*Client, error) {
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxFrameSize)
+	return &Client{conn: conn, scanner: scanner}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) send(frame Frame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(append(data, '\n'))
+	return err
+}
+
+func (c *Client) receive() (Frame, error) {
+	var frame Frame
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return frame, err
+		}
+		return frame, errors.New("connection closed by the agent")
+	}
+	err := json.Unmarshal(c.scanner.Bytes(), &frame)
+	return frame, err
+}
+
+// Request sends a request and waits for its outcome. The replies of the agent
+// are given to onReply, the questions are answered with ask. The last frame of
+// the request is returned, the error wraps ErrRequestFailed when the agent
+// reports one.
+func (c *Client) Request(request Frame, onReply func(text string), ask func(prompt agent.Prompt) (string, error)) (Frame, error) {
+	if err := c.send(request); err != nil {
+		return Frame{}, err
+	}
+	for {
+		frame, err := c.receive()
+		if err != nil {
+			return frame, err
+		}
+		switch frame.Type {
+		case agent.EventReply:
+			if onReply != nil {
+				onReply(frame.Text)
+			}
+		case FramePrompt:
+			if ask == nil {
+				return frame, fmt.Errorf("%w: the agent asked a question: %s", ErrRequestFailed, frame.Text)
+			}
+			answer, err := ask(agent.Prompt{Id: frame.Id, Text: frame.Text, Options: frame.Options})
+			if err != nil {
+				return frame, err
+			}
+			if err := c.send(Frame{Type: FrameConfirm, Id: frame.Id, Answer: answer}); err != nil {
+				return frame, err
+			}
+		default:
+			if frame.Error != "" {
+				return frame, fmt.Errorf("%w: %s", ErrRequestFailed, frame.Error)
+			}
+			return frame, nil
+		}
+	}
+}
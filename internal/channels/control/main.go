@@ -0,0 +1,411 @@
+package controlChannel
+
+/*
+	The control channel serves the local administration of a running agent on a
+	Unix domain socket, it is used by the 'cobot ctl' command. Each connection is
+	a conversation, both sides exchange JSON frames, one per line:
+
+	client -> agent
+	- {"type":"message","text":"restart nginx"}
+	- {"type":"confirm","id":"<prompt id>","answer":"yes"}
+	- {"type":"actions"}, {"type":"reload"}: run the chat command
+	- {"type":"jobs"}, {"type":"status"}
+	- {"type":"shutdown"}: stops the agent gracefully
+
+	agent -> client
+	- {"type":"reply","text":"..."}
+	- {"type":"prompt","id":"...","text":"Do you want to ...?","options":["yes","no"]}
+	- {"type":"jobs","jobs":[...]}
+	- {"type":"status","status":{...}}
+	- {"type":"done","error":"..."}: the request is handled, error is empty on success
+
+	The socket is only accessible by the user running the agent, or by the
+	members of the configured group. The peer credentials are checked on every
+	connection where the system reports them, the peers accepted act with the
+	admin role.
+*/
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+const ChannelName = "control"
+
+// SocketFile is the storage path of the socket when none is configured
+const SocketFile = "local/control.sock"
+
+// Frame types
+const (
+	FrameMessage  = "message"
+	FrameConfirm  = "confirm"
+	FrameActions  = "actions"
+	FrameJobs     = "jobs"
+	FrameReload   = "reload"
+	FrameStatus   = "status"
+	FrameShutdown = "shutdown"
+	FramePrompt   = "prompt"
+	FrameDone     = "done"
+)
+
+// Largest frame accepted from a client
+const maxFrameSize = 1 << 20
+
+// Messages of a connection waiting for the agent, the next ones are refused
+const messageQueueSize = 100
+
+var (
+	ErrConnectionNotFound = errors.New("connection not found")
+	ErrAgentListening     = errors.New("an agent is already listening on the control socket")
+	ErrPeerNotAllowed     = errors.New("peer not allowed on the control socket")
+)
+
+// Frame is the unit exchanged on a connection
+type Frame struct {
+	Type    string        `json:"type"`
+	Id      string        `json:"id,omitempty"`
+	Text    string        `json:"text,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Options []string      `json:"options,omitempty"`
+	Answer  string        `json:"answer,omitempty"`
+	Jobs    []agent.Job   `json:"jobs,omitempty"`
+	Status  *agent.Status `json:"status,omitempty"`
+}
+
+// Options configures the control channel
+type Options struct {
+	// Path of the socket, the storage local/control.sock file when empty
+	Path string
+	// Group gives the access to the socket to the members of this group, only
+	// the user running the agent has access when empty
+	Group string
+}
+
+type connection struct {
+	conn net.Conn
+	user string
+	mu   sync.Mutex
+}
+
+func (c *connection) send(frame Frame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.conn.Write(append(data, '\n'))
+	return err
+}
+
+type ControlChannel struct {
+	options     Options
+	mu          sync.Mutex
+	connections map[string]*connection
+	lastId      uint64
+	shutdown    chan struct{}
+	once        sync.Once
+}
+
+func New(options Options) *ControlChannel {
+	return &ControlChannel{
+		options:     options,
+		connections: map[string]*connection{},
+		shutdown:    make(chan struct{}),
+	}
+}
+
+func (c *ControlChannel) Name() string {
+	return ChannelName
+}
+
+func (c *ControlChannel) Capabilities() agent.ChannelCapabilities {
+	return agent.ChannelCapabilities{}
+}
+
+func (c *ControlChannel) Send(conversation string, text string) error {
+	return c.send(conversation, Frame{Type: agent.EventReply, Text: text})
+}
+
+func (c *ControlChannel) SendPrompt(conversation string, prompt agent.Prompt) error {
+	return c.send(conversation, Frame{Type: FramePrompt, Id: prompt.Id, Text: prompt.Text, Options: prompt.Options})
+}
+
+func (c *ControlChannel) send(conversation string, frame Frame) error {
+	c.mu.Lock()
+	conn, ok := c.connections[conversation]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrConnectionNotFound, conversation)
+	}
+	return conn.send(frame)
+}
+
+// SocketPath returns the path of the socket for the given storage, empty when
+// none is configured and the storage has no local path
+func SocketPath(options Options, storage agent.Storage) string {
+	if options.Path != "" {
+		return options.Path
+	}
+	pather, ok := storage.(agent.LocalPather)
+	if !ok {
+		return ""
+	}
+	return pather.LocalPath(SocketFile)
+}
+
+// Start serves the control socket until the context is done or a client asks
+// for the agent to shut down
+func (c *ControlChannel) Start(ctx context.Context, agentCtx *agent.AgentCtx) error {
+
+	logger := agent.GetLogger()
+
+	if c.options.Path == "" {
+		if err := agent.EnsureDir(agentCtx.Storage, "local", 0700); err != nil {
+			return err
+		}
+	}
+	path := SocketPath(c.options, agentCtx.Storage)
+	if path == "" {
+		return errors.New("the storage has no local path, a socket path is required")
+	}
+
+	listener, err := listen(path, c.options.Group)
+	if err != nil {
+		return err
+	}
+	logger.Info("Control channel listening on %s", path)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.shutdown:
+		}
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-c.shutdown:
+				logger.Info("Control channel asked to shut down the agent")
+				return nil
+			default:
+				return err
+			}
+		}
+		go c.serve(ctx, agentCtx, conn)
+	}
+}
+
+// listen creates the socket readable only by the owner, or by the group when
+// one is given. A socket left by a stopped agent is replaced.
+func listen(path string, group string) (*net.UnixListener, error) {
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: %s", ErrAgentListening, path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetUnlinkOnClose(true)
+
+	mode := os.FileMode(0600)
+	if group != "" {
+		gid, err := groupId(group)
+		if err == nil {
+			err = os.Chown(path, -1, gid)
+		}
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		mode = 0660
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func groupId(name string) (int, error) {
+	group, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(group.Gid)
+}
+
+// authorize checks the credentials of the peer, the name of the peer is
+// returned
+func (c *ControlChannel) authorize(conn net.Conn) (string, error) {
+
+	uid, gid, err := peerCredentials(conn)
+	if errors.Is(err, errUnsupported) {
+		// Only the permissions of the socket protect it
+		return "local", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("uid:%d", uid)
+	peer, lookupErr := user.LookupId(strconv.Itoa(uid))
+	if lookupErr == nil {
+		name = peer.Username
+	}
+
+	if uid == 0 || uid == os.Getuid() {
+		return name, nil
+	}
+	if c.options.Group != "" {
+		allowed, err := groupId(c.options.Group)
+		if err != nil {
+			return name, err
+		}
+		if gid == allowed {
+			return name, nil
+		}
+		if lookupErr == nil {
+			groups, _ := peer.GroupIds()
+			for _, group := range groups {
+				if group == strconv.Itoa(allowed) {
+					return name, nil
+				}
+			}
+		}
+	}
+	return name, ErrPeerNotAllowed
+}
+
+func (c *ControlChannel) serve(ctx context.Context, agentCtx *agent.AgentCtx, netConn net.Conn) {
+
+	logger := agent.GetLogger()
+
+	defer netConn.Close()
+
+	name, err := c.authorize(netConn)
+	if err != nil {
+		logger.Warning("Refused control connection from %s: %s", name, err)
+		agentCtx.AuditRefusal(agent.Message{Channel: ChannelName, User: name}, "", err.Error())
+		json.NewEncoder(netConn).Encode(Frame{Type: FrameDone, Error: ErrPeerNotAllowed.Error()})
+		return
+	}
+
+	c.mu.Lock()
+	c.lastId++
+	id := strconv.FormatUint(c.lastId, 10)
+	conn := &connection{conn: netConn, user: name}
+	c.connections[id] = conn
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.connections, id)
+		c.mu.Unlock()
+	}()
+
+	// Unblock the reads when the channel stops
+	stop := context.AfterFunc(ctx, func() { netConn.Close() })
+	defer stop()
+
+	// The connection keeps reading so the prompts can be answered, the
+	// messages are queued and dispatched in order, each one is done once its
+	// result is known
+	queue := make(chan agent.Message, messageQueueSize)
+	go func() {
+		for msg := range queue {
+			result := make(chan error, 1)
+			msg.Result = result
+			agentCtx.DispatchMessage(msg)
+			go func() {
+				done := Frame{Type: FrameDone}
+				if err := <-result; err != nil {
+					done.Error = agentCtx.Secrets.Redact(err.Error())
+				}
+				conn.send(done)
+			}()
+		}
+	}()
+	defer close(queue)
+
+	scanner := bufio.NewScanner(netConn)
+	scanner.Buffer(make([]byte, 0, 4096), maxFrameSize)
+	for scanner.Scan() {
+		var frame Frame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			conn.send(Frame{Type: FrameDone, Error: "invalid frame"})
+			continue
+		}
+		c.handle(agentCtx, id, conn, queue, frame)
+	}
+}
+
+// handle runs a request of a connection, the messages are queued for the agent
+func (c *ControlChannel) handle(agentCtx *agent.AgentCtx, id string, conn *connection, queue chan<- agent.Message, frame Frame) {
+
+	msg := agent.Message{Channel: ChannelName, Conversation: id, User: conn.user, Role: agent.RoleAdmin}
+
+	run := func(text string) {
+		msg.Text = text
+		select {
+		case queue <- msg:
+		default:
+			conn.send(Frame{Type: FrameDone, Error: "message queue is full"})
+		}
+	}
+
+	switch frame.Type {
+	case FrameMessage:
+		if strings.TrimSpace(frame.Text) == "" {
+			conn.send(Frame{Type: FrameDone, Error: "text is required"})
+			return
+		}
+		run(frame.Text)
+	case FrameActions:
+		run("/actions")
+	case FrameReload:
+		run("/reload")
+	case FrameConfirm:
+		if err := agentCtx.Answer(ChannelName, id, conn.user, frame.Id, frame.Answer); err != nil {
+			conn.send(Frame{Type: FrameDone, Id: frame.Id, Error: err.Error()})
+		}
+	case FrameJobs:
+		conn.send(Frame{Type: FrameJobs, Jobs: agentCtx.Jobs()})
+	case FrameStatus:
+		status := agentCtx.Status()
+		conn.send(Frame{Type: FrameStatus, Status: &status})
+	case FrameShutdown:
+		conn.send(Frame{Type: FrameDone})
+		c.once.Do(func() { close(c.shutdown) })
+	default:
+		conn.send(Frame{Type: FrameDone, Error: fmt.Sprintf("unknown frame type '%s'", frame.Type)})
+	}
+}
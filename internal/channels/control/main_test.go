@@ -0,0 +1,216 @@
+package controlChannel_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/a13labs/cobot/internal/channels/channeltest"
+	controlChannel "github.com/a13labs/cobot/internal/channels/control"
+)
+
+func startChannel(t *testing.T) (string, chan error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	channel := controlChannel.New(controlChannel.Options{Path: path})
+
+	agentCtx := &agent.AgentCtx{
+		InputChannel: make(chan agent.Message),
+		UserArgs:     agent.AgentStartArgs{LLMModel: "mistral"},
+		StartTime:    time.Now(),
+	}
+	if err := agentCtx.AddChannel(channel); err != nil {
+		t.Fatal(err)
+	}
+	go channeltest.FakeAgent(agentCtx, map[string]string{"/actions": "restart: restart a service"})
+	t.Cleanup(func() { close(agentCtx.InputChannel) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- channel.Start(ctx, agentCtx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path, done
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the control socket")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func dial(t *testing.T, path string) *controlChannel.Client {
+	t.Helper()
+	client, err := controlChannel.Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestSocketIsOnlyAccessibleByTheOwner(t *testing.T) {
+	path, _ := startChannel(t)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the socket mode to be 0600, got %o", info.Mode().Perm())
+	}
+}
+
+func TestMessageWithPrompt(t *testing.T) {
+	path, _ := startChannel(t)
+	client := dial(t, path)
+
+	var replies, questions []string
+	_, err := client.Request(controlChannel.Frame{Type: controlChannel.FrameMessage, Text: "restart nginx"},
+		func(text string) { replies = append(replies, text) },
+		func(prompt agent.Prompt) (string, error) {
+			questions = append(questions, prompt.Text)
+			return "yes", nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(questions) != 1 || questions[0] != "Run restart nginx?" {
+		t.Errorf("unexpected questions %v", questions)
+	}
+	if len(replies) != 1 || replies[0] != "ran restart nginx" {
+		t.Errorf("unexpected replies %v", replies)
+	}
+}
+
+func TestFailedMessage(t *testing.T) {
+	path, _ := startChannel(t)
+	client := dial(t, path)
+
+	_, err := client.Request(controlChannel.Frame{Type: controlChannel.FrameMessage, Text: "fail now"}, nil, nil)
+	if !errors.Is(err, controlChannel.ErrRequestFailed) || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("expected the action error, got %v", err)
+	}
+
+	// The connection is still usable
+	var replies []string
+	if _, err := client.Request(controlChannel.Frame{Type: controlChannel.FrameActions}, func(text string) { replies = append(replies, text) }, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 || !strings.HasPrefix(replies[0], "restart:") {
+		t.Errorf("unexpected actions %v", replies)
+	}
+}
+
+func TestStatusAndJobs(t *testing.T) {
+	path, _ := startChannel(t)
+	client := dial(t, path)
+
+	frame, err := client.Request(controlChannel.Frame{Type: controlChannel.FrameStatus}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Status == nil || frame.Status.Model != "mistral" {
+		t.Fatalf("unexpected status %+v", frame.Status)
+	}
+	if len(frame.Status.Channels) != 1 || frame.Status.Channels[0] != controlChannel.ChannelName {
+		t.Errorf("unexpected channels %v", frame.Status.Channels)
+	}
+
+	frame, err = client.Request(controlChannel.Frame{Type: controlChannel.FrameJobs}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Type != controlChannel.FrameJobs || len(frame.Jobs) != 0 {
+		t.Errorf("unexpected jobs frame %+v", frame)
+	}
+
+	if _, err := client.Request(controlChannel.Frame{Type: "unknown"}, nil, nil); !errors.Is(err, controlChannel.ErrRequestFailed) {
+		t.Errorf("expected an error for an unknown frame, got %v", err)
+	}
+}
+
+func TestShutdownStopsTheChannel(t *testing.T) {
+	path, done := startChannel(t)
+	client := dial(t, path)
+
+	if _, err := client.Request(controlChannel.Frame{Type: controlChannel.FrameShutdown}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+		done <- nil
+	case <-time.After(5 * time.Second):
+		t.Fatal("the channel did not stop")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the socket to be removed, got %v", err)
+	}
+}
+
+func TestSocketInUseOrNotASocket(t *testing.T) {
+	path, _ := startChannel(t)
+	agentCtx := &agent.AgentCtx{}
+
+	err := controlChannel.New(controlChannel.Options{Path: path}).Start(context.Background(), agentCtx)
+	if !errors.Is(err, controlChannel.ErrAgentListening) {
+		t.Errorf("expected the socket to be in use, got %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := controlChannel.New(controlChannel.Options{Path: file}).Start(context.Background(), agentCtx); err == nil {
+		t.Error("expected an error for a path that is not a socket")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("the file must be kept: %v", err)
+	}
+}
+
+func TestStaleSocketIsReplaced(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.SetUnlinkOnClose(false)
+	listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- controlChannel.New(controlChannel.Options{Path: path}).Start(ctx, &agent.AgentCtx{}) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if client, err := controlChannel.Dial(path); err == nil {
+			client.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the stale socket was not replaced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package controlChannel
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+var errUnsupported = errors.New("peer credentials not supported")
+
+// peerCredentials returns the user and group ids of the process connected
+func peerCredentials(conn net.Conn) (int, int, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, 0, errUnsupported
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if credErr != nil {
+		return 0, 0, credErr
+	}
+	return int(cred.Uid), int(cred.Gid), nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package controlChannel
+
+import (
+	"errors"
+	"net"
+)
+
+var errUnsupported = errors.New("peer credentials not supported")
+
+// peerCredentials is not supported, the socket is only protected by its
+// permissions
+func peerCredentials(conn net.Conn) (int, int, error) {
+	return 0, 0, errUnsupported
+}
@@ -0,0 +1,400 @@
+package emailChannel
+
+/*
+	The email channel polls an IMAP mailbox for the unseen messages of the
+	allowed senders, the subject and the body of a message are the request. The
+	replies of a request are sent in a single email answering it over SMTP, with
+	the In-Reply-To and References headers set so they stay in the thread.
+
+	A thread is a conversation, its id is the Message-ID of the first message.
+	The questions of the agent are sent right away, the answer is the first line
+	of the reply of the sender. Any other message of a thread is a new request.
+
+	The From header is chosen by the sender, a message is only accepted when
+	the Authentication-Results header added by the mail server reports a DKIM,
+	SPF or DMARC pass for the domain of the sender. The allowed senders are
+	given the configured role, viewer by default.
+*/
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/a13labs/cobot/internal/metrics"
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+const ChannelName = "email"
+
+// Requests waiting for the agent, the next ones are dropped
+const messageQueueSize = 100
+
+// Threads without activity are forgotten after this delay
+const threadTTL = 24 * time.Hour
+
+const DefaultPollInterval = time.Minute
+
+var ErrUnknownThread = errors.New("unknown email thread")
+
+// Options configures the email channel
+type Options struct {
+	// IMAPAddress is the host:port of the IMAP server, TLS is used unless
+	// IMAPPlain is set
+	IMAPAddress  string
+	IMAPPlain    bool
+	IMAPUsername string
+	IMAPPassword string
+	// Mailbox is polled for the requests, INBOX when empty
+	Mailbox      string
+	PollInterval time.Duration
+	// SMTPAddress is the host:port of the SMTP server, STARTTLS is used when
+	// the server offers it
+	SMTPAddress  string
+	SMTPUsername string
+	SMTPPassword string
+	// From is the address of the agent
+	From string
+	// AllowedSenders are the addresses the requests are accepted from, an
+	// entry starting with '@' accepts a whole domain
+	AllowedSenders []string
+	// AuthServId is the id of the mail server in the Authentication-Results
+	// headers, the first header is trusted when empty as it is added by the
+	// last server
+	AuthServId string
+	// NoSenderAuth accepts the messages of the allowed senders without
+	// checking their Authentication-Results, their From header can be forged
+	NoSenderAuth bool
+	// Role is given to the users of the channel, they are granted nothing
+	// when it is empty
+	Role string
+}
+
+// thread is an email conversation
+type thread struct {
+	sender     string
+	subject    string
+	references []string
+	// buffer holds the replies while a request is handled
+	buffer    []string
+	buffering bool
+	updated   time.Time
+}
+
+type EmailChannel struct {
+	options Options
+	queue   chan agent.Message
+	mu      sync.Mutex
+	threads map[string]*thread
+}
+
+// New returns a channel polling the configured mailbox once started
+func New(options Options) *EmailChannel {
+	if options.Mailbox == "" {
+		options.Mailbox = "INBOX"
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = DefaultPollInterval
+	}
+	return &EmailChannel{
+		options: options,
+		queue:   make(chan agent.Message, messageQueueSize),
+		threads: map[string]*thread{},
+	}
+}
+
+func (c *EmailChannel) Name() string {
+	return ChannelName
+}
+
+func (c *EmailChannel) Capabilities() agent.ChannelCapabilities {
+	return agent.ChannelCapabilities{}
+}
+
+// Send sends a text to a thread, while a request of the thread is handled the
+// text is kept for the reply
+func (c *EmailChannel) Send(conversation string, text string) error {
+	c.mu.Lock()
+	t, ok := c.threads[conversation]
+	if ok && t.buffering {
+		t.buffer = append(t.buffer, text)
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownThread, conversation)
+	}
+	return c.reply(conversation, text)
+}
+
+// SendPrompt sends the question with the replies kept so far, the sender
+// answers by replying
+func (c *EmailChannel) SendPrompt(conversation string, prompt agent.Prompt) error {
+	c.mu.Lock()
+	t, ok := c.threads[conversation]
+	if !ok {
+		c.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUnknownThread, conversation)
+	}
+	parts := append(t.buffer, prompt.Text)
+	t.buffer = nil
+	c.mu.Unlock()
+
+	if len(prompt.Options) > 0 {
+		parts = append(parts, fmt.Sprintf("Reply to this email with one of: %s", strings.Join(prompt.Options, ", ")))
+	}
+	return c.reply(conversation, strings.Join(parts, "\n\n"))
+}
+
+// Start polls the mailbox until the context is done
+func (c *EmailChannel) Start(ctx context.Context, agentCtx *agent.AgentCtx) error {
+
+	logger := agent.GetLogger()
+
+	if c.options.IMAPAddress == "" || c.options.SMTPAddress == "" || c.options.From == "" {
+		return errors.New("the IMAP server, the SMTP server and the from address are required")
+	}
+	if len(c.options.AllowedSenders) == 0 {
+		logger.Warning("No allowed senders are configured, all the emails will be refused")
+	}
+
+	// The mailbox is still polled while the agent waits for an answer, the
+	// requests are queued and handled in order
+	go func() {
+		for msg := range c.queue {
+			c.handle(agentCtx, msg)
+		}
+	}()
+	defer close(c.queue)
+	metrics.RegisterQueue(ChannelName, func() int { return len(c.queue) })
+
+	ticker := time.NewTicker(c.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.poll(agentCtx); err != nil {
+			logger.Error("Error polling the mailbox %s: %s", c.options.Mailbox, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// handle runs a request, the replies are sent in a single email
+func (c *EmailChannel) handle(agentCtx *agent.AgentCtx, msg agent.Message) {
+
+	logger := agent.GetLogger()
+
+	c.setBuffering(msg.Conversation, true)
+	err := agentCtx.ProcessMessage(msg)
+	replies := c.setBuffering(msg.Conversation, false)
+
+	if len(replies) == 0 {
+		if err == nil {
+			return
+		}
+		replies = []string{agentCtx.Secrets.Redact(err.Error())}
+	}
+	if err := c.reply(msg.Conversation, strings.Join(replies, "\n\n")); err != nil {
+		logger.ForMessage(msg).Error("Error sending the reply to %s: %s", msg.User, err)
+	}
+}
+
+// setBuffering starts or stops keeping the replies of a thread, the replies
+// kept are returned
+func (c *EmailChannel) setBuffering(conversation string, buffering bool) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.threads[conversation]
+	if !ok {
+		return nil
+	}
+	replies := t.buffer
+	t.buffer = nil
+	t.buffering = buffering
+	return replies
+}
+
+// reply sends an email answering the last message of a thread
+func (c *EmailChannel) reply(conversation string, text string) error {
+	c.mu.Lock()
+	t, ok := c.threads[conversation]
+	if !ok {
+		c.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUnknownThread, conversation)
+	}
+	data, messageId := composeReply(c.options.From, c.fromAddress(), t, text)
+	to := t.sender
+	t.references = append(t.references, messageId)
+	t.updated = time.Now()
+	c.mu.Unlock()
+
+	var auth smtp.Auth
+	if c.options.SMTPUsername != "" {
+		host, _, _ := strings.Cut(c.options.SMTPAddress, ":")
+		auth = smtp.PlainAuth("", c.options.SMTPUsername, c.options.SMTPPassword, host)
+	}
+	return smtp.SendMail(c.options.SMTPAddress, auth, c.fromAddress(), []string{to}, data)
+}
+
+// fromAddress returns the address of the agent without its display name
+func (c *EmailChannel) fromAddress() string {
+	if address, err := mail.ParseAddress(c.options.From); err == nil {
+		return strings.ToLower(address.Address)
+	}
+	return strings.ToLower(c.options.From)
+}
+
+// isAllowed returns true when the requests of the sender are accepted
+func (c *EmailChannel) isAllowed(sender string) bool {
+	for _, allowed := range c.options.AllowedSenders {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if sender == allowed || (strings.HasPrefix(allowed, "@") && strings.HasSuffix(sender, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// poll reads the unseen messages of the mailbox, they are marked as seen
+func (c *EmailChannel) poll(agentCtx *agent.AgentCtx) error {
+
+	var imapClient *client.Client
+	var err error
+	if c.options.IMAPPlain {
+		imapClient, err = client.Dial(c.options.IMAPAddress)
+	} else {
+		host, _, _ := strings.Cut(c.options.IMAPAddress, ":")
+		imapClient, err = client.DialTLS(c.options.IMAPAddress, &tls.Config{ServerName: host})
+	}
+	if err != nil {
+		return err
+	}
+	defer imapClient.Logout()
+
+	if err := imapClient.Login(c.options.IMAPUsername, c.options.IMAPPassword); err != nil {
+		return err
+	}
+	if _, err := imapClient.Select(c.options.Mailbox, false); err != nil {
+		return err
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+	uids, err := imapClient.UidSearch(criteria)
+	if err != nil || len(uids) == 0 {
+		return err
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uids...)
+	section := &imap.BodySectionName{Peek: true}
+
+	messages := make(chan *imap.Message, len(uids))
+	if err := imapClient.UidFetch(seqset, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, messages); err != nil {
+		return err
+	}
+
+	var raw [][]byte
+	for message := range messages {
+		if body := message.GetBody(section); body != nil {
+			if data, err := io.ReadAll(body); err == nil {
+				raw = append(raw, data)
+			}
+		}
+	}
+
+	// Marked before being handled, a request never runs twice
+	flags := []interface{}{imap.SeenFlag}
+	if err := imapClient.UidStore(seqset, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil); err != nil {
+		return err
+	}
+
+	for _, data := range raw {
+		c.receive(agentCtx, data)
+	}
+	c.forgetThreads()
+	return nil
+}
+
+// receive dispatches a message of the mailbox
+func (c *EmailChannel) receive(agentCtx *agent.AgentCtx, data []byte) {
+
+	logger := agent.GetLogger()
+
+	email, err := parseEmail(data)
+	if err != nil {
+		logger.Warning("Ignoring an invalid email: %s", err)
+		return
+	}
+	if email.autoSubmitted || email.sender == c.fromAddress() {
+		return
+	}
+
+	conversation := email.threadId()
+	msg := agent.Message{Channel: ChannelName, Conversation: conversation, User: email.sender, Role: c.options.Role}
+
+	if !c.isAllowed(email.sender) {
+		logger.ForMessage(msg).Warning("Refused email from %s, the sender is not allowed", email.sender)
+		msg.Text = email.subject
+		agentCtx.AuditRefusal(msg, "", "email sender not allowed")
+		return
+	}
+	if !c.options.NoSenderAuth && !email.senderAuthenticated(c.options.AuthServId) {
+		logger.ForMessage(msg).Warning("Refused email from %s, the sender is not authenticated", email.sender)
+		msg.Text = email.subject
+		agentCtx.AuditRefusal(msg, "", "email sender not authenticated")
+		return
+	}
+
+	c.mu.Lock()
+	t, known := c.threads[conversation]
+	if !known {
+		t = &thread{}
+		c.threads[conversation] = t
+	}
+	t.sender = email.sender
+	t.subject = email.subject
+	t.references = append(email.references, email.messageId)
+	t.updated = time.Now()
+	c.mu.Unlock()
+
+	// A reply of the sender may answer a question of the agent
+	if known && agentCtx.Answer(ChannelName, conversation, email.sender, "", firstLine(email.body)) == nil {
+		return
+	}
+
+	msg.Text = email.request(known)
+	if msg.Text == "" {
+		return
+	}
+	select {
+	case c.queue <- msg:
+	default:
+		logger.Warning("Email queue is full, dropping the request of %s", email.sender)
+	}
+}
+
+// forgetThreads removes the threads without activity
+func (c *EmailChannel) forgetThreads() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, t := range c.threads {
+		if !t.buffering && time.Since(t.updated) > threadTTL {
+			delete(c.threads, id)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package emailChannel_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	emailChannel "github.com/a13labs/cobot/internal/channels/email"
+)
+
+func startChannel(t *testing.T, handler func(*agent.AgentCtx, agent.Message) error) (*imapStandIn, *smtpStandIn, chan agent.Message) {
+	imapServer := newIMAPStandIn(t)
+	smtpServer := newSMTPStandIn(t)
+
+	channel := emailChannel.New(emailChannel.Options{
+		IMAPAddress:    imapServer.address,
+		IMAPPlain:      true,
+		IMAPUsername:   "username",
+		IMAPPassword:   "password",
+		PollInterval:   50 * time.Millisecond,
+		SMTPAddress:    smtpServer.address,
+		From:           "Cobot <cobot@example.com>",
+		AllowedSenders: []string{"alice@example.com", "@ops.example.com"},
+		AuthServId:     "mx.example.com",
+	})
+
+	agentCtx := &agent.AgentCtx{InputChannel: make(chan agent.Message)}
+	if err := agentCtx.AddChannel(channel); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan agent.Message, 10)
+	go func() {
+		for msg := range agentCtx.InputChannel {
+			received <- msg
+			err := handler(agentCtx, msg)
+			if msg.Result != nil {
+				msg.Result <- err
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		channel.Start(ctx, agentCtx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	return imapServer, smtpServer, received
+}
+
+func readEmail(t *testing.T, data []byte) (*mail.Message, string) {
+	t.Helper()
+	m, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(m.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, string(body)
+}
+
+func TestRequestIsAnsweredInThread(t *testing.T) {
+	imapServer, smtpServer, received := startChannel(t, func(agentCtx *agent.AgentCtx, msg agent.Message) error {
+		agentCtx.Deliver(agent.Reply{Channel: msg.Channel, Conversation: msg.Conversation, Text: "Restarting nginx"})
+		agentCtx.Deliver(agent.Reply{Channel: msg.Channel, Conversation: msg.Conversation, Text: "nginx restarted"})
+		return nil
+	})
+
+	imapServer.Deliver(t, `From: Mallory <mallory@example.net>
+To: cobot@example.com
+Subject: restart everything
+Message-ID: <spoof@example.net>
+
+please`)
+	// Forged senders are refused
+	imapServer.Deliver(t, `From: Alice <alice@example.com>
+Authentication-Results: mx.example.com; dkim=pass header.d=example.net; spf=fail smtp.mailfrom=mallory@example.net
+Subject: restart everything
+Message-ID: <forged1@example.net>
+
+please`)
+	imapServer.Deliver(t, `From: Alice <alice@example.com>
+Authentication-Results: mx.example.com; spf=none smtp.mailfrom=mallory@example.net
+Authentication-Results: mx.example.net; dkim=pass header.d=example.com
+Subject: restart everything
+Message-ID: <forged2@example.net>
+
+please`)
+	imapServer.Deliver(t, `From: Alice <Alice@example.com>
+Authentication-Results: mx.example.com (cobot test);
+	dkim=pass (2048-bit key) header.d=example.com header.s=mail;
+	spf=pass smtp.mailfrom=alice@example.com
+To: cobot@example.com
+Subject: restart nginx
+Message-ID: <req1@example.com>
+Content-Type: text/plain; charset=utf-8
+
+on web-1 please
+
+--`+" "+`
+Alice`)
+
+	select {
+	case msg := <-received:
+		if msg.Text != "restart nginx\non web-1 please" || msg.User != "alice@example.com" || msg.Conversation != "<req1@example.com>" {
+			t.Errorf("unexpected message %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the request")
+	}
+
+	reply, body := readEmail(t, smtpServer.Expect(t))
+	if reply.Header.Get("To") != "alice@example.com" || reply.Header.Get("Subject") != "Re: restart nginx" {
+		t.Errorf("unexpected headers %v", reply.Header)
+	}
+	if reply.Header.Get("In-Reply-To") != "<req1@example.com>" || reply.Header.Get("References") != "<req1@example.com>" {
+		t.Errorf("the reply is not threaded: %v", reply.Header)
+	}
+	if !strings.Contains(body, "Restarting nginx\n\nnginx restarted") {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestConfirmationByReply(t *testing.T) {
+	imapServer, smtpServer, received := startChannel(t, func(agentCtx *agent.AgentCtx, msg agent.Message) error {
+		confirmed, err := agentCtx.Confirm(msg, "Do you want to restart nginx?")
+		if err != nil || !confirmed {
+			return agent.ErrActionCancelled
+		}
+		agentCtx.Deliver(agent.Reply{Channel: msg.Channel, Conversation: msg.Conversation, Text: "nginx restarted"})
+		return nil
+	})
+
+	imapServer.Deliver(t, `From: bob@ops.example.com
+Authentication-Results: mx.example.com; spf=pass smtp.mailfrom=example.com
+Subject: restart nginx
+Message-ID: <req2@ops.example.com>
+
+`)
+	<-received
+
+	prompt, body := readEmail(t, smtpServer.Expect(t))
+	if !strings.Contains(body, "Reply to this email with one of: yes, no") {
+		t.Fatalf("unexpected prompt %q", body)
+	}
+	promptId := prompt.Header.Get("Message-ID")
+
+	imapServer.Deliver(t, `From: bob@ops.example.com
+Authentication-Results: mx.example.com; dmarc=pass header.from=ops.example.com
+Subject: Re: restart nginx
+Message-ID: <answer@ops.example.com>
+In-Reply-To: `+promptId+`
+References: <req2@ops.example.com> `+promptId+`
+
+Yes
+
+On Mon, 1 Jan 2024 at 10:00, Cobot <cobot@example.com> wrote:
+> Do you want to restart nginx?`)
+
+	reply, body := readEmail(t, smtpServer.Expect(t))
+	if !strings.Contains(body, "nginx restarted") {
+		t.Errorf("unexpected reply %q", body)
+	}
+	if reply.Header.Get("In-Reply-To") != "<answer@ops.example.com>" {
+		t.Errorf("the reply does not answer the confirmation: %v", reply.Header)
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("the answer was handled as a request: %+v", msg)
+	default:
+	}
+}
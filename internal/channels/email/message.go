@@ -0,0 +1,261 @@
+package emailChannel
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The line introducing the quoted message in a reply
+var quoteIntroRe = regexp.MustCompile(`^On .* wrote:$`)
+
+// The comments of the Authentication-Results headers
+var commentRe = regexp.MustCompile(`\([^)]*\)`)
+
+// email is a message read from the mailbox
+type email struct {
+	sender        string
+	subject       string
+	messageId     string
+	inReplyTo     string
+	references    []string
+	body          string
+	autoSubmitted bool
+	// authResults are the Authentication-Results headers, the last one added
+	// first
+	authResults []string
+}
+
+// parseEmail reads the headers and the text of a message
+func parseEmail(data []byte) (*email, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := mail.ParseAddress(m.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender: %w", err)
+	}
+
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		subject = m.Header.Get("Subject")
+	}
+
+	body, err := textBody(m.Header.Get("Content-Type"), m.Header.Get("Content-Transfer-Encoding"), m.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	autoSubmitted := strings.ToLower(strings.TrimSpace(m.Header.Get("Auto-Submitted")))
+	return &email{
+		sender:        strings.ToLower(from.Address),
+		subject:       stripReplyPrefix(subject),
+		messageId:     strings.TrimSpace(m.Header.Get("Message-ID")),
+		inReplyTo:     strings.TrimSpace(m.Header.Get("In-Reply-To")),
+		references:    strings.Fields(m.Header.Get("References")),
+		body:          stripQuotes(body),
+		autoSubmitted: autoSubmitted != "" && autoSubmitted != "no",
+		authResults:   m.Header["Authentication-Results"],
+	}, nil
+}
+
+// senderAuthenticated returns true when the mail server with the given id, or
+// the last one when empty, authenticated the domain of the sender with DKIM,
+// SPF or DMARC (RFC 8601)
+func (e *email) senderAuthenticated(authServId string) bool {
+	_, domain, found := strings.Cut(e.sender, "@")
+	if !found {
+		return false
+	}
+	for i, header := range e.authResults {
+		if authServId == "" && i > 0 {
+			break
+		}
+		parts := strings.Split(strings.ToLower(commentRe.ReplaceAllString(header, "")), ";")
+		if id := strings.Fields(parts[0]); len(id) == 0 || (authServId != "" && id[0] != strings.ToLower(authServId)) {
+			continue
+		}
+		for _, result := range parts[1:] {
+			fields := strings.Fields(result)
+			if len(fields) == 0 {
+				continue
+			}
+			properties := map[string]string{}
+			for _, property := range fields[1:] {
+				if key, value, found := strings.Cut(property, "="); found {
+					properties[key] = strings.Trim(value, `"`)
+				}
+			}
+			var authenticated string
+			switch fields[0] {
+			case "dkim=pass":
+				authenticated = properties["header.d"]
+			case "spf=pass":
+				authenticated = properties["smtp.mailfrom"]
+			case "dmarc=pass":
+				authenticated = properties["header.from"]
+			}
+			if _, address, found := strings.Cut(authenticated, "@"); found {
+				authenticated = address
+			}
+			if authenticated != "" && (domain == authenticated || strings.HasSuffix(domain, "."+authenticated)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// textBody returns the text of a body, the first text/plain part of a
+// multipart one
+func textBody(contentType string, encoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return "", errors.New("no text part")
+			}
+			if err != nil {
+				return "", err
+			}
+			partType := part.Header.Get("Content-Type")
+			if partType == "" {
+				partType = "text/plain"
+			}
+			if strings.HasPrefix(partType, "text/plain") || strings.HasPrefix(partType, "multipart/") {
+				return textBody(partType, part.Header.Get("Content-Transfer-Encoding"), part)
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", fmt.Errorf("unsupported content type %s", mediaType)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	data, err := io.ReadAll(body)
+	return string(data), err
+}
+
+func stripReplyPrefix(subject string) string {
+	subject = strings.TrimSpace(subject)
+	for {
+		lower := strings.ToLower(subject)
+		if !strings.HasPrefix(lower, "re:") && !strings.HasPrefix(lower, "fwd:") {
+			return subject
+		}
+		_, subject, _ = strings.Cut(subject, ":")
+		subject = strings.TrimSpace(subject)
+	}
+}
+
+// stripQuotes removes the quoted message and the signature of a reply
+func stripQuotes(body string) string {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "-- " || quoteIntroRe.MatchString(strings.TrimSpace(line)) {
+			break
+		}
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// threadId returns the id of the thread of the message, the Message-ID of the
+// first message
+func (e *email) threadId() string {
+	switch {
+	case len(e.references) > 0:
+		return e.references[0]
+	case e.inReplyTo != "":
+		return e.inReplyTo
+	case e.messageId != "":
+		return e.messageId
+	default:
+		return "<" + randomId() + ">"
+	}
+}
+
+// request returns the text of the request, the subject and the body for a new
+// thread, the body only in a known one
+func (e *email) request(knownThread bool) string {
+	if knownThread && e.body != "" {
+		return e.body
+	}
+	return strings.TrimSpace(e.subject + "\n" + e.body)
+}
+
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	return strings.TrimSpace(line)
+}
+
+func randomId() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// composeReply returns an email answering the last message of the thread and
+// its Message-ID
+func composeReply(from string, fromAddress string, t *thread, text string) ([]byte, string) {
+	domain := "localhost"
+	if _, d, found := strings.Cut(fromAddress, "@"); found {
+		domain = d
+	}
+	messageId := fmt.Sprintf("<%s@%s>", randomId(), domain)
+
+	var buf bytes.Buffer
+	header := func(name string, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", from)
+	header("To", t.sender)
+	header("Subject", mime.QEncoding.Encode("utf-8", "Re: "+t.subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageId)
+	if len(t.references) > 0 {
+		header("In-Reply-To", t.references[len(t.references)-1])
+		header("References", strings.Join(t.references, " "))
+	}
+	header("Auto-Submitted", "auto-replied")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	writer := quotedprintable.NewWriter(&buf)
+	writer.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n")))
+	writer.Close()
+	return buf.Bytes(), messageId
+}
@@ -0,0 +1,189 @@
+package emailChannel_test
+
+import (
+	"bytes"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+)
+
+// imapStandIn is a local IMAP server, the memory backend is not safe for
+// concurrent use so every mailbox access is serialized
+type imapStandIn struct {
+	address string
+	mu      sync.Mutex
+	backend *memory.Backend
+}
+
+func newIMAPStandIn(t *testing.T) *imapStandIn {
+	s := &imapStandIn{backend: memory.New()}
+
+	srv := server.New(lockedBackend{Backend: s.backend, mu: &s.mu})
+	srv.AllowInsecureAuth = true
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.address = listener.Addr().String()
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+	return s
+}
+
+// Deliver adds an unseen message to the INBOX
+func (s *imapStandIn) Deliver(t *testing.T, raw string) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.backend.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailbox, err := user.GetMailbox("INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := strings.ReplaceAll(raw, "\n", "\r\n")
+	if err := mailbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(body)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type lockedBackend struct {
+	backend.Backend
+	mu *sync.Mutex
+}
+
+func (b lockedBackend) Login(connInfo *imap.ConnInfo, username string, password string) (backend.User, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	user, err := b.Backend.Login(connInfo, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return lockedUser{User: user, mu: b.mu}, nil
+}
+
+type lockedUser struct {
+	backend.User
+	mu *sync.Mutex
+}
+
+func (u lockedUser) GetMailbox(name string) (backend.Mailbox, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	mailbox, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return lockedMailbox{Mailbox: mailbox, mu: u.mu}, nil
+}
+
+type lockedMailbox struct {
+	backend.Mailbox
+	mu *sync.Mutex
+}
+
+func (m lockedMailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Mailbox.Status(items)
+}
+
+func (m lockedMailbox) ListMessages(uid bool, seqset *imap.SeqSet, items []imap.FetchItem, ch chan<- *imap.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Mailbox.ListMessages(uid, seqset, items, ch)
+}
+
+func (m lockedMailbox) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uint32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Mailbox.SearchMessages(uid, criteria)
+}
+
+func (m lockedMailbox) UpdateMessagesFlags(uid bool, seqset *imap.SeqSet, operation imap.FlagsOp, flags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Mailbox.UpdateMessagesFlags(uid, seqset, operation, flags)
+}
+
+// smtpStandIn is a local SMTP server keeping the messages received
+type smtpStandIn struct {
+	address  string
+	messages chan []byte
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &smtpStandIn{address: listener.Addr().String(), messages: make(chan []byte, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	tc := textproto.NewConn(conn)
+	defer tc.Close()
+
+	tc.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, _, _ := strings.Cut(strings.ToUpper(line), " ")
+		switch verb {
+		case "EHLO", "HELO":
+			tc.PrintfLine("250 localhost")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			tc.PrintfLine("250 OK")
+		case "DATA":
+			tc.PrintfLine("354 Go ahead")
+			data, err := tc.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.messages <- data
+			tc.PrintfLine("250 OK")
+		case "QUIT":
+			tc.PrintfLine("221 Bye")
+			return
+		default:
+			tc.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+// Expect waits for a message
+func (s *smtpStandIn) Expect(t *testing.T) []byte {
+	t.Helper()
+	select {
+	case data := <-s.messages:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for an email")
+	}
+	return nil
+}
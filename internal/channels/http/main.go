@@ -0,0 +1,476 @@
+package httpChannel
+
+/*
+	The HTTP channel exposes the agent to scripts and dashboards:
+	- POST /v1/messages                   submit a message, returns the conversation id
+	- GET  /v1/conversations/{id}/events  Server-Sent Events stream of the conversation
+	- GET  /v1/actions                    the actions the role of the channel can run
+
+	Every request must carry the configured bearer token, the channel does not
+	start without one unless NoAuth is set. The events of a
+	conversation are kept for a while so a client subscribing after posting a
+	message, or reconnecting with Last-Event-ID, does not miss the replies.
+
+	The messages are answered right away, they are queued and dispatched to the
+	agent in the order they were posted.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	"github.com/a13labs/cobot/internal/metrics"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/cors"
+)
+
+const ChannelName = "http"
+
+const (
+	// Number of events kept per conversation
+	maxBacklog = 100
+	// Conversations idle for longer, without subscribers, are dropped
+	conversationTTL = time.Hour
+	// Time given to the requests in flight when the channel stops
+	shutdownTimeout = 5 * time.Second
+	// Largest message body accepted
+	maxBodySize = 64 * 1024
+	// Messages waiting for the agent, the next ones are refused
+	messageQueueSize = 100
+	// DefaultMaxConversations is used when Options.MaxConversations is 0
+	DefaultMaxConversations = 1000
+)
+
+var (
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrNoToken              = errors.New("no token configured, set NoAuth to serve the API without authentication")
+	ErrTooManyConversations = errors.New("too many conversations")
+)
+
+// Options configures the HTTP channel
+type Options struct {
+	// Listen is the address the server listens on, e.g. 127.0.0.1:8080
+	Listen string
+	// Token is the bearer token required by every request
+	Token string
+	// NoAuth serves the API without authentication when no token is given,
+	// every request is refused otherwise
+	NoAuth bool
+	// CORSOrigins are the origins allowed to call the API from a browser
+	CORSOrigins []string
+	// Role is given to the users of the channel, they are granted nothing
+	// when it is empty
+	Role string
+	// MaxConversations is the number of conversations kept at once, the new
+	// ones are refused until the idle ones expire
+	MaxConversations int
+}
+
+// ActionInfo describes an action of the catalog
+type ActionInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Args        []string `json:"args"`
+}
+
+type messageRequest struct {
+	Text         string `json:"text"`
+	Conversation string `json:"conversation,omitempty"`
+}
+
+type messageResponse struct {
+	Conversation string `json:"conversation"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type conversationEvent struct {
+	id    int
+	event agent.Event
+}
+
+type conversation struct {
+	events      []conversationEvent
+	nextId      int
+	subscribers map[chan conversationEvent]struct{}
+	lastUsed    time.Time
+}
+
+type HttpChannel struct {
+	options       Options
+	agentCtx      *agent.AgentCtx
+	mu            sync.Mutex
+	conversations map[string]*conversation
+	queue         chan agent.Message
+	queueClosed   bool
+	dispatchOnce  sync.Once
+}
+
+func New(options Options) *HttpChannel {
+	if options.MaxConversations <= 0 {
+		options.MaxConversations = DefaultMaxConversations
+	}
+	return &HttpChannel{
+		options:       options,
+		conversations: map[string]*conversation{},
+		queue:         make(chan agent.Message, messageQueueSize),
+	}
+}
+
+func (c *HttpChannel) Name() string {
+	return ChannelName
+}
+
+func (c *HttpChannel) Capabilities() agent.ChannelCapabilities {
+	return agent.ChannelCapabilities{}
+}
+
+// Send publishes a reply to the conversation subscribers
+func (c *HttpChannel) Send(conversation string, text string) error {
+	return c.SendEvent(conversation, agent.Event{Type: agent.EventReply, Text: text})
+}
+
+// SendEvent publishes an event to the conversation subscribers
+func (c *HttpChannel) SendEvent(id string, event agent.Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conv, ok := c.conversations[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrConversationNotFound, id)
+	}
+
+	conv.nextId++
+	e := conversationEvent{id: conv.nextId, event: event}
+	conv.events = append(conv.events, e)
+	if len(conv.events) > maxBacklog {
+		conv.events = conv.events[len(conv.events)-maxBacklog:]
+	}
+	conv.lastUsed = time.Now()
+
+	for subscriber := range conv.subscribers {
+		select {
+		case subscriber <- e:
+		default:
+			// A slow subscriber gets the event from the backlog when it reconnects
+		}
+	}
+	return nil
+}
+
+// Start serves the API until the context is done
+func (c *HttpChannel) Start(ctx context.Context, agentCtx *agent.AgentCtx) error {
+
+	logger := agent.GetLogger()
+
+	if c.options.Token == "" {
+		if !c.options.NoAuth {
+			return ErrNoToken
+		}
+		logger.Warning("HTTP channel started without a token, the API is not authenticated")
+	}
+
+	server := &http.Server{
+		Addr:    c.options.Listen,
+		Handler: c.Handler(agentCtx),
+		// The event streams end with the channel
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	// No message is dispatched once the channel stops
+	defer c.closeQueue()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	logger.Info("HTTP channel listening on %s", c.options.Listen)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+// Handler returns the API handler dispatching the messages to the given agent,
+// the agent given last receives all the messages
+func (c *HttpChannel) Handler(agentCtx *agent.AgentCtx) http.Handler {
+	c.mu.Lock()
+	c.agentCtx = agentCtx
+	c.mu.Unlock()
+
+	c.dispatchOnce.Do(func() {
+		go func() {
+			for msg := range c.queue {
+				c.agent().DispatchMessage(msg)
+			}
+		}()
+		metrics.RegisterQueue(ChannelName, func() int { return len(c.queue) })
+	})
+
+	r := chi.NewRouter()
+	r.Use(c.authenticate)
+	r.Post("/v1/messages", c.postMessage)
+	r.Get("/v1/conversations/{id}/events", c.getEvents)
+	r.Get("/v1/actions", c.getActions)
+
+	if len(c.options.CORSOrigins) == 0 {
+		return r
+	}
+	return cors.New(cors.Options{
+		AllowedOrigins: c.options.CORSOrigins,
+		AllowedMethods: []string{http.MethodGet, http.MethodPost},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "Last-Event-ID"},
+	}).Handler(r)
+}
+
+// agent returns the agent the messages are dispatched to
+func (c *HttpChannel) agent() *agent.AgentCtx {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.agentCtx
+}
+
+// enqueue queues a message for the agent, false is returned when the queue is
+// full or the channel stopped
+func (c *HttpChannel) enqueue(msg agent.Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.queueClosed {
+		return false
+	}
+	select {
+	case c.queue <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeQueue ends the dispatch of the messages
+func (c *HttpChannel) closeQueue() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.queueClosed {
+		c.queueClosed = true
+		close(c.queue)
+	}
+}
+
+func (c *HttpChannel) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.options.Token != "" || !c.options.NoAuth {
+			token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || c.options.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(c.options.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="cobot"`)
+				writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid or missing token"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *HttpChannel) postMessage(w http.ResponseWriter, r *http.Request) {
+
+	var req messageRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid message"})
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "text is required"})
+		return
+	}
+
+	id := req.Conversation
+	if id == "" {
+		var err error
+		id, err = c.newConversation()
+		if errors.Is(err, ErrTooManyConversations) {
+			writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "error creating conversation"})
+			return
+		}
+	} else if !c.touchConversation(id) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: ErrConversationNotFound.Error()})
+		return
+	}
+
+	msg := agent.Message{
+		Channel:      ChannelName,
+		Conversation: id,
+		User:         ChannelName,
+		Role:         c.options.Role,
+		Text:         req.Text,
+	}
+	// The client follows the events of the conversation
+	if !c.enqueue(msg) {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: "message queue is full"})
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, messageResponse{Conversation: id})
+}
+
+func (c *HttpChannel) getEvents(w http.ResponseWriter, r *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "streaming not supported"})
+		return
+	}
+
+	lastId, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	backlog, events, ok := c.subscribe(chi.URLParam(r, "id"), lastId)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: ErrConversationNotFound.Error()})
+		return
+	}
+	defer c.unsubscribe(chi.URLParam(r, "id"), events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, e := range backlog {
+		writeEvent(w, e)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case e := <-events:
+			writeEvent(w, e)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// getActions lists the actions the role of the channel can run
+func (c *HttpChannel) getActions(w http.ResponseWriter, r *http.Request) {
+	actions := []ActionInfo{}
+	if actionDB := c.agent().Actions(); actionDB != nil {
+		for _, name := range actionDB.AllowedActions(c.options.Role) {
+			action, err := actionDB.GetAction(name)
+			if err != nil {
+				continue
+			}
+			args := []string(action.Args)
+			if args == nil {
+				args = []string{}
+			}
+			actions = append(actions, ActionInfo{Name: name, Description: action.Description, Args: args})
+		}
+	}
+	writeJSON(w, http.StatusOK, actions)
+}
+
+func (c *HttpChannel) newConversation() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Drop the conversations nobody follows anymore
+	for key, conv := range c.conversations {
+		if len(conv.subscribers) == 0 && time.Since(conv.lastUsed) > conversationTTL {
+			delete(c.conversations, key)
+		}
+	}
+	if len(c.conversations) >= c.options.MaxConversations {
+		return "", ErrTooManyConversations
+	}
+
+	c.conversations[id] = &conversation{
+		subscribers: map[chan conversationEvent]struct{}{},
+		lastUsed:    time.Now(),
+	}
+	return id, nil
+}
+
+func (c *HttpChannel) touchConversation(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conv, ok := c.conversations[id]
+	if ok {
+		conv.lastUsed = time.Now()
+	}
+	return ok
+}
+
+// subscribe returns the events newer than lastId and a channel receiving the
+// next ones
+func (c *HttpChannel) subscribe(id string, lastId int) ([]conversationEvent, chan conversationEvent, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conv, ok := c.conversations[id]
+	if !ok {
+		return nil, nil, false
+	}
+
+	var backlog []conversationEvent
+	for _, e := range conv.events {
+		if e.id > lastId {
+			backlog = append(backlog, e)
+		}
+	}
+
+	events := make(chan conversationEvent, maxBacklog)
+	conv.subscribers[events] = struct{}{}
+	return backlog, events, true
+}
+
+func (c *HttpChannel) unsubscribe(id string, events chan conversationEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conv, ok := c.conversations[id]; ok {
+		delete(conv.subscribers, events)
+		conv.lastUsed = time.Now()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e conversationEvent) {
+	data, err := json.Marshal(e.event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.id, e.event.Type, data)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
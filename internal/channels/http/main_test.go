@@ -0,0 +1,281 @@
+package httpChannel_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	httpChannel "github.com/a13labs/cobot/internal/channels/http"
+)
+
+const token = "test-token"
+
+// newTestServer returns a server whose agent echoes every message it receives,
+// the token is added to the given options
+func newTestServer(t *testing.T, options httpChannel.Options) (*httptest.Server, *httpChannel.HttpChannel) {
+	s := agent.NewMemStorageFromMap(map[string]string{
+		"agent-config.yaml":    "agent:\n  name: tester\nactions:\n  - wake_up\n  - reboot\n",
+		"actions/wake_up.yaml": "description: wake up a computer\nname: wake_up\nargs:\n  - computer\nexec:\n  plugin: shell\n",
+		"actions/reboot.yaml":  "description: reboot the server\nname: reboot\nexec:\n  plugin: shell\n  parameters:\n    privileged: true\n",
+	})
+	cfg, err := agent.LoadAgentConfig(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := agent.NewActionDB(cfg, s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options.Token = token
+	channel := httpChannel.New(options)
+	agentCtx := &agent.AgentCtx{
+		Storage:      s,
+		AgentCfg:     cfg,
+		ActionDB:     db,
+		InputChannel: make(chan agent.Message),
+	}
+	go func() {
+		for msg := range agentCtx.InputChannel {
+			channel.SendEvent(msg.Conversation, agent.Event{Type: agent.EventActionStarted, Action: "echo"})
+			channel.Send(msg.Conversation, "echo: "+msg.Text)
+		}
+	}()
+
+	server := httptest.NewServer(channel.Handler(agentCtx))
+	t.Cleanup(func() {
+		server.Close()
+		close(agentCtx.InputChannel)
+	})
+	return server, channel
+}
+
+func request(t *testing.T, method, url, body string, auth bool) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestAuthentication(t *testing.T) {
+	server, _ := newTestServer(t, httpChannel.Options{Role: agent.RoleOperator})
+
+	resp := request(t, http.MethodGet, server.URL+"/v1/actions", "", false)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d; want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/v1/actions", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d; want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestNoTokenFailsClosed(t *testing.T) {
+	channel := httpChannel.New(httpChannel.Options{Listen: "127.0.0.1:0"})
+	if err := channel.Start(context.Background(), &agent.AgentCtx{}); !errors.Is(err, httpChannel.ErrNoToken) {
+		t.Errorf("Start() error = %v; want ErrNoToken", err)
+	}
+
+	server := httptest.NewServer(channel.Handler(&agent.AgentCtx{}))
+	defer server.Close()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/v1/actions", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d without a token configured; want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestActions(t *testing.T) {
+	server, _ := newTestServer(t, httpChannel.Options{Role: agent.RoleOperator})
+
+	resp := request(t, http.MethodGet, server.URL+"/v1/actions", "", true)
+	defer resp.Body.Close()
+
+	var actions []httpChannel.ActionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&actions); err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || actions[0].Name != "wake_up" || actions[0].Args[0] != "computer" {
+		t.Errorf("actions = %+v; want the actions an operator can run", actions)
+	}
+
+	// A viewer can run no action
+	server, _ = newTestServer(t, httpChannel.Options{Role: agent.RoleViewer})
+	resp = request(t, http.MethodGet, server.URL+"/v1/actions", "", true)
+	defer resp.Body.Close()
+	actions = nil
+	if err := json.NewDecoder(resp.Body).Decode(&actions); err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 0 {
+		t.Errorf("actions = %+v; want none for a viewer", actions)
+	}
+}
+
+func TestMaxConversations(t *testing.T) {
+	server, _ := newTestServer(t, httpChannel.Options{Role: agent.RoleOperator, MaxConversations: 2})
+
+	var conversation string
+	for i := 0; i < 2; i++ {
+		resp := request(t, http.MethodPost, server.URL+"/v1/messages", `{"text":"hello"}`, true)
+		var posted struct {
+			Conversation string `json:"conversation"`
+		}
+		json.NewDecoder(resp.Body).Decode(&posted)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("status = %d; want %d", resp.StatusCode, http.StatusAccepted)
+		}
+		conversation = posted.Conversation
+	}
+
+	resp := request(t, http.MethodPost, server.URL+"/v1/messages", `{"text":"hello"}`, true)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d for a new conversation over the limit; want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	// The existing conversations are still served
+	resp = request(t, http.MethodPost, server.URL+"/v1/messages", `{"text":"hello","conversation":"`+conversation+`"}`, true)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("status = %d in an existing conversation; want %d", resp.StatusCode, http.StatusAccepted)
+	}
+}
+
+func TestMessageAndEvents(t *testing.T) {
+	server, _ := newTestServer(t, httpChannel.Options{Role: agent.RoleOperator})
+
+	resp := request(t, http.MethodPost, server.URL+"/v1/messages", `{"text":"wake up fedora"}`, true)
+	var posted struct {
+		Conversation string `json:"conversation"`
+	}
+	json.NewDecoder(resp.Body).Decode(&posted)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || posted.Conversation == "" {
+		t.Fatalf("POST /v1/messages = %d, %+v", resp.StatusCode, posted)
+	}
+
+	// Events sent before the subscription are replayed
+	events := request(t, http.MethodGet, server.URL+"/v1/conversations/"+posted.Conversation+"/events", "", true)
+	defer events.Body.Close()
+	if ct := events.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	received := make(chan agent.Event)
+	go func() {
+		scanner := bufio.NewScanner(events.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				var event agent.Event
+				json.Unmarshal([]byte(data), &event)
+				received <- event
+			}
+		}
+	}()
+
+	next := func() agent.Event {
+		select {
+		case event := <-received:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for an event")
+		}
+		return agent.Event{}
+	}
+
+	if event := next(); event.Type != agent.EventActionStarted {
+		t.Errorf("first event = %+v", event)
+	}
+	if event := next(); event.Type != agent.EventReply || event.Text != "echo: wake up fedora" {
+		t.Errorf("second event = %+v", event)
+	}
+
+	// Continue the conversation while subscribed
+	resp = request(t, http.MethodPost, server.URL+"/v1/messages", `{"text":"again","conversation":"`+posted.Conversation+`"}`, true)
+	resp.Body.Close()
+	next()
+	if event := next(); event.Text != "echo: again" {
+		t.Errorf("reply = %+v", event)
+	}
+
+	resp = request(t, http.MethodPost, server.URL+"/v1/messages", `{"text":"hi","conversation":"unknown"}`, true)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown conversation status = %d", resp.StatusCode)
+	}
+	resp = request(t, http.MethodPost, server.URL+"/v1/messages", `{"text":" "}`, true)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("empty message status = %d", resp.StatusCode)
+	}
+}
+
+func TestMessagesAreDispatchedInOrder(t *testing.T) {
+	server, _ := newTestServer(t, httpChannel.Options{Role: agent.RoleOperator})
+
+	resp := request(t, http.MethodPost, server.URL+"/v1/messages", `{"text":"0"}`, true)
+	var posted struct {
+		Conversation string `json:"conversation"`
+	}
+	json.NewDecoder(resp.Body).Decode(&posted)
+	resp.Body.Close()
+	for i := 1; i < 20; i++ {
+		resp := request(t, http.MethodPost, server.URL+"/v1/messages", `{"text":"`+strconv.Itoa(i)+`","conversation":"`+posted.Conversation+`"}`, true)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("POST /v1/messages = %d", resp.StatusCode)
+		}
+	}
+
+	events := request(t, http.MethodGet, server.URL+"/v1/conversations/"+posted.Conversation+"/events", "", true)
+	defer events.Body.Close()
+	scanner := bufio.NewScanner(events.Body)
+	for want := 0; want < 20; {
+		if !scanner.Scan() {
+			t.Fatalf("events stream closed: %v", scanner.Err())
+		}
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event agent.Event
+		json.Unmarshal([]byte(data), &event)
+		if event.Type != agent.EventReply {
+			continue
+		}
+		if event.Text != "echo: "+strconv.Itoa(want) {
+			t.Fatalf("reply = %q; want the reply to message %d", event.Text, want)
+		}
+		want++
+	}
+}
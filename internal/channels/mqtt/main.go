@@ -0,0 +1,268 @@
+package mqttChannel
+
+/*
+	The MQTT channel puts the agent on a MQTT bus. By default the topics are
+	derived from the agent name:
+	- cobot/<agent>/command  commands for the agent, plain text or JSON
+	                         {"text":"...","conversation":"..."}
+	- cobot/<agent>/reply    replies, {"conversation":"...","text":"..."}
+	- cobot/<agent>/events   action events, {"conversation":"...","type":"...",...}
+	- cobot/<agent>/status   "online" or "offline", retained and used as LWT
+
+	The conversation is chosen by the publisher of the command, it is echoed in
+	the replies and events so several clients can share the topics.
+
+	MQTT does not tell who published a message, so the user is never taken from
+	the payload. The commands published on the command topic come from the
+	"mqtt" user, the ones published on cobot/<agent>/command/<user> come from
+	<user>; the broker ACLs must only allow each client its own topic.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const ChannelName = "mqtt"
+
+// Status payloads
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+// Conversation used by the commands not giving one
+const defaultConversation = "mqtt"
+
+// Commands waiting for the agent, the next ones are dropped
+const commandQueueSize = 100
+
+// Time allowed to the broker operations
+const operationTimeout = 10 * time.Second
+
+var ErrNotConnected = errors.New("not connected to the MQTT broker")
+
+// Options configures the MQTT channel, empty topics are derived from the agent
+// name
+type Options struct {
+	// Broker is the broker URL, e.g. tcp://localhost:1883
+	Broker   string
+	ClientId string
+	Username string
+	Password string
+	// QoS is used for every subscription and publication
+	QoS          byte
+	CommandTopic string
+	ReplyTopic   string
+	EventTopic   string
+	StatusTopic  string
+	// Role is given to the users of the channel, they are granted nothing
+	// when it is empty
+	Role string
+}
+
+// Command is the JSON form of a command
+type Command struct {
+	Text         string `json:"text"`
+	Conversation string `json:"conversation,omitempty"`
+}
+
+// ReplyPayload is published on the reply topic
+type ReplyPayload struct {
+	Conversation string `json:"conversation"`
+	Text         string `json:"text"`
+}
+
+// EventPayload is published on the event topic
+type EventPayload struct {
+	Conversation string `json:"conversation"`
+	agent.Event
+}
+
+type MqttChannel struct {
+	options Options
+	client  paho.Client
+}
+
+func New(options Options) *MqttChannel {
+	return &MqttChannel{options: options}
+}
+
+func (c *MqttChannel) Name() string {
+	return ChannelName
+}
+
+func (c *MqttChannel) Capabilities() agent.ChannelCapabilities {
+	return agent.ChannelCapabilities{}
+}
+
+func (c *MqttChannel) Send(conversation string, text string) error {
+	return c.publish(c.options.ReplyTopic, false, ReplyPayload{Conversation: conversation, Text: text})
+}
+
+func (c *MqttChannel) SendEvent(conversation string, event agent.Event) error {
+	return c.publish(c.options.EventTopic, false, EventPayload{Conversation: conversation, Event: event})
+}
+
+func (c *MqttChannel) publish(topic string, retained bool, payload interface{}) error {
+	if c.client == nil {
+		return ErrNotConnected
+	}
+
+	var data []byte
+	switch p := payload.(type) {
+	case string:
+		data = []byte(p)
+	default:
+		var err error
+		if data, err = json.Marshal(p); err != nil {
+			return err
+		}
+	}
+
+	token := c.client.Publish(topic, c.options.QoS, retained, data)
+	if !token.WaitTimeout(operationTimeout) {
+		return fmt.Errorf("timeout publishing to %s", topic)
+	}
+	return token.Error()
+}
+
+// Start connects to the broker and handles the commands until the context is
+// done
+func (c *MqttChannel) Start(ctx context.Context, agentCtx *agent.AgentCtx) error {
+
+	logger := agent.GetLogger()
+
+	prefix := "cobot/" + agentCtx.GetAgentName() + "/"
+	if c.options.CommandTopic == "" {
+		c.options.CommandTopic = prefix + "command"
+	}
+	if c.options.ReplyTopic == "" {
+		c.options.ReplyTopic = prefix + "reply"
+	}
+	if c.options.EventTopic == "" {
+		c.options.EventTopic = prefix + "events"
+	}
+	if c.options.StatusTopic == "" {
+		c.options.StatusTopic = prefix + "status"
+	}
+	if c.options.ClientId == "" {
+		c.options.ClientId = "cobot-" + agentCtx.GetAgentName()
+	}
+
+	// The handler must not block the client, the commands are queued and
+	// dispatched in order
+	commands := make(chan agent.Message, commandQueueSize)
+	handler := func(client paho.Client, m paho.Message) {
+		user, ok := c.topicUser(m.Topic())
+		if !ok {
+			logger.Warning("Ignoring MQTT command on %s, no user", m.Topic())
+			return
+		}
+		msg, ok := parseCommand(user, m.Payload())
+		msg.Role = c.options.Role
+		if !ok {
+			logger.Warning("Ignoring invalid MQTT command on %s", m.Topic())
+			return
+		}
+		select {
+		case commands <- msg:
+		default:
+			logger.Warning("MQTT command queue is full, dropping command")
+		}
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(c.options.Broker).
+		SetClientID(c.options.ClientId).
+		SetUsername(c.options.Username).
+		SetPassword(c.options.Password).
+		SetCleanSession(true).
+		SetAutoReconnect(true).
+		SetWill(c.options.StatusTopic, StatusOffline, c.options.QoS, true).
+		SetOnConnectHandler(func(client paho.Client) {
+			// Subscriptions are lost on reconnection, they are renewed
+			topics := map[string]byte{
+				c.options.CommandTopic:        c.options.QoS,
+				c.options.CommandTopic + "/+": c.options.QoS,
+			}
+			if token := client.SubscribeMultiple(topics, handler); token.WaitTimeout(operationTimeout) && token.Error() != nil {
+				logger.Error("Error subscribing to %s: %s", c.options.CommandTopic, token.Error())
+			}
+			if err := c.publish(c.options.StatusTopic, true, StatusOnline); err != nil {
+				logger.Error("Error publishing MQTT status: %s", err)
+			}
+			logger.Info("MQTT channel connected to %s, listening on %s", c.options.Broker, c.options.CommandTopic)
+		}).
+		SetConnectionLostHandler(func(client paho.Client, err error) {
+			logger.Warning("MQTT connection lost: %s", err)
+		})
+
+	c.client = paho.NewClient(opts)
+	token := c.client.Connect()
+	if !token.WaitTimeout(operationTimeout) {
+		return fmt.Errorf("timeout connecting to %s", c.options.Broker)
+	}
+	if err := token.Error(); err != nil {
+		return err
+	}
+
+	for done := false; !done; {
+		select {
+		case msg := <-commands:
+			agentCtx.DispatchMessage(msg)
+		case <-ctx.Done():
+			done = true
+		}
+	}
+
+	// A clean disconnection does not trigger the will, the status is updated
+	if err := c.publish(c.options.StatusTopic, true, StatusOffline); err != nil {
+		logger.Error("Error publishing MQTT status: %s", err)
+	}
+	c.client.Unsubscribe(c.options.CommandTopic, c.options.CommandTopic+"/+").WaitTimeout(operationTimeout)
+	c.client.Disconnect(uint(operationTimeout / time.Millisecond))
+	return nil
+}
+
+// topicUser returns the user a command topic belongs to
+func (c *MqttChannel) topicUser(topic string) (string, bool) {
+	if topic == c.options.CommandTopic {
+		return ChannelName, true
+	}
+	user, found := strings.CutPrefix(topic, c.options.CommandTopic+"/")
+	return user, found && user != ""
+}
+
+// parseCommand reads a command of the given user, either JSON or plain text
+func parseCommand(user string, payload []byte) (agent.Message, bool) {
+	msg := agent.Message{
+		Channel:      ChannelName,
+		Conversation: defaultConversation,
+		User:         user,
+	}
+
+	text := strings.TrimSpace(string(payload))
+	if strings.HasPrefix(text, "{") {
+		var cmd Command
+		if err := json.Unmarshal(payload, &cmd); err != nil {
+			return msg, false
+		}
+		text = strings.TrimSpace(cmd.Text)
+		if cmd.Conversation != "" {
+			msg.Conversation = cmd.Conversation
+		}
+	}
+	if text == "" {
+		return msg, false
+	}
+	msg.Text = text
+	return msg, true
+}
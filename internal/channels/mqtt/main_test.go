@@ -0,0 +1,145 @@
+package mqttChannel_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	mqttChannel "github.com/a13labs/cobot/internal/channels/mqtt"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// startBroker starts an embedded broker and returns its URL
+func startBroker(t *testing.T) string {
+	server := mqtt.New(nil)
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	tcp := listeners.NewTCP("test", "127.0.0.1:0", nil)
+	if err := server.AddListener(tcp); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return "tcp://" + tcp.Address()
+}
+
+func connect(t *testing.T, broker string, id string) paho.Client {
+	client := paho.NewClient(paho.NewClientOptions().AddBroker(broker).SetClientID(id))
+	if token := client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connecting to %s: %v", broker, token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(100) })
+	return client
+}
+
+func subscribe(t *testing.T, client paho.Client, topic string) chan string {
+	messages := make(chan string, 10)
+	token := client.Subscribe(topic, 1, func(c paho.Client, m paho.Message) {
+		messages <- string(m.Payload())
+	})
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("subscribing to %s: %v", topic, token.Error())
+	}
+	return messages
+}
+
+func next(t *testing.T, messages chan string) string {
+	select {
+	case m := <-messages:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for a MQTT message")
+	}
+	return ""
+}
+
+func TestCommandsRepliesAndStatus(t *testing.T) {
+	broker := startBroker(t)
+
+	channel := mqttChannel.New(mqttChannel.Options{Broker: broker, QoS: 1})
+	agentCtx := &agent.AgentCtx{
+		AgentCfg:     agent.AgentConfigFile{},
+		InputChannel: make(chan agent.Message),
+	}
+	agentCtx.AgentCfg.Agent.Name = "lab"
+	if err := agentCtx.AddChannel(channel); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan agent.Message, 10)
+	go func() {
+		for msg := range agentCtx.InputChannel {
+			received <- msg
+			channel.SendEvent(msg.Conversation, agent.Event{Type: agent.EventActionStarted, Action: "wake_up"})
+			agentCtx.Deliver(agent.Reply{Channel: msg.Channel, Conversation: msg.Conversation, Text: "echo: " + msg.Text})
+		}
+	}()
+	defer close(agentCtx.InputChannel)
+
+	client := connect(t, broker, "test-client")
+	status := subscribe(t, client, "cobot/lab/status")
+	replies := subscribe(t, client, "cobot/lab/reply")
+	events := subscribe(t, client, "cobot/lab/events")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- channel.Start(ctx, agentCtx) }()
+
+	if s := next(t, status); s != mqttChannel.StatusOnline {
+		t.Errorf("status = %q; want online", s)
+	}
+
+	client.Publish("cobot/lab/command", 1, false, "wake up fedora").Wait()
+	msg := <-received
+	if msg.Channel != "mqtt" || msg.Conversation != "mqtt" || msg.Text != "wake up fedora" {
+		t.Errorf("message = %+v", msg)
+	}
+	var event mqttChannel.EventPayload
+	json.Unmarshal([]byte(next(t, events)), &event)
+	if event.Type != agent.EventActionStarted || event.Action != "wake_up" || event.Conversation != "mqtt" {
+		t.Errorf("event = %+v", event)
+	}
+	var reply mqttChannel.ReplyPayload
+	json.Unmarshal([]byte(next(t, replies)), &reply)
+	if reply.Conversation != "mqtt" || reply.Text != "echo: wake up fedora" {
+		t.Errorf("reply = %+v", reply)
+	}
+
+	// The user is taken from the topic, never from the payload
+	client.Publish("cobot/lab/command", 1, false, `{"text":"stop","user":"alice"}`).Wait()
+	msg = <-received
+	if msg.User != "mqtt" {
+		t.Errorf("user = %q; want the payload user ignored", msg.User)
+	}
+	next(t, replies)
+	client.Publish("cobot/lab/command/alice", 1, false, `{"text":"restart nginx","conversation":"dashboard","user":"bob"}`).Wait()
+	msg = <-received
+	if msg.Conversation != "dashboard" || msg.User != "alice" || msg.Text != "restart nginx" {
+		t.Errorf("message = %+v", msg)
+	}
+	json.Unmarshal([]byte(next(t, replies)), &reply)
+	if reply.Conversation != "dashboard" {
+		t.Errorf("reply = %+v", reply)
+	}
+
+	cancel()
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	if s := next(t, status); s != mqttChannel.StatusOffline {
+		t.Errorf("status = %q; want offline", s)
+	}
+
+	// The status is retained for the late subscribers
+	late := connect(t, broker, "late-client")
+	if s := next(t, subscribe(t, late, "cobot/lab/status")); s != mqttChannel.StatusOffline {
+		t.Errorf("retained status = %q; want offline", s)
+	}
+}
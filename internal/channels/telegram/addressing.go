@@ -0,0 +1,172 @@
+package telegramChannel
+
+/*
+	Several agents can share a Telegram group, a message is handled when it is
+	addressed to the agent:
+	- it starts with @<agent name> or @<bot username>, the prefix is removed
+	- it starts with @all, every agent handles it and answers the broadcast
+	  with a single message holding all its replies
+	- it answers a message of the bot
+	- it is a command suffixed with the bot username, /audit@lab_bot
+	The /agents command is answered by every agent online in the group.
+
+	The conversation of a broadcast is "<chat id>:<message id>", the replies
+	sent to it are buffered until the agent is done with the message. The
+	broadcasts are dispatched in order with the other messages, their replies
+	are collected in their own goroutine so the next messages are not held up.
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/a13labs/cobot/internal/agent"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const broadcastName = "all"
+const agentsCommand = "/agents"
+
+// isAlias returns true when the name designates the agent, the agent name and
+// the bot username are accepted
+func (c *TelegramChannel) isAlias(agentName string, name string) bool {
+	return strings.EqualFold(name, agentName) || strings.EqualFold(name, c.bot.Self.UserName)
+}
+
+// repliesToBot returns true when the message answers a message of the bot
+func (c *TelegramChannel) repliesToBot(message *tgbotapi.Message) bool {
+	reply := message.ReplyToMessage
+	return reply != nil && reply.From != nil && reply.From.ID == c.bot.Self.ID
+}
+
+// addressedText returns the text of a message addressed to the agent, without
+// the address. ok is false when the message is for someone else.
+func (c *TelegramChannel) addressedText(agentName string, message *tgbotapi.Message, text string) (addressed string, broadcast bool, ok bool) {
+	text = strings.TrimSpace(text)
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", false, false
+	}
+	first := fields[0]
+	rest := strings.TrimSpace(strings.TrimPrefix(text, first))
+
+	switch {
+	case strings.HasPrefix(first, "@"):
+		name := strings.TrimRight(strings.TrimPrefix(first, "@"), ",:")
+		if rest == "" {
+			return "", false, false
+		}
+		if strings.EqualFold(name, broadcastName) {
+			return rest, true, true
+		}
+		return rest, false, c.isAlias(agentName, name)
+	case strings.HasPrefix(first, "/"):
+		command, target, found := strings.Cut(first, "@")
+		if found {
+			return strings.TrimSpace(command + " " + rest), false, c.isAlias(agentName, target)
+		}
+		return text, false, command == agentsCommand || c.repliesToBot(message)
+	default:
+		return text, false, c.repliesToBot(message)
+	}
+}
+
+func broadcastConversation(chatId int64, messageId int) string {
+	return fmt.Sprintf("%d:%d", chatId, messageId)
+}
+
+// parseBroadcast returns the chat and the message of a broadcast conversation
+func parseBroadcast(conversation string) (int64, int, bool) {
+	chat, message, found := strings.Cut(conversation, ":")
+	if !found {
+		return 0, 0, false
+	}
+	chatId, err := strconv.ParseInt(chat, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	messageId, err := strconv.Atoi(message)
+	if err != nil {
+		return 0, 0, false
+	}
+	return chatId, messageId, true
+}
+
+// buffered keeps the text when it is sent to a broadcast being handled
+func (c *TelegramChannel) buffered(conversation string, text string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	replies, ok := c.broadcasts[conversation]
+	if ok {
+		c.broadcasts[conversation] = append(replies, text)
+	}
+	return ok
+}
+
+// broadcast dispatches a message sent to all the agents, the replies are sent
+// in a single message answering it once the agent is done
+func (c *TelegramChannel) broadcast(agentCtx *agent.AgentCtx, msg agent.Message) {
+
+	chatId, messageId, ok := parseBroadcast(msg.Conversation)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	c.broadcasts[msg.Conversation] = []string{}
+	c.mu.Unlock()
+
+	result := make(chan error, 1)
+	msg.Result = result
+	agentCtx.DispatchMessage(msg)
+
+	go c.sendBroadcastReplies(agentCtx, msg, chatId, messageId, result)
+}
+
+// sendBroadcastReplies waits until the agent is done with a broadcast and sends
+// its replies
+func (c *TelegramChannel) sendBroadcastReplies(agentCtx *agent.AgentCtx, msg agent.Message, chatId int64, messageId int, result <-chan error) {
+
+	logger := agent.GetLogger()
+
+	err := <-result
+
+	c.mu.Lock()
+	replies := c.broadcasts[msg.Conversation]
+	delete(c.broadcasts, msg.Conversation)
+	c.mu.Unlock()
+
+	if len(replies) == 0 {
+		if err == nil {
+			return
+		}
+		replies = []string{agentCtx.Secrets.Redact(err.Error())}
+	}
+
+	text := agentCtx.GetAgentName() + ":\n" + strings.Join(replies, "\n")
+	for i, part := range agent.SplitMessage(text, maxMessageLength) {
+		reply := tgbotapi.NewMessage(chatId, part)
+		if i == 0 {
+			reply.ReplyToMessageID = messageId
+		}
+		if _, err := c.bot.Send(reply); err != nil {
+			logger.Error("Error sending the broadcast replies: %s", err)
+			return
+		}
+	}
+}
+
+// sendAgentStatus answers /agents, every agent of the group answers for itself
+func (c *TelegramChannel) sendAgentStatus(agentCtx *agent.AgentCtx, message *tgbotapi.Message) error {
+	actions := 0
+	if actionDB := agentCtx.Actions(); actionDB != nil {
+		actions = len(actionDB.ActionNames)
+	}
+	status := fmt.Sprintf("%s (@%s) is online, %d actions available", agentCtx.GetAgentName(), c.bot.Self.UserName, actions)
+
+	reply := tgbotapi.NewMessage(message.Chat.ID, status)
+	reply.ReplyToMessageID = message.MessageID
+	_, err := c.bot.Send(reply)
+	return err
+}
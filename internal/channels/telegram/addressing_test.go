@@ -0,0 +1,118 @@
+package telegramChannel_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	telegramChannel "github.com/a13labs/cobot/internal/channels/telegram"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestAddressing(t *testing.T) {
+	api := newFakeBotAPI(t)
+	_, agentCtx := startChannel(t, api, telegramChannel.Options{})
+
+	botMessage := &tgbotapi.Message{MessageID: 50, From: &tgbotapi.User{ID: 1000, UserName: "lab_bot", IsBot: true}}
+	otherMessage := &tgbotapi.Message{MessageID: 51, From: &tgbotapi.User{ID: 2000, UserName: "db_bot", IsBot: true}}
+
+	reply := func(text string, to *tgbotapi.Message) tgbotapi.Update {
+		update := textMessage(chatId, 1, "alice", text)
+		update.Message.ReplyToMessage = to
+		return update
+	}
+
+	for _, update := range []tgbotapi.Update{
+		textMessage(chatId, 1, "alice", "@db restart postgres"),
+		textMessage(chatId, 1, "alice", "restart nginx"),
+		reply("restart postgres", otherMessage),
+		textMessage(chatId, 1, "alice", "/audit@db_bot"),
+		textMessage(chatId, 1, "alice", "@lab"),
+		textMessage(chatId, 1, "alice", "@lab restart nginx"),
+		textMessage(chatId, 1, "alice", "@Lab_Bot: status"),
+		reply("uptime", botMessage),
+		textMessage(chatId, 1, "alice", "/audit@lab_bot 5"),
+	} {
+		api.Push(update)
+	}
+
+	for _, expected := range []string{"restart nginx", "status", "uptime", "/audit 5"} {
+		if msg := nextMessage(t, agentCtx); msg.Text != expected || msg.Conversation != "100" {
+			t.Errorf("expected %q, got %+v", expected, msg)
+		}
+	}
+	select {
+	case msg := <-agentCtx.InputChannel:
+		t.Errorf("unexpected message %q", msg.Text)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestBroadcastRepliesAreAggregated(t *testing.T) {
+	api := newFakeBotAPI(t)
+	_, agentCtx := startChannel(t, api, telegramChannel.Options{})
+	go func() {
+		for msg := range agentCtx.InputChannel {
+			agentCtx.Deliver(agent.Reply{Channel: msg.Channel, Conversation: msg.Conversation, Text: "running " + msg.Text})
+			agentCtx.Deliver(agent.Reply{Channel: msg.Channel, Conversation: msg.Conversation, Text: "done"})
+			msg.Result <- nil
+		}
+	}()
+
+	broadcast := textMessage(chatId, 1, "alice", "@all uptime")
+	broadcast.Message.MessageID = 42
+	api.Push(broadcast)
+
+	call := api.Expect("sendMessage")
+	if text := call.Params.Get("text"); text != "lab:\nrunning uptime\ndone" {
+		t.Errorf("unexpected reply %q", text)
+	}
+	if call.Params.Get("reply_to_message_id") != "42" {
+		t.Errorf("the reply does not answer the broadcast: %v", call.Params)
+	}
+}
+
+func TestBroadcastDoesNotHoldTheNextMessages(t *testing.T) {
+	api := newFakeBotAPI(t)
+	_, agentCtx := startChannel(t, api, telegramChannel.Options{})
+	release := make(chan struct{})
+	go func() {
+		for msg := range agentCtx.InputChannel {
+			go func(msg agent.Message) {
+				if msg.Conversation != "100" {
+					<-release
+				}
+				agentCtx.Deliver(agent.Reply{Channel: msg.Channel, Conversation: msg.Conversation, Text: "done " + msg.Text})
+				if msg.Result != nil {
+					msg.Result <- nil
+				}
+			}(msg)
+		}
+	}()
+
+	broadcast := textMessage(chatId, 1, "alice", "@all uptime")
+	broadcast.Message.MessageID = 42
+	api.Push(broadcast)
+	api.Push(textMessage(chatId, 1, "alice", "@lab status"))
+
+	if text := api.Expect("sendMessage").Params.Get("text"); text != "done status" {
+		t.Errorf("first reply = %q; want the reply to the message sent after the broadcast", text)
+	}
+	close(release)
+	if text := api.Expect("sendMessage").Params.Get("text"); text != "lab:\ndone uptime" {
+		t.Errorf("broadcast reply = %q", text)
+	}
+}
+
+func TestAgentsCommand(t *testing.T) {
+	api := newFakeBotAPI(t)
+	startChannel(t, api, telegramChannel.Options{})
+
+	api.Push(textMessage(chatId, 3, "mallory", "/agents"))
+	api.Push(textMessage(chatId, 1, "alice", "/agents"))
+
+	call := api.Expect("sendMessage")
+	if text := call.Params.Get("text"); text != "lab (@lab_bot) is online, 0 actions available" {
+		t.Errorf("unexpected status %q", text)
+	}
+}
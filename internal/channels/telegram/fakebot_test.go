@@ -0,0 +1,203 @@
+package telegramChannel_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const testToken = "123:test"
+
+// apiCall is a request received by the fake Bot API
+type apiCall struct {
+	Method string
+	Params url.Values
+	// Document is the content of the uploaded document, if any
+	Document []byte
+}
+
+// fakeBotAPI is a minimal Telegram Bot API server, the updates pushed are
+// returned by getUpdates and every other call is recorded
+type fakeBotAPI struct {
+	t             *testing.T
+	server        *httptest.Server
+	mu            sync.Mutex
+	updates       []tgbotapi.Update
+	nextUpdateId  int
+	nextMessageId int
+	calls         chan apiCall
+	files         map[string][]byte
+}
+
+func newFakeBotAPI(t *testing.T) *fakeBotAPI {
+	f := &fakeBotAPI{t: t, nextUpdateId: 1, nextMessageId: 1, calls: make(chan apiCall, 100), files: map[string][]byte{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// Client returns a client sending the Bot API requests to the fake server
+func (f *fakeBotAPI) Client() *http.Client {
+	target, _ := url.Parse(f.server.URL)
+	return &http.Client{Transport: rewriteTransport{target: target}}
+}
+
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (r rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = r.target.Scheme
+	req.URL.Host = r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// Push queues an update, its id is assigned when it is zero
+func (f *fakeBotAPI) Push(update tgbotapi.Update) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if update.UpdateID == 0 {
+		update.UpdateID = f.nextUpdateId
+	}
+	if update.UpdateID >= f.nextUpdateId {
+		f.nextUpdateId = update.UpdateID + 1
+	}
+	f.updates = append(f.updates, update)
+}
+
+// AddFile makes a file available for download, its path is the file id
+func (f *fakeBotAPI) AddFile(fileId string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[fileId] = data
+}
+
+// Expect waits for a call of the given method
+func (f *fakeBotAPI) Expect(method string) apiCall {
+	f.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case call := <-f.calls:
+			if call.Method == method {
+				return call
+			}
+		case <-timeout:
+			f.t.Fatalf("timeout waiting for %s", method)
+		}
+	}
+}
+
+func (f *fakeBotAPI) handle(w http.ResponseWriter, r *http.Request) {
+	if path, ok := strings.CutPrefix(r.URL.Path, "/file/bot"+testToken+"/"); ok {
+		f.mu.Lock()
+		data, found := f.files[path]
+		f.mu.Unlock()
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+		return
+	}
+
+	method := strings.TrimPrefix(r.URL.Path, "/bot"+testToken+"/")
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		r.ParseMultipartForm(32 << 20)
+	} else {
+		r.ParseForm()
+	}
+	params := r.Form
+
+	var document []byte
+	if r.MultipartForm != nil {
+		if headers := r.MultipartForm.File["document"]; len(headers) > 0 {
+			if file, err := headers[0].Open(); err == nil {
+				document, _ = io.ReadAll(file)
+				file.Close()
+			}
+		}
+	}
+
+	var result interface{}
+	switch method {
+	case "getMe":
+		result = tgbotapi.User{ID: 1000, FirstName: "lab", UserName: "lab_bot", IsBot: true}
+	case "getUpdates":
+		result = f.pendingUpdates(params)
+	case "answerCallbackQuery", "setWebhook", "deleteWebhook":
+		result = true
+	case "getWebhookInfo":
+		result = tgbotapi.WebhookInfo{}
+	case "getFile":
+		result = tgbotapi.File{FileID: params.Get("file_id"), FilePath: params.Get("file_id")}
+	default:
+		chatId, _ := strconv.ParseInt(params.Get("chat_id"), 10, 64)
+		f.mu.Lock()
+		messageId := f.nextMessageId
+		f.nextMessageId++
+		f.mu.Unlock()
+		if id, err := strconv.Atoi(params.Get("message_id")); err == nil {
+			messageId = id
+		}
+		result = tgbotapi.Message{MessageID: messageId, Chat: &tgbotapi.Chat{ID: chatId}, Text: params.Get("text")}
+	}
+
+	if method != "getUpdates" && method != "getMe" {
+		f.calls <- apiCall{Method: method, Params: params, Document: document}
+	}
+
+	data, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"ok":true,"result":%s}`, data)
+}
+
+func (f *fakeBotAPI) pendingUpdates(params url.Values) []tgbotapi.Update {
+	offset, _ := strconv.Atoi(params.Get("offset"))
+
+	// Short polling keeps the tests fast
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for {
+		f.mu.Lock()
+		var updates []tgbotapi.Update
+		for _, update := range f.updates {
+			if update.UpdateID >= offset {
+				updates = append(updates, update)
+			}
+		}
+		f.mu.Unlock()
+		if len(updates) > 0 || time.Now().After(deadline) {
+			return updates
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func textMessage(chatId int64, fromId int, username string, text string) tgbotapi.Update {
+	return tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: fromId, UserName: username},
+		Chat:      &tgbotapi.Chat{ID: chatId},
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+	}}
+}
+
+func callbackQuery(fromId int, username string, data string) tgbotapi.Update {
+	return tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   "cb-" + data + "-" + strconv.Itoa(fromId),
+		From: &tgbotapi.User{ID: fromId, UserName: username},
+		Data: data,
+	}}
+}
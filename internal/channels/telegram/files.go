@@ -0,0 +1,91 @@
+package telegramChannel
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/a13labs/cobot/internal/agent"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// SendFile sends a document to a chat, the conversation is the chat id
+func (c *TelegramChannel) SendFile(conversation string, name string, data []byte) error {
+	chatId, err := parseConversation(conversation)
+	if err != nil {
+		return err
+	}
+	_, err = c.bot.Send(tgbotapi.NewDocumentUpload(chatId, tgbotapi.FileBytes{Name: name, Bytes: data}))
+	return err
+}
+
+// hasFile tells whether a document or a photo is sent with a message
+func hasFile(message *tgbotapi.Message) bool {
+	return message.Document != nil || (message.Photo != nil && len(*message.Photo) > 0)
+}
+
+// receiveFiles saves the document or photo sent with a message, for a photo
+// the largest size is kept
+func (c *TelegramChannel) receiveFiles(agentCtx *agent.AgentCtx, msg agent.Message, message *tgbotapi.Message) ([]agent.Attachment, error) {
+
+	var fileId, name string
+	var size int
+	switch {
+	case message.Document != nil:
+		fileId, name, size = message.Document.FileID, message.Document.FileName, message.Document.FileSize
+	case message.Photo != nil && len(*message.Photo) > 0:
+		photos := *message.Photo
+		largest := photos[0]
+		for _, photo := range photos[1:] {
+			if photo.Width*photo.Height > largest.Width*largest.Height {
+				largest = photo
+			}
+		}
+		fileId, name, size = largest.FileID, "photo.jpg", largest.FileSize
+	default:
+		return nil, nil
+	}
+
+	if size > agent.MaxUploadSize {
+		return nil, agent.ErrUploadTooLarge
+	}
+	if name == "" {
+		name = fileId
+	}
+
+	data, err := c.download(fileId)
+	if err != nil {
+		return nil, err
+	}
+	attachment, err := agentCtx.SaveUpload(msg, name, data)
+	if err != nil {
+		return nil, err
+	}
+	return []agent.Attachment{attachment}, nil
+}
+
+// download fetches a file from the Bot API file server
+func (c *TelegramChannel) download(fileId string) ([]byte, error) {
+	url, err := c.bot.GetFileDirectURL(fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.options.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading file %s: %s", fileId, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, agent.MaxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > agent.MaxUploadSize {
+		return nil, agent.ErrUploadTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,84 @@
+package telegramChannel_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	telegramChannel "github.com/a13labs/cobot/internal/channels/telegram"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestLongReplySentAsDocument(t *testing.T) {
+	api := newFakeBotAPI(t)
+	_, agentCtx := startChannel(t, api, telegramChannel.Options{})
+	api.Expect("setWebhook") // the webhook is removed once the bot is connected
+
+	output := strings.Repeat("line of output\n", 500)
+	if err := agentCtx.Deliver(agent.Reply{Channel: telegramChannel.ChannelName, Conversation: "100", Text: output, FileName: "status-output.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	call := api.Expect("sendDocument")
+	if string(call.Document) != output {
+		t.Errorf("document content has %d bytes, expected %d", len(call.Document), len(output))
+	}
+}
+
+func TestDocumentReceivedAsAttachment(t *testing.T) {
+	api := newFakeBotAPI(t)
+	_, agentCtx := startChannel(t, api, telegramChannel.Options{})
+
+	api.AddFile("doc-1", []byte("key: value\n"))
+	update := textMessage(chatId, 1, "alice", "")
+	update.Message.Caption = "@lab apply this config"
+	update.Message.Document = &tgbotapi.Document{FileID: "doc-1", FileName: "../config.yaml", FileSize: 11}
+	api.Push(update)
+
+	select {
+	case msg := <-agentCtx.InputChannel:
+		if msg.Text != "apply this config" || msg.User != "alice" {
+			t.Errorf("unexpected message %+v", msg)
+		}
+		if len(msg.Attachments) != 1 {
+			t.Fatalf("expected one attachment, got %+v", msg.Attachments)
+		}
+		attachment := msg.Attachments[0]
+		if attachment.Name != "config.yaml" || !strings.HasPrefix(attachment.Path, agent.UploadsDir+"/telegram/100/") {
+			t.Errorf("unexpected attachment %+v", attachment)
+		}
+		data, err := agentCtx.Storage.ReadFile(attachment.Path)
+		if err != nil || string(data) != "key: value\n" {
+			t.Errorf("unexpected content %q: %v", data, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the message")
+	}
+}
+
+func TestFileFromViewerRefused(t *testing.T) {
+	api := newFakeBotAPI(t)
+	_, agentCtx := startChannel(t, api, telegramChannel.Options{})
+
+	api.AddFile("doc-1", []byte("key: value\n"))
+	update := textMessage(chatId, 3, "carol", "")
+	update.Message.Caption = "@lab apply this config"
+	update.Message.Document = &tgbotapi.Document{FileID: "doc-1", FileName: "config.yaml", FileSize: 11}
+	api.Push(update)
+
+	for {
+		call := api.Expect("sendMessage")
+		if text := call.Params.Get("text"); text == "You are not allowed to send files." {
+			break
+		}
+	}
+	select {
+	case msg := <-agentCtx.InputChannel:
+		t.Errorf("unexpected message %+v", msg)
+	default:
+	}
+	if _, err := agentCtx.Storage.Stat(agent.UploadsDir); err == nil {
+		t.Errorf("the file of a viewer was stored")
+	}
+}
@@ -0,0 +1,191 @@
+package telegramChannel
+
+/*
+	Prompts are sent with an inline keyboard, one button per option. The
+	callback data of a button is "prompt:<prompt id>:<option index>". Only the
+	user the prompt was asked to can answer it, once answered or expired the
+	message is edited to show the outcome and the keyboard is removed.
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/a13labs/cobot/internal/agent"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const callbackPrefix = "prompt:"
+
+// Keyboards with more options have a button per row
+const maxButtonsPerRow = 3
+
+type keyboardPrompt struct {
+	conversation string
+	chatId       int64
+	messageId    int
+	prompt       agent.Prompt
+	answeredBy   string
+}
+
+// SendPrompt sends the prompt with a button per option
+func (c *TelegramChannel) SendPrompt(conversation string, prompt agent.Prompt) error {
+	chatId, err := parseConversation(conversation)
+	if err != nil {
+		return err
+	}
+
+	message := tgbotapi.NewMessage(chatId, prompt.Text)
+	if len(prompt.Options) > 0 {
+		message.ReplyMarkup = newKeyboard(prompt)
+	}
+	sent, err := c.bot.Send(message)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.prompts[prompt.Id] = &keyboardPrompt{
+		conversation: conversation,
+		chatId:       chatId,
+		messageId:    sent.MessageID,
+		prompt:       prompt,
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+func newKeyboard(prompt agent.Prompt) tgbotapi.InlineKeyboardMarkup {
+	var buttons []tgbotapi.InlineKeyboardButton
+	for i, option := range prompt.Options {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(option, fmt.Sprintf("%s%s:%d", callbackPrefix, prompt.Id, i)))
+	}
+
+	if len(buttons) <= maxButtonsPerRow {
+		return tgbotapi.NewInlineKeyboardMarkup(buttons)
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, button := range buttons {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// ClosePrompt edits the prompt message to show the outcome, the keyboard is
+// removed
+func (c *TelegramChannel) ClosePrompt(conversation string, prompt agent.Prompt, answer string, err error) {
+
+	logger := agent.GetLogger()
+
+	c.mu.Lock()
+	kp, ok := c.prompts[prompt.Id]
+	delete(c.prompts, prompt.Id)
+	var answeredBy string
+	if ok {
+		answeredBy = kp.answeredBy
+	}
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	var outcome string
+	switch {
+	case err != nil:
+		outcome = fmt.Sprintf("⌛ %s", err)
+	case answeredBy != "":
+		outcome = fmt.Sprintf("✅ %s (%s)", answer, answeredBy)
+	default:
+		outcome = fmt.Sprintf("✅ %s", answer)
+	}
+
+	edit := tgbotapi.NewEditMessageText(kp.chatId, kp.messageId, prompt.Text+"\n\n"+outcome)
+	if _, err := c.bot.Send(edit); err != nil {
+		logger.Error("Error editing Telegram prompt: %s", err)
+	}
+}
+
+// handleCallback answers a prompt with the option of the button pressed
+func (c *TelegramChannel) handleCallback(agentCtx *agent.AgentCtx, query *tgbotapi.CallbackQuery) {
+
+	logger := agent.GetLogger()
+
+	id, index, err := parseCallbackData(query.Data)
+	if err != nil {
+		c.answerCallback(query.ID, "This button is not valid anymore", true)
+		return
+	}
+
+	c.mu.Lock()
+	kp, ok := c.prompts[id]
+	c.mu.Unlock()
+	if !ok || index >= len(kp.prompt.Options) {
+		// Expired, already answered or replayed
+		c.answerCallback(query.ID, "This request has expired", true)
+		return
+	}
+
+	if query.From == nil {
+		return
+	}
+	cfg := agentCtx.Config()
+	user, ok := cfg.FindTelegramUser(int64(query.From.ID), query.From.UserName)
+	if !ok {
+		msg := agent.Message{
+			Channel:      ChannelName,
+			Conversation: kp.conversation,
+			User:         agent.TelegramUserName(int64(query.From.ID), query.From.UserName),
+			Text:         kp.prompt.Options[index],
+		}
+		logger.ForMessage(msg).Warning("Refused answer from unknown Telegram user %s", msg.User)
+		agentCtx.AuditRefusal(msg, "", "unknown telegram user")
+		c.answerCallback(query.ID, "You are not allowed to answer", true)
+		return
+	}
+
+	// Set before answering, the prompt is closed as soon as it is answered
+	c.mu.Lock()
+	previous := kp.answeredBy
+	kp.answeredBy = user.Name
+	c.mu.Unlock()
+
+	answer := kp.prompt.Options[index]
+	if err := agentCtx.Answer(ChannelName, kp.conversation, user.Name, id, answer); err != nil {
+		c.mu.Lock()
+		kp.answeredBy = previous
+		c.mu.Unlock()
+		c.answerCallback(query.ID, "Only the user who made the request can answer", true)
+		return
+	}
+	c.answerCallback(query.ID, answer, false)
+}
+
+func (c *TelegramChannel) answerCallback(id string, text string, alert bool) {
+
+	logger := agent.GetLogger()
+
+	config := tgbotapi.NewCallback(id, text)
+	if alert {
+		config = tgbotapi.NewCallbackWithAlert(id, text)
+	}
+	if _, err := c.bot.AnswerCallbackQuery(config); err != nil {
+		logger.Error("Error answering Telegram callback: %s", err)
+	}
+}
+
+func parseCallbackData(data string) (string, int, error) {
+	rest, ok := strings.CutPrefix(data, callbackPrefix)
+	if !ok {
+		return "", 0, errInvalidCallback
+	}
+	id, indexStr, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", 0, errInvalidCallback
+	}
+	index, err := strconv.Atoi(indexStr)
+	if err != nil || index < 0 {
+		return "", 0, errInvalidCallback
+	}
+	return id, index, nil
+}
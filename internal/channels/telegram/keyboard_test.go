@@ -0,0 +1,145 @@
+package telegramChannel_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	telegramChannel "github.com/a13labs/cobot/internal/channels/telegram"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const chatId = 100
+
+// startChannel starts a channel on the fake Bot API with an empty storage
+func startChannel(t *testing.T, api *fakeBotAPI, options telegramChannel.Options) (*telegramChannel.TelegramChannel, *agent.AgentCtx) {
+	return startChannelWithStorage(t, api, options, agent.NewMemStorage())
+}
+
+// startChannelWithStorage starts a channel on the fake Bot API, the agent
+// knows the operators alice and bob and the viewer carol
+func startChannelWithStorage(t *testing.T, api *fakeBotAPI, options telegramChannel.Options, storage agent.Storage) (*telegramChannel.TelegramChannel, *agent.AgentCtx) {
+	options.Token = testToken
+	options.ChatId = chatId
+	options.Client = api.Client()
+	channel := telegramChannel.New(options)
+
+	agentCtx := &agent.AgentCtx{InputChannel: make(chan agent.Message), Storage: storage}
+	agentCtx.AgentCfg.Agent.Name = "lab"
+	agentCtx.AgentCfg.Users = []agent.UserDef{
+		{Name: "alice", Role: agent.RoleOperator, Telegram: agent.TelegramIdentity{Id: 1}},
+		{Name: "bob", Role: agent.RoleOperator, Telegram: agent.TelegramIdentity{Id: 2}},
+		{Name: "carol", Role: agent.RoleViewer, Telegram: agent.TelegramIdentity{Id: 3}},
+	}
+	if err := agentCtx.AddChannel(channel); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		channel.Start(ctx, agentCtx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	return channel, agentCtx
+}
+
+// askForEveryMessage makes the agent ask which action to run for every message
+func askForEveryMessage(agentCtx *agent.AgentCtx) {
+	go func() {
+		for msg := range agentCtx.InputChannel {
+			answer, err := agentCtx.Ask(msg, "Which action?", []string{"restart", "status", "cancel"})
+			if err != nil {
+				answer = err.Error()
+			}
+			agentCtx.Deliver(agent.Reply{Channel: msg.Channel, Conversation: msg.Conversation, Text: "chose " + answer})
+		}
+	}()
+}
+
+func promptCallbacks(t *testing.T, call apiCall) []string {
+	var markup tgbotapi.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(call.Params.Get("reply_markup")), &markup); err != nil {
+		t.Fatalf("invalid reply_markup %q: %v", call.Params.Get("reply_markup"), err)
+	}
+	var data []string
+	for _, row := range markup.InlineKeyboard {
+		for _, button := range row {
+			data = append(data, *button.CallbackData)
+		}
+	}
+	return data
+}
+
+func TestInlineKeyboardPrompt(t *testing.T) {
+	api := newFakeBotAPI(t)
+	_, agentCtx := startChannel(t, api, telegramChannel.Options{})
+	askForEveryMessage(agentCtx)
+
+	api.Push(textMessage(chatId, 1, "alice", "@lab restart nginx"))
+
+	prompt := api.Expect("sendMessage")
+	if prompt.Params.Get("text") != "Which action?" {
+		t.Fatalf("prompt text = %q", prompt.Params.Get("text"))
+	}
+	callbacks := promptCallbacks(t, prompt)
+	if len(callbacks) != 3 {
+		t.Fatalf("callbacks = %v", callbacks)
+	}
+
+	// Only the requester can answer
+	api.Push(callbackQuery(2, "bob", callbacks[0]))
+	refused := api.Expect("answerCallbackQuery")
+	if refused.Params.Get("show_alert") != "true" || !strings.Contains(refused.Params.Get("text"), "Only the user") {
+		t.Errorf("callback answer = %v", refused.Params)
+	}
+
+	api.Push(callbackQuery(1, "alice", callbacks[1]))
+	if answered := api.Expect("answerCallbackQuery"); answered.Params.Get("text") != "status" {
+		t.Errorf("callback answer = %v", answered.Params)
+	}
+	edit := api.Expect("editMessageText")
+	if edit.Params.Get("message_id") != "1" || !strings.Contains(edit.Params.Get("text"), "✅ status (alice)") {
+		t.Errorf("edited message = %v", edit.Params)
+	}
+	if edit.Params.Get("reply_markup") != "" {
+		t.Errorf("the keyboard was not removed: %v", edit.Params)
+	}
+	if reply := api.Expect("sendMessage"); reply.Params.Get("text") != "chose status" {
+		t.Errorf("reply = %q", reply.Params.Get("text"))
+	}
+
+	// Replayed callbacks are refused
+	api.Push(callbackQuery(1, "alice", callbacks[0]))
+	if replayed := api.Expect("answerCallbackQuery"); !strings.Contains(replayed.Params.Get("text"), "expired") {
+		t.Errorf("replayed callback answer = %v", replayed.Params)
+	}
+}
+
+func TestInlineKeyboardPromptExpires(t *testing.T) {
+	defer func(timeout time.Duration) { agent.PromptTimeout = timeout }(agent.PromptTimeout)
+	agent.PromptTimeout = 50 * time.Millisecond
+
+	api := newFakeBotAPI(t)
+	_, agentCtx := startChannel(t, api, telegramChannel.Options{})
+	askForEveryMessage(agentCtx)
+
+	api.Push(textMessage(chatId, 1, "alice", "@lab restart nginx"))
+	callbacks := promptCallbacks(t, api.Expect("sendMessage"))
+
+	if edit := api.Expect("editMessageText"); !strings.Contains(edit.Params.Get("text"), "⌛") {
+		t.Errorf("edited message = %v", edit.Params)
+	}
+
+	api.Push(callbackQuery(1, "alice", callbacks[0]))
+	if expired := api.Expect("answerCallbackQuery"); !strings.Contains(expired.Params.Get("text"), "expired") {
+		t.Errorf("expired callback answer = %v", expired.Params)
+	}
+}
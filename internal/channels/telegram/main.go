@@ -1,90 +1,296 @@
 package telegramChannel
 
 import (
-	"log"
-	"os"
-	"os/signal"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
-	"syscall"
+	"sync"
+	"time"
 
 	"github.com/a13labs/cobot/internal/agent"
+	"github.com/a13labs/cobot/internal/metrics"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
-var shutdownSignal = make(chan os.Signal, 1)
+const ChannelName = "telegram"
 
-// StartBot initializes and starts the Telegram bot with a channel listener.
-func Start(ctx *agent.AgentCtx, token string, chatId int64) {
+// Telegram refuses messages longer than 4096 characters
+const maxMessageLength = 4096
 
-	bot, err := tgbotapi.NewBotAPI(token)
+// Messages waiting for the agent, the next ones are dropped
+const messageQueueSize = 100
+
+// Options configures the Telegram channel
+type Options struct {
+	Token  string
+	ChatId int64
+	// Client is used to reach the Bot API, http.DefaultClient when nil
+	Client *http.Client
+	// WebhookURL enables the webhook mode, the updates are received on Listen
+	// instead of being polled
+	WebhookURL string
+	Listen     string
+	// WebhookSecret is the secret token Telegram must send with the updates,
+	// a random one is used when empty
+	WebhookSecret string
+	// MaxMessageAge is the age after which the messages are ignored,
+	// DefaultMaxMessageAge when zero
+	MaxMessageAge time.Duration
+}
+
+type TelegramChannel struct {
+	options Options
+	bot     *tgbotapi.BotAPI
+	queue   chan queuedMessage
+	mu      sync.Mutex
+	prompts map[string]*keyboardPrompt
+	// broadcasts buffers the replies of the broadcasts being handled
+	broadcasts map[string][]string
+	// lastUpdateId is only used by the update loop
+	lastUpdateId int
+}
+
+// New returns a channel listening to the configured chat, the bot is connected
+// when the channel starts.
+func New(options Options) *TelegramChannel {
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	if options.MaxMessageAge == 0 {
+		options.MaxMessageAge = DefaultMaxMessageAge
+	}
+	return &TelegramChannel{
+		options:    options,
+		queue:      make(chan queuedMessage, messageQueueSize),
+		prompts:    map[string]*keyboardPrompt{},
+		broadcasts: map[string][]string{},
+	}
+}
+
+func (c *TelegramChannel) Name() string {
+	return ChannelName
+}
+
+func (c *TelegramChannel) Capabilities() agent.ChannelCapabilities {
+	return agent.ChannelCapabilities{MaxMessageLength: maxMessageLength}
+}
+
+// Send sends a text to a chat, the conversation is the chat id
+func (c *TelegramChannel) Send(conversation string, text string) error {
+	if c.buffered(conversation, text) {
+		return nil
+	}
+	chatId, err := parseConversation(conversation)
 	if err != nil {
-		log.Fatalf("Error initializing Telegram bot: %v", err)
+		return err
 	}
+	_, err = c.bot.Send(tgbotapi.NewMessage(chatId, text))
+	return err
+}
 
-	log.Printf("Authorized on account %s", bot.Self.UserName)
+// parseConversation returns the chat of a conversation, broadcasts are sent
+// to the chat
+func parseConversation(conversation string) (int64, error) {
+	if chatId, _, ok := parseBroadcast(conversation); ok {
+		return chatId, nil
+	}
+	chatId, err := strconv.ParseInt(conversation, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid telegram conversation '%s'", conversation)
+	}
+	return chatId, nil
+}
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+// Start initializes the Telegram bot and listens to the chat until the context
+// is done.
+func (c *TelegramChannel) Start(ctx context.Context, agentCtx *agent.AgentCtx) error {
 
-	updates, err := bot.GetUpdatesChan(u)
+	logger := agent.GetLogger()
+
+	bot, err := tgbotapi.NewBotAPIWithClient(c.options.Token, c.options.Client)
 	if err != nil {
-		log.Fatalf("Error creating update channel: %v", err)
+		return err
 	}
+	c.bot = bot
 
-	// sets up a signal handler to listen for SIGQUIT and SIGINT.
-	signal.Notify(shutdownSignal, syscall.SIGQUIT)
-	signal.Notify(shutdownSignal, syscall.SIGINT)
+	logger.Info("Authorized on account %s", bot.Self.UserName)
 
-	ctx.SetWriterFunc(func(text string) error {
-		msg := tgbotapi.NewMessage(chatId, text)
-		bot.Send(msg)
-		return nil
-	})
+	c.lastUpdateId = c.loadOffset(agentCtx)
 
-	// Send a welcome message
-	ctx.SayHello()
+	var updates <-chan tgbotapi.Update
+	if c.options.WebhookURL != "" {
+		if updates, err = c.startWebhook(ctx); err != nil {
+			return err
+		}
+	} else {
+		// Telegram refuses to be polled while a webhook is registered
+		if _, err := bot.RemoveWebhook(); err != nil {
+			return err
+		}
 
-	// Listen for messages in the channel
-	for {
-		select {
-		case update := <-updates:
-			if update.Message == nil {
+		u := tgbotapi.NewUpdate(c.lastUpdateId + 1)
+		u.Timeout = 60
+
+		polled, err := bot.GetUpdatesChan(u)
+		if err != nil {
+			return err
+		}
+		defer bot.StopReceivingUpdates()
+		updates = polled
+	}
+
+	// The updates must still be received while the agent waits for an answer
+	// or a file is downloaded, the messages are queued and dispatched in order
+	go func() {
+		for queued := range c.queue {
+			msg, ok := c.receiveAttachments(agentCtx, queued)
+			if !ok {
 				continue
 			}
-
-			if update.Message.Chat.ID != chatId {
+			if _, _, ok := parseBroadcast(msg.Conversation); ok {
+				c.broadcast(agentCtx, msg)
 				continue
 			}
+			agentCtx.DispatchMessage(msg)
+		}
+	}()
+	defer close(c.queue)
+	metrics.RegisterQueue(ChannelName, func() int { return len(c.queue) })
 
-			// Handle messages from the specified channel
-			userInput := update.Message.Text
-			if strings.HasPrefix(userInput, "@") {
-				tokens := strings.Split(userInput, " ")
-				if len(tokens) > 0 {
-
-					// Convert the string to a rune slice
-					runes := []rune(tokens[0])
-
-					if len(runes) > 0 {
-						targetAgent := string(runes[1:])
-						if targetAgent == ctx.GetAgentName() {
-							if len(tokens) > 1 {
-								ctx.DispatchInput(userInput)
-							}
-						}
-					}
-				}
-			}
-		case <-shutdownSignal:
+	chat := agent.Message{Channel: ChannelName, Conversation: strconv.FormatInt(c.options.ChatId, 10)}
+
+	// Send a welcome message
+	agentCtx.SayHello(chat)
+
+	if len(agentCtx.Config().Users) == 0 {
+		logger.Warning("No users are declared in the agent configuration, all the Telegram messages will be refused")
+	}
+
+	// Listen for messages in the channel
+	for {
+		select {
+		case update := <-updates:
+			c.handleUpdate(agentCtx, update)
+		case <-ctx.Done():
 			// Send a goodbye message
-			goodbyeMsg, err := ctx.SayGoodBye()
+			goodbyeMsg, err := agentCtx.SayGoodBye()
 			if err != nil {
-				log.Fatal(err)
-				os.Exit(1)
+				logger.Error("Error generating the goodbye message: %s", err)
+				return nil
 			}
-			msg := tgbotapi.NewMessage(chatId, goodbyeMsg)
-			bot.Send(msg)
+			return c.Send(chat.Conversation, goodbyeMsg)
+		}
+	}
+}
+
+func (c *TelegramChannel) handleUpdate(agentCtx *agent.AgentCtx, update tgbotapi.Update) {
+	if !c.acceptUpdate(agentCtx, update) {
+		return
+	}
+
+	switch {
+	case update.CallbackQuery != nil:
+		c.handleCallback(agentCtx, update.CallbackQuery)
+	case update.Message != nil:
+		if update.Message.Chat.ID != c.options.ChatId {
 			return
 		}
+		c.handleMessage(agentCtx, update.Message)
 	}
 }
+
+// handleMessage dispatches the messages addressed to the agent, sent by a
+// declared user, without the address. Documents and photos are addressed to
+// the agent by their caption and saved as attachments of the message.
+func (c *TelegramChannel) handleMessage(agentCtx *agent.AgentCtx, message *tgbotapi.Message) {
+
+	logger := agent.GetLogger()
+
+	userInput := message.Text
+	if userInput == "" {
+		userInput = message.Caption
+	}
+	text, broadcast, ok := c.addressedText(agentCtx.GetAgentName(), message, userInput)
+	if !ok || message.From == nil {
+		return
+	}
+
+	msg := agent.Message{
+		Channel:      ChannelName,
+		Conversation: strconv.FormatInt(message.Chat.ID, 10),
+		Text:         text,
+	}
+	if broadcast {
+		msg.Conversation = broadcastConversation(message.Chat.ID, message.MessageID)
+	}
+
+	cfg := agentCtx.Config()
+	user, ok := cfg.FindTelegramUser(int64(message.From.ID), message.From.UserName)
+	if !ok {
+		msg.User = agent.TelegramUserName(int64(message.From.ID), message.From.UserName)
+		logger.ForMessage(msg).Warning("Refused message from unknown Telegram user %s", msg.User)
+		agentCtx.AuditRefusal(msg, "", "unknown telegram user")
+		return
+	}
+	msg.User = user.Name
+	if !agent.ValidRole(user.Role) {
+		logger.ForMessage(msg).Warning("Refused message from %s, the user has no valid role", msg.User)
+		agentCtx.AuditRefusal(msg, "", "user without a valid role")
+		return
+	}
+	msg.Role = user.Role
+
+	if strings.Fields(text)[0] == agentsCommand {
+		if err := c.sendAgentStatus(agentCtx, message); err != nil {
+			logger.Error("Error sending the agent status: %s", err)
+		}
+		return
+	}
+
+	// The files are stored in the storage, viewers can't run any action using them
+	if hasFile(message) && !agent.HasRole(msg.Role, agent.RoleOperator) {
+		logger.ForMessage(msg).Warning("Refused the file sent by %s, the user is not an %s", msg.User, agent.RoleOperator)
+		agentCtx.AuditRefusal(msg, "", "file sent by a user without the operator role")
+		if err := c.Send(msg.Conversation, "You are not allowed to send files."); err != nil {
+			logger.Error("Error sending message: %s", err)
+		}
+		return
+	}
+
+	select {
+	case c.queue <- queuedMessage{msg: msg, message: message}:
+	default:
+		logger.ForMessage(msg).Warning("Telegram message queue is full, dropping message from %s", msg.User)
+	}
+}
+
+// queuedMessage is a message waiting to be dispatched with the Telegram message
+// it comes from, its files are downloaded when it is dispatched
+type queuedMessage struct {
+	msg     agent.Message
+	message *tgbotapi.Message
+}
+
+// receiveAttachments downloads the files of a queued message, the user is told
+// when they could not be received and the message is dropped
+func (c *TelegramChannel) receiveAttachments(agentCtx *agent.AgentCtx, queued queuedMessage) (agent.Message, bool) {
+
+	logger := agent.GetLogger()
+
+	msg := queued.msg
+	attachments, err := c.receiveFiles(agentCtx, msg, queued.message)
+	if err != nil {
+		logger.ForMessage(msg).Error("Error receiving the file sent by %s: %s", msg.User, err)
+		if err := c.Send(msg.Conversation, fmt.Sprintf("The file could not be received: %s", err)); err != nil {
+			logger.Error("Error sending message: %s", err)
+		}
+		return msg, false
+	}
+	msg.Attachments = attachments
+	return msg, true
+}
+
+var errInvalidCallback = errors.New("invalid callback data")
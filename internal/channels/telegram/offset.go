@@ -0,0 +1,88 @@
+package telegramChannel
+
+/*
+	The id of the last update handled is kept in the storage so a restart does
+	not replay the updates already handled, an update is recorded before it is
+	handled: an action is never triggered twice by the same message. The updates
+	redelivered by Telegram are dropped by id and the messages older than
+	MaxMessageAge, left behind while the agent was stopped, are ignored.
+*/
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const offsetDir = "local/telegram"
+const offsetFile = offsetDir + "/offset"
+
+// DefaultMaxMessageAge is used when the options do not set a maximum age
+const DefaultMaxMessageAge = 5 * time.Minute
+
+// loadOffset reads the id of the last update handled, zero when unknown
+func (c *TelegramChannel) loadOffset(agentCtx *agent.AgentCtx) int {
+
+	logger := agent.GetLogger()
+
+	if agentCtx.Storage == nil {
+		return 0
+	}
+	if _, err := agentCtx.Storage.Stat(offsetFile); err != nil {
+		return 0
+	}
+	data, err := agentCtx.Storage.ReadFile(offsetFile)
+	if err != nil {
+		logger.Error("Error reading the Telegram update offset: %s", err)
+		return 0
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		logger.Warning("Invalid Telegram update offset '%s', ignoring it", strings.TrimSpace(string(data)))
+		return 0
+	}
+	return id
+}
+
+// saveOffset records the id of the last update handled
+func (c *TelegramChannel) saveOffset(agentCtx *agent.AgentCtx, id int) {
+
+	logger := agent.GetLogger()
+
+	if agentCtx.Storage == nil {
+		return
+	}
+	if err := agent.EnsureDir(agentCtx.Storage, offsetDir, 0700); err != nil {
+		logger.Error("Error creating the Telegram state folder: %s", err)
+		return
+	}
+	if err := agentCtx.Storage.WriteFile(offsetFile, []byte(strconv.Itoa(id)+"\n"), 0600); err != nil {
+		logger.Error("Error saving the Telegram update offset: %s", err)
+	}
+}
+
+// acceptUpdate returns false for the updates already handled and the messages
+// too old to be handled, the update id is recorded otherwise
+func (c *TelegramChannel) acceptUpdate(agentCtx *agent.AgentCtx, update tgbotapi.Update) bool {
+
+	logger := agent.GetLogger()
+
+	if update.UpdateID <= c.lastUpdateId {
+		logger.Debug("Dropping Telegram update %d, already handled", update.UpdateID)
+		return false
+	}
+	c.lastUpdateId = update.UpdateID
+	c.saveOffset(agentCtx, update.UpdateID)
+
+	if update.Message != nil {
+		sent := time.Unix(int64(update.Message.Date), 0)
+		if age := time.Since(sent); age > c.options.MaxMessageAge {
+			logger.Info("Ignoring Telegram message %d sent %s ago", update.Message.MessageID, age.Round(time.Second))
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package telegramChannel_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	telegramChannel "github.com/a13labs/cobot/internal/channels/telegram"
+)
+
+// nextMessage waits for a message sent to the agent
+func nextMessage(t *testing.T, agentCtx *agent.AgentCtx) agent.Message {
+	t.Helper()
+	select {
+	case msg := <-agentCtx.InputChannel:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the message")
+	}
+	return agent.Message{}
+}
+
+func TestUpdatesAreHandledOnce(t *testing.T) {
+	api := newFakeBotAPI(t)
+	storage := agent.NewMemStorageFromMap(map[string]string{"local/telegram/offset": "5\n"})
+	_, agentCtx := startChannelWithStorage(t, api, telegramChannel.Options{}, storage)
+
+	// Already handled before the restart
+	handled := textMessage(chatId, 1, "alice", "@lab restart nginx")
+	handled.UpdateID = 5
+	api.Push(handled)
+
+	// Redelivered twice
+	fresh := textMessage(chatId, 1, "alice", "@lab status")
+	fresh.UpdateID = 6
+	api.Push(fresh)
+	api.Push(fresh)
+
+	last := textMessage(chatId, 1, "alice", "@lab uptime")
+	last.UpdateID = 7
+	api.Push(last)
+
+	for _, expected := range []string{"status", "uptime"} {
+		if msg := nextMessage(t, agentCtx); msg.Text != expected {
+			t.Errorf("expected %q, got %q", expected, msg.Text)
+		}
+	}
+	select {
+	case msg := <-agentCtx.InputChannel:
+		t.Errorf("unexpected message %q", msg.Text)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	data, err := storage.ReadFile("local/telegram/offset")
+	if err != nil || strings.TrimSpace(string(data)) != "7" {
+		t.Errorf("expected offset 7, got %q: %v", data, err)
+	}
+}
+
+func TestOldMessagesAreIgnored(t *testing.T) {
+	api := newFakeBotAPI(t)
+	_, agentCtx := startChannel(t, api, telegramChannel.Options{MaxMessageAge: time.Minute})
+
+	old := textMessage(chatId, 1, "alice", "@lab restart nginx")
+	old.Message.Date = int(time.Now().Add(-time.Hour).Unix())
+	api.Push(old)
+	api.Push(textMessage(chatId, 1, "alice", "@lab status"))
+
+	if msg := nextMessage(t, agentCtx); msg.Text != "status" {
+		t.Errorf("expected the recent message, got %q", msg.Text)
+	}
+}
@@ -0,0 +1,127 @@
+package telegramChannel
+
+/*
+	In webhook mode Telegram pushes the updates to the channel instead of being
+	polled. The webhook is registered with a secret token, Telegram sends it back
+	in the X-Telegram-Bot-Api-Secret-Token header of every request and the
+	requests without it are refused. The server listens on the path of the
+	webhook URL, a reverse proxy usually terminates TLS in front of it.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+const (
+	// Largest update accepted
+	maxUpdateSize = 1 << 20
+	// Time given to the requests in flight when the channel stops
+	webhookShutdownTimeout = 5 * time.Second
+	// Updates received and not handled yet
+	webhookQueueSize = 100
+)
+
+// startWebhook registers the webhook and serves it until the context is done
+func (c *TelegramChannel) startWebhook(ctx context.Context) (<-chan tgbotapi.Update, error) {
+
+	logger := agent.GetLogger()
+
+	webhookURL, err := url.Parse(c.options.WebhookURL)
+	if err != nil {
+		return nil, err
+	}
+	path := webhookURL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	if c.options.WebhookSecret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		c.options.WebhookSecret = hex.EncodeToString(buf)
+	}
+
+	listener, err := net.Listen("tcp", c.options.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("url", webhookURL.String())
+	params.Set("secret_token", c.options.WebhookSecret)
+	params.Set("allowed_updates", `["message","callback_query"]`)
+	if _, err := c.bot.MakeRequest("setWebhook", params); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	updates := make(chan tgbotapi.Update, webhookQueueSize)
+	mux := http.NewServeMux()
+	mux.Handle(path, c.webhookHandler(updates))
+	server := &http.Server{
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("Telegram webhook server error: %s", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Telegram webhook registered for %s, listening on %s", webhookURL.Redacted(), listener.Addr())
+	return updates, nil
+}
+
+func (c *TelegramChannel) webhookHandler(updates chan<- tgbotapi.Update) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		logger := agent.GetLogger()
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		secret := r.Header.Get(secretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(c.options.WebhookSecret)) != 1 {
+			logger.Warning("Refused Telegram webhook request from %s, invalid secret token", r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var update tgbotapi.Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateSize)).Decode(&update); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case updates <- update:
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+			// Telegram delivers the update again later
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+}
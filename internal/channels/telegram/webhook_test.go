@@ -0,0 +1,67 @@
+package telegramChannel_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	telegramChannel "github.com/a13labs/cobot/internal/channels/telegram"
+)
+
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestWebhook(t *testing.T) {
+	api := newFakeBotAPI(t)
+	listen := freeAddress(t)
+	_, agentCtx := startChannel(t, api, telegramChannel.Options{
+		WebhookURL:    "https://cobot.example.com/telegram/hook",
+		Listen:        listen,
+		WebhookSecret: "s3cret",
+	})
+
+	registered := api.Expect("setWebhook")
+	if registered.Params.Get("url") != "https://cobot.example.com/telegram/hook" || registered.Params.Get("secret_token") != "s3cret" {
+		t.Fatalf("setWebhook params = %v", registered.Params)
+	}
+
+	post := func(secret string) int {
+		update := textMessage(chatId, 1, "alice", "@lab restart nginx")
+		update.UpdateID = 1
+		data, _ := json.Marshal(update)
+		req, _ := http.NewRequest(http.MethodPost, "http://"+listen+"/telegram/hook", bytes.NewReader(data))
+		req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post("wrong"); status != http.StatusUnauthorized {
+		t.Errorf("status with a wrong secret = %d", status)
+	}
+	if status := post("s3cret"); status != http.StatusOK {
+		t.Fatalf("status = %d", status)
+	}
+
+	select {
+	case msg := <-agentCtx.InputChannel:
+		if msg.User != "alice" || msg.Role != agent.RoleOperator || msg.Text != "restart nginx" {
+			t.Errorf("message = %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the update was not dispatched")
+	}
+}
@@ -0,0 +1,307 @@
+package websocketChannel
+
+/*
+	The WebSocket channel serves interactive clients on /v1/ws, each connection
+	is a conversation. Both sides exchange JSON frames:
+
+	client -> agent
+	- {"type":"message","text":"restart nginx"}
+	- {"type":"confirm","id":"<prompt id>","answer":"yes"}
+
+	agent -> client
+	- {"type":"reply","text":"..."}
+	- {"type":"prompt","id":"...","text":"Do you want to ...?","options":["yes","no"]}
+	- {"type":"action_started","action":"restart_service"}
+	- {"type":"action_finished","action":"restart_service","text":"<output>","error":"..."}
+	- {"type":"error","error":"..."}
+
+	Clients authenticate with the bearer token, either in the Authorization
+	header or, for browsers, in the token query parameter, the channel does not
+	start without one unless NoAuth is set. Browsers may only connect from the
+	page's own host unless other origins are allowed.
+
+	The messages of a connection are dispatched to the agent in the order they
+	were received.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	"golang.org/x/net/websocket"
+)
+
+const ChannelName = "websocket"
+
+// Frame types, the others are the agent event types
+const (
+	FrameMessage = "message"
+	FramePrompt  = "prompt"
+	FrameConfirm = "confirm"
+)
+
+// Time given to the connections when the channel stops
+const shutdownTimeout = 5 * time.Second
+
+// Messages of a connection waiting for the agent, the next ones are refused
+const messageQueueSize = 100
+
+var (
+	ErrConnectionNotFound = errors.New("connection not found")
+	ErrNoToken            = errors.New("no token configured, set NoAuth to accept connections without authentication")
+)
+
+// Frame is the unit exchanged on a connection
+type Frame struct {
+	Type    string   `json:"type"`
+	Id      string   `json:"id,omitempty"`
+	Text    string   `json:"text,omitempty"`
+	Action  string   `json:"action,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Options []string `json:"options,omitempty"`
+	Answer  string   `json:"answer,omitempty"`
+}
+
+// Options configures the WebSocket channel
+type Options struct {
+	// Listen is the address the server listens on, e.g. 127.0.0.1:8081
+	Listen string
+	// Token is required from every client
+	Token string
+	// NoAuth accepts the connections without authentication when no token
+	// is given, every connection is refused otherwise
+	NoAuth bool
+	// AllowedOrigins are the browser origins allowed to connect, only the
+	// origin of the server's own host is allowed when empty
+	AllowedOrigins []string
+	// Role is given to the users of the channel, they are granted nothing
+	// when it is empty
+	Role string
+}
+
+type connection struct {
+	ws *websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *connection) send(frame Frame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return websocket.JSON.Send(c.ws, frame)
+}
+
+type WebsocketChannel struct {
+	options     Options
+	agentCtx    *agent.AgentCtx
+	mu          sync.Mutex
+	connections map[string]*connection
+}
+
+func New(options Options) *WebsocketChannel {
+	return &WebsocketChannel{
+		options:     options,
+		connections: map[string]*connection{},
+	}
+}
+
+func (c *WebsocketChannel) Name() string {
+	return ChannelName
+}
+
+func (c *WebsocketChannel) Capabilities() agent.ChannelCapabilities {
+	return agent.ChannelCapabilities{}
+}
+
+func (c *WebsocketChannel) Send(conversation string, text string) error {
+	return c.send(conversation, Frame{Type: agent.EventReply, Text: text})
+}
+
+func (c *WebsocketChannel) SendEvent(conversation string, event agent.Event) error {
+	return c.send(conversation, Frame{Type: event.Type, Action: event.Action, Text: event.Text, Error: event.Error})
+}
+
+func (c *WebsocketChannel) SendPrompt(conversation string, prompt agent.Prompt) error {
+	return c.send(conversation, Frame{Type: FramePrompt, Id: prompt.Id, Text: prompt.Text, Options: prompt.Options})
+}
+
+func (c *WebsocketChannel) send(conversation string, frame Frame) error {
+	c.mu.Lock()
+	conn, ok := c.connections[conversation]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrConnectionNotFound, conversation)
+	}
+	return conn.send(frame)
+}
+
+// Start serves the WebSocket endpoint until the context is done
+func (c *WebsocketChannel) Start(ctx context.Context, agentCtx *agent.AgentCtx) error {
+
+	logger := agent.GetLogger()
+
+	if c.options.Token == "" {
+		if !c.options.NoAuth {
+			return ErrNoToken
+		}
+		logger.Warning("WebSocket channel started without a token, the connections are not authenticated")
+	}
+
+	server := &http.Server{
+		Addr:        c.options.Listen,
+		Handler:     c.Handler(agentCtx),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	logger.Info("WebSocket channel listening on %s", c.options.Listen)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		// Hijacked connections are not closed by Shutdown
+		c.mu.Lock()
+		for _, conn := range c.connections {
+			conn.ws.Close()
+		}
+		c.mu.Unlock()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+// Handler returns the handler serving /v1/ws for the given agent
+func (c *WebsocketChannel) Handler(agentCtx *agent.AgentCtx) http.Handler {
+	c.agentCtx = agentCtx
+
+	mux := http.NewServeMux()
+	mux.Handle("/v1/ws", websocket.Server{
+		Handshake: c.handshake,
+		Handler:   c.serve,
+	})
+	return mux
+}
+
+func (c *WebsocketChannel) handshake(config *websocket.Config, r *http.Request) error {
+	if c.options.Token != "" || !c.options.NoAuth {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			token = r.URL.Query().Get("token")
+		}
+		if c.options.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(c.options.Token)) != 1 {
+			return errors.New("invalid or missing token")
+		}
+	}
+
+	// Clients other than browsers send no origin
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if len(c.options.AllowedOrigins) == 0 {
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return nil
+		}
+		return fmt.Errorf("origin %s not allowed", origin)
+	}
+	for _, allowed := range c.options.AllowedOrigins {
+		if origin == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s not allowed", origin)
+}
+
+func (c *WebsocketChannel) serve(ws *websocket.Conn) {
+
+	logger := agent.GetLogger()
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		ws.Close()
+		return
+	}
+	id := hex.EncodeToString(buf)
+	conn := &connection{ws: ws}
+
+	c.mu.Lock()
+	c.connections[id] = conn
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.connections, id)
+		c.mu.Unlock()
+		ws.Close()
+	}()
+
+	// The connection keeps reading so the prompts can be answered, the
+	// messages are queued and dispatched in order
+	queue := make(chan agent.Message, messageQueueSize)
+	go func() {
+		for msg := range queue {
+			c.agentCtx.DispatchMessage(msg)
+		}
+	}()
+	defer close(queue)
+
+	for {
+		var frame Frame
+		if err := websocket.JSON.Receive(ws, &frame); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				// The invalid frame was consumed, the connection is still usable
+				conn.send(Frame{Type: agent.EventError, Error: "invalid frame"})
+				continue
+			}
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				logger.Debug("WebSocket connection %s closed: %s", id, err)
+			}
+			return
+		}
+
+		switch frame.Type {
+		case FrameMessage:
+			if strings.TrimSpace(frame.Text) == "" {
+				conn.send(Frame{Type: agent.EventError, Error: "text is required"})
+				continue
+			}
+			msg := agent.Message{
+				Channel:      ChannelName,
+				Conversation: id,
+				User:         ChannelName,
+				Role:         c.options.Role,
+				Text:         frame.Text,
+			}
+			select {
+			case queue <- msg:
+			default:
+				conn.send(Frame{Type: agent.EventError, Error: "message queue is full"})
+			}
+		case FrameConfirm:
+			if err := c.agentCtx.Answer(ChannelName, id, ChannelName, frame.Id, frame.Answer); err != nil {
+				conn.send(Frame{Type: agent.EventError, Id: frame.Id, Error: err.Error()})
+			}
+		default:
+			conn.send(Frame{Type: agent.EventError, Error: fmt.Sprintf("unknown frame type '%s'", frame.Type)})
+		}
+	}
+}
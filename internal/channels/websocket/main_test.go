@@ -0,0 +1,155 @@
+package websocketChannel_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	websocketChannel "github.com/a13labs/cobot/internal/channels/websocket"
+	"golang.org/x/net/websocket"
+)
+
+const token = "test-token"
+
+// newTestServer returns a server whose agent asks a confirmation and then
+// reports a fake action run
+func newTestServer(t *testing.T) *httptest.Server {
+	channel := websocketChannel.New(websocketChannel.Options{Token: token})
+	agentCtx := &agent.AgentCtx{InputChannel: make(chan agent.Message)}
+	if err := agentCtx.AddChannel(channel); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for msg := range agentCtx.InputChannel {
+			confirmed, err := agentCtx.Confirm(msg, "Run echo?")
+			if err != nil || !confirmed {
+				channel.Send(msg.Conversation, "cancelled")
+				continue
+			}
+			channel.SendEvent(msg.Conversation, agent.Event{Type: agent.EventActionStarted, Action: "echo"})
+			channel.SendEvent(msg.Conversation, agent.Event{Type: agent.EventActionFinished, Action: "echo", Text: msg.Text})
+			channel.Send(msg.Conversation, "done")
+		}
+	}()
+
+	server := httptest.NewServer(channel.Handler(agentCtx))
+	t.Cleanup(func() {
+		server.Close()
+		close(agentCtx.InputChannel)
+	})
+	return server
+}
+
+func dial(t *testing.T, server *httptest.Server, token string) (*websocket.Conn, error) {
+	return dialFrom(t, server, token, server.URL)
+}
+
+func dialFrom(t *testing.T, server *httptest.Server, token string, origin string) (*websocket.Conn, error) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/ws"
+	config, err := websocket.NewConfig(url, origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Header.Set("Authorization", "Bearer "+token)
+	return websocket.DialConfig(config)
+}
+
+func receive(t *testing.T, ws *websocket.Conn) websocketChannel.Frame {
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var frame websocketChannel.Frame
+	if err := websocket.JSON.Receive(ws, &frame); err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestAuthentication(t *testing.T) {
+	server := newTestServer(t)
+
+	if ws, err := dial(t, server, "wrong"); err == nil {
+		ws.Close()
+		t.Errorf("connection accepted with a wrong token")
+	}
+}
+
+func TestOtherOriginsAreRefusedByDefault(t *testing.T) {
+	server := newTestServer(t)
+
+	if ws, err := dialFrom(t, server, token, "http://evil.example.com"); err == nil {
+		ws.Close()
+		t.Errorf("connection accepted from another origin")
+	}
+	ws, err := dial(t, server, token)
+	if err != nil {
+		t.Fatalf("connection refused from the server's origin: %v", err)
+	}
+	ws.Close()
+}
+
+func TestNoTokenFailsClosed(t *testing.T) {
+	channel := websocketChannel.New(websocketChannel.Options{Listen: "127.0.0.1:0"})
+	if err := channel.Start(context.Background(), &agent.AgentCtx{}); !errors.Is(err, websocketChannel.ErrNoToken) {
+		t.Errorf("Start() error = %v; want ErrNoToken", err)
+	}
+
+	server := httptest.NewServer(channel.Handler(&agent.AgentCtx{}))
+	defer server.Close()
+	if ws, err := dial(t, server, ""); err == nil {
+		ws.Close()
+		t.Errorf("connection accepted without a token configured")
+	}
+}
+
+func TestConversation(t *testing.T) {
+	server := newTestServer(t)
+
+	ws, err := dial(t, server, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// Malformed and unknown frames are reported, the connection stays open
+	websocket.Message.Send(ws, "{not json")
+	if frame := receive(t, ws); frame.Type != agent.EventError {
+		t.Errorf("frame = %+v; want an error", frame)
+	}
+	websocket.JSON.Send(ws, websocketChannel.Frame{Type: "dance"})
+	if frame := receive(t, ws); frame.Type != agent.EventError {
+		t.Errorf("frame = %+v; want an error", frame)
+	}
+
+	websocket.JSON.Send(ws, websocketChannel.Frame{Type: websocketChannel.FrameMessage, Text: "hello"})
+	prompt := receive(t, ws)
+	if prompt.Type != websocketChannel.FramePrompt || prompt.Text != "Run echo?" || len(prompt.Options) != 2 {
+		t.Fatalf("frame = %+v; want a prompt", prompt)
+	}
+
+	websocket.JSON.Send(ws, websocketChannel.Frame{Type: websocketChannel.FrameConfirm, Id: "other", Answer: "yes"})
+	if frame := receive(t, ws); frame.Type != agent.EventError || frame.Id != "other" {
+		t.Errorf("frame = %+v; want an error for an unknown prompt", frame)
+	}
+
+	websocket.JSON.Send(ws, websocketChannel.Frame{Type: websocketChannel.FrameConfirm, Id: prompt.Id, Answer: "yes"})
+	for _, want := range []websocketChannel.Frame{
+		{Type: agent.EventActionStarted, Action: "echo"},
+		{Type: agent.EventActionFinished, Action: "echo", Text: "hello"},
+		{Type: agent.EventReply, Text: "done"},
+	} {
+		frame := receive(t, ws)
+		if frame.Type != want.Type || frame.Action != want.Action || frame.Text != want.Text {
+			t.Errorf("frame = %+v; want %+v", frame, want)
+		}
+	}
+
+	// A replayed confirmation is refused
+	websocket.JSON.Send(ws, websocketChannel.Frame{Type: websocketChannel.FrameConfirm, Id: prompt.Id, Answer: "yes"})
+	if frame := receive(t, ws); frame.Type != agent.EventError {
+		t.Errorf("frame = %+v; want an error for a replayed confirmation", frame)
+	}
+}
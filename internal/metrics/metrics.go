@@ -0,0 +1,174 @@
+package metrics
+
+/*
+	The metrics of the agent are exposed in the Prometheus format:
+	- cobot_messages_received_total{channel}
+	- cobot_pipeline_stage_duration_seconds{stage}: the time spent in each
+	  stage of the handling of a message
+	- cobot_llm_requests_total{endpoint}, cobot_llm_request_errors_total{endpoint},
+	  cobot_llm_request_duration_seconds{endpoint} and
+	  cobot_llm_tokens_total{endpoint,type}: the requests sent to the LLM server
+	- cobot_actions_total{action,outcome}, cobot_action_duration_seconds{action}
+	- cobot_queue_depth{queue}: the messages waiting to be handled
+	- cobot_channel_queue_depth{channel}: the messages received by a channel
+	  waiting to be given to the agent
+	- cobot_config_reloads_total{result}
+
+	The metrics are registered in a dedicated registry, with the Go runtime and
+	process metrics.
+*/
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "cobot"
+
+// Pipeline stages
+const (
+	StageCommand   = "command"
+	StageQuestion  = "question"
+	StageMatch     = "match"
+	StageSelect    = "select"
+	StageArguments = "arguments"
+	StageExecute   = "execute"
+)
+
+// Types of LLM tokens
+const (
+	TokensPrompt     = "prompt"
+	TokensCompletion = "completion"
+)
+
+// Registry holds the metrics of the agent
+var Registry = prometheus.NewRegistry()
+
+var (
+	MessagesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_received_total",
+		Help:      "Messages received, by channel.",
+	}, []string{"channel"})
+
+	StageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "pipeline_stage_duration_seconds",
+		Help:      "Time spent in each stage of the handling of a message.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"stage"})
+
+	LLMRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_requests_total",
+		Help:      "Requests sent to the LLM server, by endpoint.",
+	}, []string{"endpoint"})
+
+	LLMRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_request_errors_total",
+		Help:      "Requests to the LLM server that failed, by endpoint.",
+	}, []string{"endpoint"})
+
+	LLMRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "llm_request_duration_seconds",
+		Help:      "Duration of the requests to the LLM server, by endpoint.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"endpoint"})
+
+	LLMTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_tokens_total",
+		Help:      "Tokens evaluated by the LLM server, by endpoint and type (prompt or completion).",
+	}, []string{"endpoint", "type"})
+
+	Actions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "actions_total",
+		Help:      "Actions executed, by name and outcome (success, failure or refused).",
+	}, []string{"action", "outcome"})
+
+	ActionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "action_duration_seconds",
+		Help:      "Duration of the actions executed, by name.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+	}, []string{"action"})
+
+	QueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Messages waiting to be handled, by queue.",
+	}, []string{"queue"})
+
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Reloads of the configuration, by result (success or failure).",
+	}, []string{"result"})
+)
+
+var (
+	queuesMu sync.Mutex
+	queues   = map[string]func() int{}
+)
+
+// queueCollector reports the depth of the queues registered by the channels
+type queueCollector struct {
+	desc *prometheus.Desc
+}
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	queuesMu.Lock()
+	defer queuesMu.Unlock()
+	for name, length := range queues {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(length()), name)
+	}
+}
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		MessagesReceived,
+		StageDuration,
+		LLMRequests,
+		LLMRequestErrors,
+		LLMRequestDuration,
+		LLMTokens,
+		Actions,
+		ActionDuration,
+		QueueDepth,
+		ConfigReloads,
+		&queueCollector{desc: prometheus.NewDesc(namespace+"_channel_queue_depth", "Messages received by a channel waiting for the agent, by channel.", []string{"channel"}, nil)},
+	)
+}
+
+// RegisterQueue reports the length of a channel queue, a queue registered
+// again with the same name replaces the previous one
+func RegisterQueue(name string, length func() int) {
+	queuesMu.Lock()
+	defer queuesMu.Unlock()
+	queues[name] = length
+}
+
+// ObserveStage records the time spent in a pipeline stage started at the given
+// time
+func ObserveStage(stage string, start time.Time) {
+	StageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// Handler serves the metrics in the Prometheus format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
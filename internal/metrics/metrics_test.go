@@ -0,0 +1,36 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/metrics"
+)
+
+func TestHandlerExposesTheMetrics(t *testing.T) {
+	metrics.MessagesReceived.WithLabelValues("telegram").Inc()
+	metrics.ObserveStage(metrics.StageMatch, time.Now().Add(-time.Second))
+	metrics.Actions.WithLabelValues("restart", "success").Inc()
+	metrics.ConfigReloads.WithLabelValues("failure").Inc()
+	metrics.RegisterQueue("email", func() int { return 3 })
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(recorder.Body)
+
+	for _, expected := range []string{
+		`cobot_messages_received_total{channel="telegram"} 1`,
+		`cobot_pipeline_stage_duration_seconds_count{stage="match"} 1`,
+		`cobot_actions_total{action="restart",outcome="success"} 1`,
+		`cobot_config_reloads_total{result="failure"} 1`,
+		`cobot_channel_queue_depth{channel="email"} 3`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected %q in the metrics", expected)
+		}
+	}
+}
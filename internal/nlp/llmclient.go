@@ -1,18 +1,49 @@
 package nlp
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/a13labs/cobot/internal/metrics"
+	"github.com/a13labs/cobot/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LLM server endpoints, used as the metrics labels
+const (
+	endpointChat       = "chat"
+	endpointGenerate   = "generate"
+	endpointEmbeddings = "embeddings"
+)
+
+const tracerName = "github.com/a13labs/cobot/internal/nlp"
+
+var (
+	// ErrServerUnavailable is returned when the LLM server cannot be reached
+	// or does not serve the model
+	ErrServerUnavailable = errors.New("LLM server unavailable")
+	// ErrServerResponse is returned when the LLM server answers with an error
+	ErrServerResponse = errors.New("error getting response from LLMClient server")
+	// ErrInvalidResponse is returned when the answer of the model does not
+	// follow the requested schema
+	ErrInvalidResponse = errors.New("invalid response from the model")
 )
 
 type LLMClient struct {
 	Host  string
 	Port  int
 	Model string
+	// Redact, when set, is applied to every prompt sent to the server
+	Redact func(string) string
 }
 
 type LLMChatMessage struct {
@@ -74,6 +105,10 @@ type LLMBoolResult struct {
 	Result bool `json:"result"`
 }
 
+type LLMStringMapResult struct {
+	Result map[string]string `json:"result"`
+}
+
 var llmJSONSystemConstraints = []string{
 	"-Receive instructions.",
 	"-Follow provided rules.",
@@ -96,7 +131,9 @@ func composeJSONSystemInput(schema string) string {
 	return fmt.Sprintf("%s\n-Write the response using the JSON schema:'%s'.", constrainsList, schema)
 }
 
-func NewLLMClient(host string, port int, model string) *LLMClient {
+// NewLLMClient returns a client of the given server, ErrServerUnavailable is
+// returned when the server cannot be reached or does not serve the model
+func NewLLMClient(host string, port int, model string) (*LLMClient, error) {
 
 	llm := &LLMClient{
 		Host:  host,
@@ -105,7 +142,7 @@ func NewLLMClient(host string, port int, model string) *LLMClient {
 	}
 
 	if !llm.HealthCheck() {
-		return nil
+		return nil, fmt.Errorf("%w: no server listening on %s:%d", ErrServerUnavailable, host, port)
 	}
 
 	// Get the model info from the server endpoint /api/show
@@ -115,29 +152,44 @@ func NewLLMClient(host string, port int, model string) *LLMClient {
 	resp, err := http.Post(url, "application/json", strings.NewReader(request_body))
 
 	if err != nil {
-		return nil
-	}
-
-	if resp == nil || resp.StatusCode != http.StatusOK {
-		return nil
+		return nil, fmt.Errorf("%w: %w", ErrServerUnavailable, err)
 	}
 
 	defer resp.Body.Close()
 
-	return llm
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: model %s not found (%s)", ErrServerUnavailable, llm.Model, resp.Status)
+	}
+
+	return llm, nil
 }
 
 func (llm *LLMClient) HealthCheck() bool {
 	// Check if the LLMClient server is running but opening a connection to it
-	conn := fmt.Sprintf("%s:%d", llm.Host, llm.Port)
-	_, err := net.Dial("tcp", conn)
-	return err == nil
+	conn, err := net.Dial("tcp", net.JoinHostPort(llm.Host, strconv.Itoa(llm.Port)))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
 }
 
-func (llm *LLMClient) RequestChat(messages []LLMChatMessage) (*LLMChatResponseNoStream, error) {
+func (llm *LLMClient) RequestChat(ctx context.Context, messages []LLMChatMessage) (msg *LLMChatResponseNoStream, err error) {
+
+	ctx, span := llm.startSpan(ctx, endpointChat)
+	defer endSpan(span, &err)
+	defer observeRequest(endpointChat, time.Now(), &err)
 
 	url := fmt.Sprintf("http://%s:%d/api/chat", llm.Host, llm.Port)
 
+	if llm.Redact != nil {
+		redacted := make([]LLMChatMessage, len(messages))
+		for i, m := range messages {
+			redacted[i] = LLMChatMessage{Role: m.Role, Content: llm.Redact(m.Content)}
+		}
+		messages = redacted
+	}
+
 	requestBodyBytes, err := json.Marshal(messages)
 	if err != nil {
 		return nil, err
@@ -145,72 +197,82 @@ func (llm *LLMClient) RequestChat(messages []LLMChatMessage) (*LLMChatResponseNo
 
 	request_body := fmt.Sprintf(`{"model": "%s", "messages": %s, "stream" : false, "format" : "json"}`, llm.Model, string(requestBodyBytes))
 
-	resp, err := http.Post(url, "application/json", strings.NewReader(request_body))
+	resp, err := post(ctx, url, request_body)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if resp == nil || resp.StatusCode != http.StatusOK {
-		return nil, errors.New("error getting response from LLMClient server")
-	}
-
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrServerResponse, resp.Status)
+	}
+
 	// Read the response from the server
-	msg := &LLMChatResponseNoStream{}
+	msg = &LLMChatResponseNoStream{}
 	err = json.NewDecoder(resp.Body).Decode(&msg)
 	if err != nil {
 		return nil, err
 	}
+	observeTokens(span, endpointChat, msg.PromptEvalCount, msg.EvalCount)
 
 	return msg, nil
 }
 
-func (llm *LLMClient) RequestCompletion(request *LLMCompletionRequest) (*LLMCompletionResponseNoStream, error) {
+func (llm *LLMClient) RequestCompletion(ctx context.Context, request *LLMCompletionRequest) (msg *LLMCompletionResponseNoStream, err error) {
+
+	ctx, span := llm.startSpan(ctx, endpointGenerate)
+	defer endSpan(span, &err)
+	defer observeRequest(endpointGenerate, time.Now(), &err)
 
 	url := fmt.Sprintf("http://%s:%d/api/generate", llm.Host, llm.Port)
 
-	request_body := fmt.Sprintf(`{"model": "%s", "prompt": "%s"}`, llm.Model, request.Prompt)
-	resp, err := http.Post(url, "application/json", strings.NewReader(request_body))
+	request_body := fmt.Sprintf(`{"model": "%s", "prompt": "%s"}`, llm.Model, llm.redact(request.Prompt))
+	resp, err := post(ctx, url, request_body)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if resp == nil || resp.StatusCode != http.StatusOK {
-		return nil, errors.New("error getting response from LLMClient server")
-	}
-
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrServerResponse, resp.Status)
+	}
+
 	// Read the response from the server
-	msg := &LLMCompletionResponseNoStream{}
+	msg = &LLMCompletionResponseNoStream{}
 	err = json.NewDecoder(resp.Body).Decode(&msg)
 	if err != nil {
 		return nil, err
 	}
+	observeTokens(span, endpointGenerate, msg.PromptEvalCount, msg.EvalCount)
 
 	return msg, nil
 }
 
-func (llm *LLMClient) EmbeddingRequest(request *LLMEmbeddingRequest) ([]float64, error) {
+func (llm *LLMClient) EmbeddingRequest(ctx context.Context, request *LLMEmbeddingRequest) (embeddings []float64, err error) {
+
+	ctx, span := llm.startSpan(ctx, endpointEmbeddings)
+	defer endSpan(span, &err)
+	defer observeRequest(endpointEmbeddings, time.Now(), &err)
 
 	url := fmt.Sprintf("http://%s:%d/api/embeddings", llm.Host, llm.Port)
 
-	request_body := fmt.Sprintf(`{"model": "%s", "prompt": "%s"}`, llm.Model, request.Prompt)
-	resp, err := http.Post(url, "application/json", strings.NewReader(request_body))
+	request_body := fmt.Sprintf(`{"model": "%s", "prompt": "%s"}`, llm.Model, llm.redact(request.Prompt))
+	resp, err := post(ctx, url, request_body)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if resp == nil || resp.StatusCode != http.StatusOK {
-		return nil, errors.New("error getting response from LLMClient server")
-	}
-
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrServerResponse, resp.Status)
+	}
+
 	// Read the response from the server
 	msg := &LLMEmbeddingResponse{}
 	err = json.NewDecoder(resp.Body).Decode(&msg)
@@ -221,68 +283,143 @@ func (llm *LLMClient) EmbeddingRequest(request *LLMEmbeddingRequest) ([]float64,
 	return msg.Embeddings, nil
 }
 
-func (llm *LLMClient) MessageRequest(instructions string) (string, error) {
+// observeRequest records a request to the LLM server in the metrics
+func observeRequest(endpoint string, start time.Time, err *error) {
+	metrics.LLMRequests.WithLabelValues(endpoint).Inc()
+	metrics.LLMRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		metrics.LLMRequestErrors.WithLabelValues(endpoint).Inc()
+	}
+}
+
+// observeTokens records the tokens of a request in the metrics and its span
+func observeTokens(span trace.Span, endpoint string, prompt int, completion int) {
+	metrics.LLMTokens.WithLabelValues(endpoint, metrics.TokensPrompt).Add(float64(prompt))
+	metrics.LLMTokens.WithLabelValues(endpoint, metrics.TokensCompletion).Add(float64(completion))
+	span.SetAttributes(
+		attribute.Int(tracing.AttrPromptTokens, prompt),
+		attribute.Int(tracing.AttrCompletionTokens, completion),
+	)
+}
+
+// startSpan starts the span of a request to the LLM server
+func (llm *LLMClient) startSpan(ctx context.Context, endpoint string) (context.Context, trace.Span) {
+	return tracing.Tracer(tracerName).Start(ctx, "llm."+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String(tracing.AttrModel, llm.Model),
+			attribute.String(tracing.AttrEndpoint, endpoint),
+		))
+}
+
+// endSpan ends a span, recording the error of the request if any
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+// post sends a JSON body to the LLM server, the request is cancelled with the
+// context
+func post(ctx context.Context, url string, body string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return http.DefaultClient.Do(req)
+}
+
+func (llm *LLMClient) redact(text string) string {
+	if llm.Redact == nil {
+		return text
+	}
+	return llm.Redact(text)
+}
+
+func (llm *LLMClient) MessageRequest(ctx context.Context, instructions string) (string, error) {
 
 	schema := "{\"result\":string}"
-	msg, err := llm.JSONRequest(schema, instructions)
+	msg, err := llm.JSONRequest(ctx, schema, instructions)
 	if err != nil {
 		return "", err
 	}
 	jsonResult := LLMStringResult{}
 	err = json.Unmarshal([]byte(msg), &jsonResult)
 	if err != nil {
-		return "", nil
+		return "", fmt.Errorf("%w: %w", ErrInvalidResponse, err)
 	}
 	return jsonResult.Result, nil
 }
 
-func (llm *LLMClient) IntListRequest(instructions string) ([]int, error) {
+func (llm *LLMClient) IntListRequest(ctx context.Context, instructions string) ([]int, error) {
 
 	schema := "{\"result\":[int]}"
-	msg, err := llm.JSONRequest(schema, instructions)
+	msg, err := llm.JSONRequest(ctx, schema, instructions)
 	if err != nil {
 		return nil, err
 	}
 	jsonResult := LLMIntListResult{}
 	err = json.Unmarshal([]byte(msg), &jsonResult)
 	if err != nil {
-		return nil, nil
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
 	}
 	return jsonResult.Result, nil
 }
 
-func (llm *LLMClient) StringListRequest(instructions string) ([]string, error) {
+func (llm *LLMClient) StringListRequest(ctx context.Context, instructions string) ([]string, error) {
 
 	schema := "{\"result\":[string]}"
-	msg, err := llm.JSONRequest(schema, instructions)
+	msg, err := llm.JSONRequest(ctx, schema, instructions)
 	if err != nil {
 		return nil, err
 	}
 	jsonResult := LLMStringListResult{}
 	err = json.Unmarshal([]byte(msg), &jsonResult)
 	if err != nil {
-		return nil, nil
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+	return jsonResult.Result, nil
+}
+
+func (llm *LLMClient) StringMapRequest(ctx context.Context, instructions string, keys []string) (map[string]string, error) {
+
+	fields := make([]string, len(keys))
+	for i, key := range keys {
+		fields[i] = fmt.Sprintf("\"%s\":string", key)
+	}
+	schema := fmt.Sprintf("{\"result\":{%s}}", strings.Join(fields, ","))
+	msg, err := llm.JSONRequest(ctx, schema, instructions)
+	if err != nil {
+		return nil, err
+	}
+	jsonResult := LLMStringMapResult{}
+	err = json.Unmarshal([]byte(msg), &jsonResult)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
 	}
 	return jsonResult.Result, nil
 }
 
-func (llm *LLMClient) BoolRequest(instructions string) (bool, error) {
+func (llm *LLMClient) BoolRequest(ctx context.Context, instructions string) (bool, error) {
 
 	schema := "{\"result\":boolean}"
-	msg, err := llm.JSONRequest(schema, instructions)
+	msg, err := llm.JSONRequest(ctx, schema, instructions)
 	if err != nil {
 		return false, err
 	}
 	jsonResult := LLMBoolResult{}
 	err = json.Unmarshal([]byte(msg), &jsonResult)
 	if err != nil {
-		return false, nil
+		return false, fmt.Errorf("%w: %w", ErrInvalidResponse, err)
 	}
 	return jsonResult.Result, nil
 }
 
-func (llm *LLMClient) JSONRequest(schema string, instructions string) (string, error) {
-	llmMessage, err := llm.RequestChat([]LLMChatMessage{
+func (llm *LLMClient) JSONRequest(ctx context.Context, schema string, instructions string) (string, error) {
+	llmMessage, err := llm.RequestChat(ctx, []LLMChatMessage{
 		{
 			Role:    "System",
 			Content: composeJSONSystemInput(schema),
@@ -0,0 +1,81 @@
+package shellPlugin
+
+/*
+	The shell plugin runs a command using the system shell. Parameters:
+	- command: the command line to run (required)
+	- privileged: run the command through sudo (optional, default false)
+
+	The values substituted in the command, arguments, knowledge base entries and
+	secrets, are single quoted, they are always a single word for the shell. The
+	placeholders must not be written inside quotes.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+)
+
+const (
+	// waitDelay is the time given to the command to stop once cancelled, it
+	// is killed and its output closed afterwards
+	waitDelay = 2 * time.Second
+
+	// privilegedWrapper runs the command given as argument and terminates its
+	// process group when it receives SIGTERM
+	privilegedWrapper = `trap 'trap - TERM; kill -TERM 0' TERM; sh -c "$1" & wait $!`
+)
+
+type shellPlugin struct{}
+
+func (p *shellPlugin) Execute(ctx context.Context, params map[string]interface{}) (string, error) {
+
+	command, ok := params["command"].(string)
+	if !ok || strings.TrimSpace(command) == "" {
+		return "", errors.New("shell plugin requires a command parameter")
+	}
+
+	privileged := false
+	if value, exist := params["privileged"]; exist {
+		privileged, ok = value.(bool)
+		if !ok {
+			return "", fmt.Errorf("shell plugin privileged parameter must be a boolean, got %v", value)
+		}
+	}
+
+	logger := agent.GetLogger().With("plugin", "shell", "privileged", privileged)
+	logger.Debug("Running command %s", command)
+
+	var cmd *exec.Cmd
+	if privileged {
+		// The signal forwarded by sudo is relayed to the process group of the
+		// command, the user can't signal the processes running as root
+		cmd = exec.CommandContext(ctx, "sudo", "-n", "sh", "-c", privilegedWrapper, "sh", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	killProcessGroup(cmd)
+	cmd.WaitDelay = waitDelay
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		logger.Debug("Command %s failed: %s", command, err)
+		return string(output), err
+	}
+	return string(output), nil
+}
+
+// QuoteArgument quotes a value for the shell, the single quotes it holds are
+// closed, escaped and opened again
+func (p *shellPlugin) QuoteArgument(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func init() {
+	agent.RegisterPlugin("shell", &shellPlugin{})
+}
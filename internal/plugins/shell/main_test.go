@@ -0,0 +1,50 @@
+package shellPlugin_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a13labs/cobot/internal/agent"
+	_ "github.com/a13labs/cobot/internal/plugins/shell"
+)
+
+func TestQuotedArgumentIsASingleWord(t *testing.T) {
+	plugin, ok := agent.GetPlugin("shell")
+	if !ok {
+		t.Fatal("shell plugin not registered")
+	}
+	quoter, ok := plugin.(agent.ArgumentQuoter)
+	if !ok {
+		t.Fatal("shell plugin does not quote the arguments")
+	}
+
+	value := "nginx'; echo injected; '$(id)"
+	output, err := plugin.Execute(context.Background(), map[string]interface{}{"command": "printf %s " + quoter.QuoteArgument(value)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != value {
+		t.Errorf("output = %q; want %q", output, value)
+	}
+}
+
+func TestCancelKillsTheChildren(t *testing.T) {
+	plugin, ok := agent.GetPlugin("shell")
+	if !ok {
+		t.Fatal("shell plugin not registered")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	output, err := plugin.Execute(ctx, map[string]interface{}{"command": "sleep 3; echo done"})
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Execute() returned after %s; want it to return once cancelled", elapsed)
+	}
+	if err == nil || strings.Contains(output, "done") {
+		t.Errorf("Execute() = %q, %v; want the command cancelled", output, err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package shellPlugin
+
+import "os/exec"
+
+// killProcessGroup is not supported, the cancellation only kills the shell
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package shellPlugin
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroup runs the command in its own process group and makes the
+// cancellation terminate the whole group, the children of the shell included.
+// sudo forwards the signal to the command it runs.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+}
@@ -0,0 +1,132 @@
+package tracing
+
+/*
+	The agent records OpenTelemetry spans for the handling of each message:
+
+	agent.process (channel, session, user, request id)
+	├── llm.chat (model, endpoint, prompt and completion tokens)
+	└── agent.action (action, outcome)
+	    └── plugin.execute (plugin)
+
+	The spans are dropped unless an exporter is configured: OTLP over HTTP to a
+	collector, or JSON lines written to stdout or to a file for offline use.
+*/
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+const ServiceName = "cobot"
+
+// Attributes of the spans
+const (
+	AttrChannel          = "cobot.channel"
+	AttrSession          = "cobot.session"
+	AttrUser             = "cobot.user"
+	AttrRequestId        = "cobot.request_id"
+	AttrAction           = "cobot.action"
+	AttrOutcome          = "cobot.outcome"
+	AttrPlugin           = "cobot.plugin"
+	AttrModel            = "llm.model"
+	AttrEndpoint         = "llm.endpoint"
+	AttrPromptTokens     = "llm.prompt_tokens"
+	AttrCompletionTokens = "llm.completion_tokens"
+)
+
+// Options configures where the spans are exported
+type Options struct {
+	// Exporter is none, otlp, stdout or file, none when empty
+	Exporter string
+	// Endpoint is the host:port of the OTLP HTTP collector, the OTLP
+	// environment variables apply when empty
+	Endpoint string
+	// Insecure disables TLS for the OTLP collector
+	Insecure bool
+	// File receives the spans with the file exporter
+	File string
+}
+
+// Tracer returns the tracer of the given package
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// Setup installs the tracer provider exporting the spans as configured, the
+// returned function flushes and stops it.
+func Setup(ctx context.Context, options Options) (func(context.Context) error, error) {
+
+	var exporter sdktrace.SpanExporter
+	var closer io.Closer
+	var err error
+
+	switch options.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		var clientOptions []otlptracehttp.Option
+		if options.Endpoint != "" {
+			clientOptions = append(clientOptions, otlptracehttp.WithEndpoint(options.Endpoint))
+		}
+		if options.Insecure {
+			clientOptions = append(clientOptions, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, clientOptions...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		if options.File == "" {
+			return nil, fmt.Errorf("a file is required by the %s trace exporter", ExporterFile)
+		}
+		if err := os.MkdirAll(filepath.Dir(options.File), 0700); err != nil {
+			return nil, err
+		}
+		var file *os.File
+		file, err = os.OpenFile(options.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err == nil {
+			closer = file
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		}
+	default:
+		return nil, fmt.Errorf("unknown trace exporter '%s'", options.Exporter)
+	}
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}
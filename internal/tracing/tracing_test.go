@@ -0,0 +1,42 @@
+package tracing_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/a13labs/cobot/internal/tracing"
+)
+
+func TestFileExporter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "local", "traces.json")
+
+	shutdown, err := tracing.Setup(context.Background(), tracing.Options{Exporter: tracing.ExporterFile, File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := tracing.Tracer("test").Start(context.Background(), "agent.process")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Name":"agent.process"`) || !strings.Contains(string(data), tracing.ServiceName) {
+		t.Errorf("unexpected traces:\n%s", data)
+	}
+}
+
+func TestUnknownExporter(t *testing.T) {
+	if _, err := tracing.Setup(context.Background(), tracing.Options{Exporter: "zipkin"}); err == nil {
+		t.Error("expected an error for an unknown exporter")
+	}
+	if _, err := tracing.Setup(context.Background(), tracing.Options{Exporter: tracing.ExporterFile}); err == nil {
+		t.Error("expected an error for the file exporter without a file")
+	}
+}
@@ -23,8 +23,19 @@ package main
 
 import (
 	"github.com/a13labs/cobot/cli"
+	_ "github.com/a13labs/cobot/cli/audit"
+	_ "github.com/a13labs/cobot/cli/config"
 	_ "github.com/a13labs/cobot/cli/console"
+	_ "github.com/a13labs/cobot/cli/ctl"
+	_ "github.com/a13labs/cobot/cli/email"
+	_ "github.com/a13labs/cobot/cli/http"
+	_ "github.com/a13labs/cobot/cli/mqtt"
+	_ "github.com/a13labs/cobot/cli/secrets"
+	_ "github.com/a13labs/cobot/cli/service"
 	_ "github.com/a13labs/cobot/cli/telegram"
+	_ "github.com/a13labs/cobot/cli/validate"
+	_ "github.com/a13labs/cobot/cli/websocket"
+	_ "github.com/a13labs/cobot/internal/plugins/shell"
 )
 
 func main() {